@@ -41,15 +41,20 @@ func GetHttpClient() *http.Client {
 
 func GetWebsocketDialer() *ws.Dialer {
 	once2.Do(func() {
-		websocketDialer = &ws.Dialer{
-			Proxy:             http.ProxyFromEnvironment,
-			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
-			HandshakeTimeout:  45 * time.Second,
-			ReadBufferSize:    0,            // default is 4096
-			WriteBufferSize:   0,            // default is 4096
-			WriteBufferPool:   &sync.Pool{}, // java version do not use pool
-			EnableCompression: false,
-		}
+		websocketDialer = NewWebsocketDialer(&tls.Config{InsecureSkipVerify: true})
 	})
 	return websocketDialer
 }
+
+// NewWebsocketDialer creates a dialer with its own tls config, e.g. one presenting a client certificate to the router
+func NewWebsocketDialer(tlsConfig *tls.Config) *ws.Dialer {
+	return &ws.Dialer{
+		Proxy:             http.ProxyFromEnvironment,
+		TLSClientConfig:   tlsConfig,
+		HandshakeTimeout:  45 * time.Second,
+		ReadBufferSize:    0,            // default is 4096
+		WriteBufferSize:   0,            // default is 4096
+		WriteBufferPool:   &sync.Pool{}, // java version do not use pool
+		EnableCompression: false,
+	}
+}
@@ -2,6 +2,7 @@ package connector
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -9,6 +10,7 @@ import (
 
 	ws "github.com/gorilla/websocket"
 	. "github.com/torchcc/crank4go/connector/plugin"
+	"github.com/torchcc/crank4go/credential"
 	ptc "github.com/torchcc/crank4go/protocol"
 	. "github.com/torchcc/crank4go/util"
 )
@@ -35,6 +37,8 @@ type Connector struct {
 	targetServiceName   string
 	connectorInstanceID string
 	componentName       string
+	versionLabel        string
+	tokenProvider       credential.TokenProvider
 	connectorPlugins    []ConnectorPlugin
 	state               State
 }
@@ -67,11 +71,44 @@ func NewConnector(routerURIs []*url.URL, targetURI *url.URL, targetServiceName s
 func (c *Connector) ConnMonitor() *ConnectionMonitor {
 	return c.connMonitor
 }
+
+func (c *Connector) VersionLabel() string {
+	return c.versionLabel
+}
+
+// SetVersionLabel must be called before Start
+func (c *Connector) SetVersionLabel(versionLabel string) *Connector {
+	c.versionLabel = versionLabel
+	return c
+}
+
+func (c *Connector) TokenProvider() credential.TokenProvider {
+	return c.tokenProvider
+}
+
+// SetTokenProvider must be called before Start
+func (c *Connector) SetTokenProvider(tokenProvider credential.TokenProvider) *Connector {
+	c.tokenProvider = tokenProvider
+	return c
+}
+
+// SetTLSClientConfig dials the router with its own tls config instead of the shared dialer, must be called before Start
+func (c *Connector) SetTLSClientConfig(tlsClientConfig *tls.Config) *Connector {
+	if tlsClientConfig != nil {
+		c.websocketDialer = NewWebsocketDialer(tlsClientConfig)
+	}
+	return c
+}
+
 func (c *Connector) Start() {
 	for _, routerURI := range c.routerURIs {
+		rawQuery := fmt.Sprintf("connectorInstanceID=%s&componentName=%s", c.connectorInstanceID, c.componentName)
+		if c.versionLabel != "" {
+			rawQuery += "&versionLabel=" + url.QueryEscape(c.versionLabel)
+		}
 		registerURI := routerURI.ResolveReference(&url.URL{
 			Path:     "register/",
-			RawQuery: fmt.Sprintf("connectorInstanceID=%s&componentName=%s", c.connectorInstanceID, c.componentName),
+			RawQuery: rawQuery,
 		})
 		LOG.Infof("Connecting to %s", registerURI.String())
 		for i := 0; i < c.slidingWindowSize; i++ {
@@ -112,6 +149,15 @@ func (c *Connector) connectToRouter(registerURI *url.URL, connInfo *ConnectionIn
 		headers.Add("CrankerProtocol", ptc.CrankerProtocolVersion10)
 		headers.Add("Route", c.targetServiceName)
 		go func() {
+			if c.tokenProvider != nil {
+				token, err := c.tokenProvider.Token()
+				if err != nil {
+					LOG.Errorf("cannot get registration token for %s, err: %s", registerURI, err.Error())
+					socket.OnWebsocketError(err)
+					return
+				}
+				headers.Set("Authorization", "Bearer "+token)
+			}
 			if conn, _, err := c.websocketDialer.Dial(registerURI.String(), headers); err != nil {
 				LOG.Errorf("cannot replace socket for %s, err: %s", registerURI, err.Error())
 				socket.OnWebsocketError(err)
@@ -197,6 +243,7 @@ func CreateAndStartConnector(c *ConnectorConfig) *Connector {
 	connMonitor := NewConnectionMonitor(c.dataPublishHandlers)
 	connector := NewConnector(c.RouterURIs(), c.TargetURI(), c.TargetServiceName(), c.SlidingWindowSize(),
 		connMonitor, c.InstanceID(), c.ComponentName(), c.Plugins())
+	connector.SetVersionLabel(c.VersionLabel()).SetTokenProvider(c.TokenProvider()).SetTLSClientConfig(c.TLSClientConfig())
 	connector.Start()
 	if c.IsShutDownHookAdded() {
 		addShutDownHook(connector.ShutDown)
@@ -1,10 +1,13 @@
 package connector
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"net/url"
 
 	"github.com/google/uuid"
 	"github.com/torchcc/crank4go/connector/plugin"
+	"github.com/torchcc/crank4go/credential"
 	"github.com/torchcc/crank4go/util"
 )
 
@@ -14,6 +17,9 @@ type ConnectorConfig struct {
 	targetServiceName   string
 	instanceID          string
 	componentName       string
+	versionLabel        string
+	tokenProvider       credential.TokenProvider
+	tlsClientConfig     *tls.Config
 	plugins             []plugin.ConnectorPlugin
 	routerURIs          []*url.URL
 	dataPublishHandlers []util.DataPublishHandler
@@ -98,3 +104,45 @@ func (c *ConnectorConfig) ComponentName() string {
 func (c *ConnectorConfig) InstanceID() string {
 	return c.instanceID
 }
+
+func (c *ConnectorConfig) VersionLabel() string {
+	return c.versionLabel
+}
+
+// SetVersionLabel the version of the target service, e.g. "v2.3.1" or a git sha. it is sent to the router on registration,
+// so that all connectors of a version can be dark launched at once
+func (c *ConnectorConfig) SetVersionLabel(versionLabel string) *ConnectorConfig {
+	c.versionLabel = versionLabel
+	return c
+}
+
+func (c *ConnectorConfig) TokenProvider() credential.TokenProvider {
+	return c.tokenProvider
+}
+
+// SetTokenProvider the token is asked for every time a websocket is dialed and sent as a bearer token, so a rotated token
+// is picked up without restarting. e.g. credential.NewFileTokenProvider(path) or credential.NewHmacTokenProvider(secret, name, routes, ttl)
+func (c *ConnectorConfig) SetTokenProvider(tokenProvider credential.TokenProvider) *ConnectorConfig {
+	c.tokenProvider = tokenProvider
+	return c
+}
+
+func (c *ConnectorConfig) TLSClientConfig() *tls.Config {
+	return c.tlsClientConfig
+}
+
+// SetTLSClientConfig the tls config to dial the router with, by default the router certificate is not verified
+func (c *ConnectorConfig) SetTLSClientConfig(tlsClientConfig *tls.Config) *ConnectorConfig {
+	c.tlsClientConfig = tlsClientConfig
+	return c
+}
+
+// SetClientCertificate presents the client certificate to routers which require mutual TLS, the certificate files are
+// read again once they are renewed. the router certificate is verified against routerCAs, or the system roots if it is nil
+func (c *ConnectorConfig) SetClientCertificate(certFiles *credential.CertificateFiles, routerCAs *x509.CertPool) *ConnectorConfig {
+	return c.SetTLSClientConfig(&tls.Config{
+		GetClientCertificate: certFiles.GetClientCertificate,
+		RootCAs:              routerCAs,
+		MinVersion:           tls.VersionTLS12,
+	})
+}
@@ -0,0 +1,97 @@
+package credential
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertPolicy maps the identities found in client certificates to the routes they may register.
+// an identity is the subject common name or any DNS, URI or email SAN of the certificate, "*" matches every verified certificate
+type CertPolicy struct {
+	lock  sync.RWMutex
+	rules map[string][]string
+}
+
+func NewCertPolicy() *CertPolicy {
+	return &CertPolicy{rules: make(map[string][]string)}
+}
+
+// Allow lets the certificate identity register the routes, "*" as route allows every route. calling it again for an identity adds routes
+func (p *CertPolicy) Allow(identity string, routes ...string) *CertPolicy {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.rules[identity] = append(p.rules[identity], routes...)
+	return p
+}
+
+// Identify returns the identity of the certificate with the routes of all the rules matching any of its names
+func (p *CertPolicy) Identify(cert *x509.Certificate) *Identity {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	identity := &Identity{Subject: cert.Subject.CommonName, Issuer: cert.Issuer.CommonName, ExpiresAt: cert.NotAfter, Routes: make([]string, 0, 4)}
+	identity.Routes = append(identity.Routes, p.rules["*"]...)
+	for _, name := range CertNames(cert) {
+		identity.Routes = append(identity.Routes, p.rules[name]...)
+	}
+	return identity
+}
+
+// CertNames the names a certificate is identified by: the subject common name, DNS SANs, URI SANs and email SANs
+func CertNames(cert *x509.Certificate) []string {
+	names := make([]string, 0, 1+len(cert.DNSNames)+len(cert.URIs)+len(cert.EmailAddresses))
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return append(names, cert.EmailAddresses...)
+}
+
+// CertificateFiles loads a client certificate and its key from PEM files, and loads them again once the files are modified,
+// so the certificate can be renewed without restarting. use GetClientCertificate as tls.Config.GetClientCertificate
+type CertificateFiles struct {
+	certFile string
+	keyFile  string
+	lock     sync.Mutex
+	modTime  time.Time
+	cert     *tls.Certificate
+}
+
+func NewCertificateFiles(certFile, keyFile string) (*CertificateFiles, error) {
+	f := &CertificateFiles{certFile: certFile, keyFile: keyFile}
+	if _, err := f.GetClientCertificate(nil); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *CertificateFiles) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	certInfo, err := os.Stat(f.certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyInfo, err := os.Stat(f.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	modTime := certInfo.ModTime()
+	if keyInfo.ModTime().After(modTime) {
+		modTime = keyInfo.ModTime()
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.cert != nil && modTime.Equal(f.modTime) {
+		return f.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	f.cert, f.modTime = &cert, modTime
+	return f.cert, nil
+}
@@ -0,0 +1,35 @@
+package credential
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+)
+
+func TestCertPolicyIdentify(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://cluster.local/ns/payments/sa/connector")
+	policy := NewCertPolicy().
+		Allow("orders.connectors.internal", "orders").
+		Allow("spiffe://cluster.local/ns/payments/sa/connector", "payments", "refunds").
+		Allow("ops-connector", "*")
+	tests := []struct {
+		name  string
+		cert  *x509.Certificate
+		route string
+		want  bool
+	}{
+		{name: "dnsSanOwnsRoute", cert: &x509.Certificate{DNSNames: []string{"orders.connectors.internal"}}, route: "orders", want: true},
+		{name: "dnsSanDoesNotOwnRoute", cert: &x509.Certificate{DNSNames: []string{"orders.connectors.internal"}}, route: "payments", want: false},
+		{name: "uriSan", cert: &x509.Certificate{URIs: []*url.URL{spiffe}}, route: "refunds", want: true},
+		{name: "commonNameWildcard", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "ops-connector"}}, route: "", want: true},
+		{name: "unknown", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "intruder"}}, route: "orders", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Identify(tt.cert).CanRegister(tt.route); got != tt.want {
+				t.Errorf("CanRegister(%q) = %v, want %v", tt.route, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package credential
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// the leeway applied to exp and nbf, to tolerate clock skew between the connector and the router
+const clockSkew = 30 * time.Second
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+)
+
+var (
+	ErrMissingToken     = errors.New("missing registration token")
+	ErrMalformedToken   = errors.New("malformed registration token")
+	ErrInvalidSignature = errors.New("invalid registration token signature")
+	ErrTokenExpired     = errors.New("registration token expired")
+	ErrTokenNotYetValid = errors.New("registration token not yet valid")
+)
+
+// Claims the payload of a registration token, which is a compact JWT.
+// Routes lists the routes the holder may register, "*" allows every route. Roles grants access to the admin API
+type Claims struct {
+	Subject   string   `json:"sub,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Routes    []string `json:"routes,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// Identity who a verified token belongs to
+type Identity struct {
+	Subject   string
+	Issuer    string
+	Routes    []string
+	Roles     []string
+	ExpiresAt time.Time // zero value means the token never expires
+}
+
+// CanRegister judges if the identity owns the route, the catch-all route is only owned through "*"
+func (i *Identity) CanRegister(route string) bool {
+	for _, r := range i.Routes {
+		if r == "*" || r == route {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Identity) String() string {
+	return fmt.Sprintf("Identity{subject=%s, issuer=%s, routes=%v}", i.Subject, i.Issuer, i.Routes)
+}
+
+// NewHmacToken mints a HS256 signed token for the subject, a ttl <= 0 makes a token that never expires
+func NewHmacToken(secret []byte, subject string, routes []string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := &Claims{Subject: subject, Routes: routes, IssuedAt: now.Unix()}
+	if ttl > 0 {
+		claims.ExpiresAt = now.Add(ttl).Unix()
+	}
+	return Sign(claims, AlgHS256, "", func(signingInput []byte) ([]byte, error) {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signingInput)
+		return mac.Sum(nil), nil
+	})
+}
+
+// NewRsaToken mints a RS256 signed token, kid tells the router which of its public keys to verify it with
+func NewRsaToken(key *rsa.PrivateKey, kid string, claims *Claims) (string, error) {
+	return Sign(claims, AlgRS256, kid, func(signingInput []byte) ([]byte, error) {
+		digest := sha256.Sum256(signingInput)
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	})
+}
+
+// NewEcdsaToken mints a ES256 signed token, kid tells the router which of its public keys to verify it with
+func NewEcdsaToken(key *ecdsa.PrivateKey, kid string, claims *Claims) (string, error) {
+	return Sign(claims, AlgES256, kid, func(signingInput []byte) ([]byte, error) {
+		digest := sha256.Sum256(signingInput)
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	})
+}
+
+// Sign encodes the claims as a compact JWT signed by sign
+func Sign(claims *Claims, alg, kid string, sign func(signingInput []byte) ([]byte, error)) (string, error) {
+	h, err := json.Marshal(&header{Alg: alg, Typ: "JWT", Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sig, err := sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// parsedToken a token whose signature is not verified yet
+type parsedToken struct {
+	header       header
+	claims       Claims
+	signingInput []byte
+	signature    []byte
+}
+
+func parse(token string) (*parsedToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+	t := &parsedToken{signingInput: []byte(parts[0] + "." + parts[1])}
+	h, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(h, &t.header) != nil {
+		return nil, ErrMalformedToken
+	}
+	c, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(c, &t.claims) != nil {
+		return nil, ErrMalformedToken
+	}
+	if t.signature, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return nil, ErrMalformedToken
+	}
+	return t, nil
+}
+
+func (t *parsedToken) verifyHmac(secret []byte) bool {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(t.signingInput)
+	return hmac.Equal(mac.Sum(nil), t.signature)
+}
+
+func (t *parsedToken) verifyRsa(key *rsa.PublicKey) bool {
+	digest := sha256.Sum256(t.signingInput)
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], t.signature) == nil
+}
+
+func (t *parsedToken) verifyEcdsa(key *ecdsa.PublicKey) bool {
+	if len(t.signature) != 64 {
+		return false
+	}
+	digest := sha256.Sum256(t.signingInput)
+	r, s := new(big.Int).SetBytes(t.signature[:32]), new(big.Int).SetBytes(t.signature[32:])
+	return ecdsa.Verify(key, digest[:], r, s)
+}
+
+// identity checks the time claims and converts the claims to an Identity
+func (t *parsedToken) identity(now time.Time) (*Identity, error) {
+	if t.claims.ExpiresAt != 0 && now.After(time.Unix(t.claims.ExpiresAt, 0).Add(clockSkew)) {
+		return nil, ErrTokenExpired
+	}
+	if t.claims.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(t.claims.NotBefore, 0)) {
+		return nil, ErrTokenNotYetValid
+	}
+	identity := &Identity{Subject: t.claims.Subject, Issuer: t.claims.Issuer, Routes: t.claims.Routes, Roles: t.claims.Roles}
+	if t.claims.ExpiresAt != 0 {
+		identity.ExpiresAt = time.Unix(t.claims.ExpiresAt, 0)
+	}
+	return identity, nil
+}
+
+// BearerToken extracts the token from the Authorization header, blank if there is none
+func BearerToken(req *http.Request) string {
+	const prefix = "Bearer "
+	if auth := req.Header.Get("Authorization"); len(auth) > len(prefix) && strings.EqualFold(auth[:len(prefix)], prefix) {
+		return strings.TrimSpace(auth[len(prefix):])
+	}
+	return ""
+}
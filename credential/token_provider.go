@@ -0,0 +1,90 @@
+package credential
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// TokenProvider supplies the token a connector presents every time it dials the router,
+// so a rotated token is picked up without restarting the connector
+type TokenProvider interface {
+	Token() (string, error)
+}
+
+// StaticToken a token which never changes
+type StaticToken string
+
+func (t StaticToken) Token() (string, error) {
+	return string(t), nil
+}
+
+// FileTokenProvider reads the token from a file, e.g. one mounted from a secret store, and reads it again once the file is modified
+type FileTokenProvider struct {
+	path    string
+	lock    sync.Mutex
+	modTime time.Time
+	token   string
+}
+
+func NewFileTokenProvider(path string) *FileTokenProvider {
+	return &FileTokenProvider{path: path}
+}
+
+func (p *FileTokenProvider) Token() (string, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return "", err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.token != "" && info.ModTime().Equal(p.modTime) {
+		return p.token, nil
+	}
+	content, err := os.ReadFile(p.path)
+	if err != nil {
+		return "", err
+	}
+	token := string(bytes.TrimSpace(content))
+	if token == "" {
+		return "", errors.New("empty token file: " + p.path)
+	}
+	p.token, p.modTime = token, info.ModTime()
+	return p.token, nil
+}
+
+// HmacTokenProvider mints HS256 tokens from a shared secret, a new token is minted once the current one reaches half of its ttl
+type HmacTokenProvider struct {
+	secret    []byte
+	subject   string
+	routes    []string
+	ttl       time.Duration
+	lock      sync.Mutex
+	token     string
+	refreshAt time.Time
+}
+
+// NewHmacTokenProvider
+// @param routes the routes the connector registers, "*" for every route
+// @param ttl how long each minted token is valid, 1 hour by default
+func NewHmacTokenProvider(secret []byte, subject string, routes []string, ttl time.Duration) *HmacTokenProvider {
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	return &HmacTokenProvider{secret: secret, subject: subject, routes: routes, ttl: ttl}
+}
+
+func (p *HmacTokenProvider) Token() (string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if now := time.Now(); p.token == "" || now.After(p.refreshAt) {
+		token, err := NewHmacToken(p.secret, p.subject, p.routes, p.ttl)
+		if err != nil {
+			return "", err
+		}
+		p.token, p.refreshAt = token, now.Add(p.ttl/2)
+	}
+	return p.token, nil
+}
@@ -0,0 +1,129 @@
+package credential
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHmacVerifier(t *testing.T) {
+	oldSecret, newSecret := []byte("old-secret"), []byte("new-secret")
+	verifier := NewHmacVerifier(newSecret, oldSecret)
+	expired, _ := Sign(&Claims{Subject: "a", Routes: []string{"a"}, ExpiresAt: time.Now().Add(-time.Hour).Unix()}, AlgHS256, "",
+		func(signingInput []byte) ([]byte, error) { return hmacSum(newSecret, signingInput), nil })
+	tests := []struct {
+		name      string
+		token     func() (string, error)
+		wantErr   error
+		wantRoute string
+	}{
+		{name: "newSecret", token: func() (string, error) { return NewHmacToken(newSecret, "a", []string{"a"}, time.Minute) }, wantRoute: "a"},
+		{name: "oldSecretDuringRotation", token: func() (string, error) { return NewHmacToken(oldSecret, "b", []string{"*"}, 0) }, wantRoute: "anything"},
+		{name: "unknownSecret", token: func() (string, error) { return NewHmacToken([]byte("other"), "a", []string{"a"}, time.Minute) }, wantErr: ErrInvalidSignature},
+		{name: "expired", token: func() (string, error) { return expired, nil }, wantErr: ErrTokenExpired},
+		{name: "malformed", token: func() (string, error) { return "not.a-token", nil }, wantErr: ErrMalformedToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tt.token()
+			if err != nil {
+				t.Fatalf("token() error = %v", err)
+			}
+			identity, err := verifier.Verify(token)
+			if err != tt.wantErr {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !identity.CanRegister(tt.wantRoute) {
+				t.Errorf("%s cannot register %s", identity, tt.wantRoute)
+			}
+		})
+	}
+}
+
+func TestIdentityCanRegister(t *testing.T) {
+	identity := &Identity{Subject: "a", Routes: []string{"orders", "payments"}}
+	for route, want := range map[string]bool{"orders": true, "payments": true, "users": false, "": false} {
+		if got := identity.CanRegister(route); got != want {
+			t.Errorf("CanRegister(%q) = %v, want %v", route, got, want)
+		}
+	}
+}
+
+func TestKeyFileVerifier(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	writePublicKey(t, filepath.Join(dir, "rsa-2024.pem"), &rsaKey.PublicKey)
+	writePublicKey(t, filepath.Join(dir, "ec-2024.pem"), &ecKey.PublicKey)
+	verifier, err := NewKeyFileVerifier(filepath.Join(dir, "rsa-2024.pem"), filepath.Join(dir, "ec-2024.pem"))
+	if err != nil {
+		t.Fatalf("NewKeyFileVerifier() error = %v", err)
+	}
+	claims := &Claims{Subject: "orders-connector", Routes: []string{"orders"}, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	rsaToken, _ := NewRsaToken(rsaKey, "rsa-2024", claims)
+	ecToken, _ := NewEcdsaToken(ecKey, "", claims)
+	wrongKid, _ := NewRsaToken(rsaKey, "ec-2024", claims)
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "rsaWithKid", token: rsaToken},
+		{name: "ecdsaWithoutKid", token: ecToken},
+		{name: "wrongKid", token: wrongKid, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := verifier.Verify(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && identity.Subject != "orders-connector" {
+				t.Errorf("Verify() subject = %s", identity.Subject)
+			}
+		})
+	}
+}
+
+func TestFileTokenProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	provider := NewFileTokenProvider(path)
+	if token, err := provider.Token(); err != nil || token != "first" {
+		t.Fatalf("Token() = %q, %v", token, err)
+	}
+	if err := os.WriteFile(path, []byte("second"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	if token, err := provider.Token(); err != nil || token != "second" {
+		t.Errorf("Token() after rotation = %q, %v", token, err)
+	}
+}
+
+func hmacSum(secret, signingInput []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(signingInput)
+	return mac.Sum(nil)
+}
+
+func writePublicKey(t *testing.T, path string, key interface{}) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,244 @@
+package credential
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/torchcc/crank4go/util"
+)
+
+// key files are checked for modification at most once per reloadCheckInterval
+const reloadCheckInterval = 5 * time.Second
+
+// Verifier verifies the token a connector presents on registration
+type Verifier interface {
+	Verify(token string) (*Identity, error)
+}
+
+// Verifiers tries each verifier in order, the first one accepting the token wins
+type Verifiers []Verifier
+
+func (vs Verifiers) Verify(token string) (*Identity, error) {
+	err := ErrInvalidSignature
+	for _, v := range vs {
+		var identity *Identity
+		if identity, err = v.Verify(token); err == nil {
+			return identity, nil
+		}
+	}
+	return nil, err
+}
+
+// HmacVerifier verifies HS256 tokens. several secrets may be configured at once, so that the secret can be rotated
+// by adding the new one, rolling the connectors and then removing the old one
+type HmacVerifier struct {
+	lock    sync.RWMutex
+	secrets [][]byte
+	files   *watchedFiles
+}
+
+func NewHmacVerifier(secrets ...[]byte) *HmacVerifier {
+	return &HmacVerifier{secrets: secrets}
+}
+
+// NewHmacVerifierFromFiles reads one secret from each file, surrounding whitespace is trimmed.
+// the files are read again once they are modified
+func NewHmacVerifierFromFiles(paths ...string) (*HmacVerifier, error) {
+	v := &HmacVerifier{files: newWatchedFiles(paths)}
+	if err := v.Reload(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Reload reads the secret files again, the current secrets are kept if any file is invalid
+func (v *HmacVerifier) Reload() error {
+	if v.files == nil {
+		return nil
+	}
+	secrets := make([][]byte, 0, len(v.files.paths))
+	for _, path := range v.files.paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if secret := bytes.TrimSpace(content); len(secret) == 0 {
+			return errors.New("empty hmac secret file: " + path)
+		} else {
+			secrets = append(secrets, secret)
+		}
+	}
+	v.lock.Lock()
+	v.secrets = secrets
+	v.lock.Unlock()
+	return nil
+}
+
+func (v *HmacVerifier) Verify(token string) (*Identity, error) {
+	v.files.reloadIfModified(v.Reload)
+	t, err := parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if t.header.Alg != AlgHS256 {
+		return nil, fmt.Errorf("unexpected registration token algorithm: %s", t.header.Alg)
+	}
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	for _, secret := range v.secrets {
+		if t.verifyHmac(secret) {
+			return t.identity(time.Now())
+		}
+	}
+	return nil, ErrInvalidSignature
+}
+
+// KeyFileVerifier verifies RS256 and ES256 tokens against public keys loaded from PEM files, the files may hold
+// a PUBLIC KEY, a RSA PUBLIC KEY or a CERTIFICATE. the kid of a key is its file name without extension, a token
+// carrying a kid is only checked against that key. the files are read again once they are modified
+type KeyFileVerifier struct {
+	lock  sync.RWMutex
+	keys  map[string]interface{} // kid -> *rsa.PublicKey or *ecdsa.PublicKey
+	files *watchedFiles
+}
+
+func NewKeyFileVerifier(paths ...string) (*KeyFileVerifier, error) {
+	v := &KeyFileVerifier{files: newWatchedFiles(paths)}
+	if err := v.Reload(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Reload reads the key files again, the current keys are kept if any file is invalid
+func (v *KeyFileVerifier) Reload() error {
+	keys := make(map[string]interface{})
+	for _, path := range v.files.paths {
+		key, err := loadPublicKey(path)
+		if err != nil {
+			return err
+		}
+		keys[strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))] = key
+	}
+	v.lock.Lock()
+	v.keys = keys
+	v.lock.Unlock()
+	return nil
+}
+
+func (v *KeyFileVerifier) Verify(token string) (*Identity, error) {
+	v.files.reloadIfModified(v.Reload)
+	t, err := parse(token)
+	if err != nil {
+		return nil, err
+	}
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	for kid, key := range v.keys {
+		if t.header.Kid != "" && t.header.Kid != kid {
+			continue
+		}
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			if t.header.Alg == AlgRS256 && t.verifyRsa(k) {
+				return t.identity(time.Now())
+			}
+		case *ecdsa.PublicKey:
+			if t.header.Alg == AlgES256 && t.verifyEcdsa(k) {
+				return t.identity(time.Now())
+			}
+		}
+	}
+	return nil, ErrInvalidSignature
+}
+
+func loadPublicKey(path string) (interface{}, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("no PEM block found in " + path)
+	}
+	var key interface{}
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %s in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key in %s: %s", path, err.Error())
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T in %s", key, path)
+	}
+}
+
+// watchedFiles remembers the modification time of files, so that they are only read again once modified
+type watchedFiles struct {
+	paths     []string
+	lock      sync.Mutex
+	lastCheck time.Time
+	modTimes  map[string]time.Time
+}
+
+func newWatchedFiles(paths []string) *watchedFiles {
+	f := &watchedFiles{paths: paths, lastCheck: time.Now(), modTimes: make(map[string]time.Time)}
+	f.modified()
+	return f
+}
+
+// modified returns true if any file is modified since the last call
+func (f *watchedFiles) modified() bool {
+	modified := false
+	for _, path := range f.paths {
+		if info, err := os.Stat(path); err == nil && !info.ModTime().Equal(f.modTimes[path]) {
+			f.modTimes[path] = info.ModTime()
+			modified = true
+		}
+	}
+	return modified
+}
+
+func (f *watchedFiles) reloadIfModified(reload func() error) {
+	if f == nil {
+		return
+	}
+	f.lock.Lock()
+	if time.Since(f.lastCheck) < reloadCheckInterval {
+		f.lock.Unlock()
+		return
+	}
+	f.lastCheck = time.Now()
+	modified := f.modified()
+	f.lock.Unlock()
+	if modified {
+		if err := reload(); err != nil {
+			util.LOG.Warningf("failed to reload credential files %v, the current keys are kept, err: %s", f.paths, err.Error())
+		} else {
+			util.LOG.Infof("credential files %v reloaded", f.paths)
+		}
+	}
+}
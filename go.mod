@@ -1,10 +1,11 @@
 module github.com/torchcc/crank4go
 
-go 1.16
+go 1.18
 
 require (
 	github.com/google/uuid v1.2.0
 	github.com/gorilla/websocket v1.4.2
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
+	golang.org/x/crypto v0.9.0
 )
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/torchcc/crank4go/router/audit"
+	"github.com/torchcc/crank4go/router/handler"
+	"github.com/torchcc/crank4go/util"
+)
+
+// the response body of a failed call kept in its audit record
+const maxAuditedErrorBytes = 512
+
+// auditor writes an audit record for each mutating call of a resource
+type auditor struct {
+	sink  audit.Sink
+	state func() interface{}
+}
+
+// SetAuditor audits every call of the resource which is not GET, HEAD or OPTIONS to the sink.
+// state returns the state of the resource, it is recorded before and after the call, nil to record no state
+func (f *Filter) SetAuditor(sink audit.Sink, state func() interface{}) *Filter {
+	if sink == nil {
+		f.auditor = nil
+	} else {
+		f.auditor = &auditor{sink: sink, state: state}
+	}
+	return f
+}
+
+func (a *auditor) wrap(function func(http.ResponseWriter, *http.Request, httprouter.Params) bool) func(http.ResponseWriter, *http.Request, httprouter.Params) bool {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			return function(w, r, params)
+		}
+		record := &audit.Record{
+			ID:         uuid.New().String(),
+			Time:       time.Now().UTC(),
+			Principal:  "anonymous",
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			Path:       r.URL.RequestURI(),
+		}
+		if len(params) > 0 {
+			record.Params = make(map[string]string)
+			for _, p := range params {
+				record.Params[p.Key] = p.Value
+			}
+		}
+		if a.state != nil {
+			record.Before = a.state()
+		}
+		capture := &auditCapture{ResponseWriter: w}
+		stop := function(capture, r, params)
+		// the AuthFilter stores the principal in the request while the call runs, a call without credentials stays anonymous
+		if principal := handler.PrincipalFrom(r); principal != nil {
+			record.Principal, record.Role, record.AuthMethod = principal.Name, principal.Role.String(), principal.Method
+		}
+		if a.state != nil {
+			record.After = a.state()
+		}
+		record.Status = capture.status
+		if record.Status == 0 {
+			record.Status = http.StatusOK
+		}
+		if record.Status < http.StatusBadRequest {
+			record.Result = "success"
+		} else {
+			record.Result = "failure"
+			record.Error = capture.body.String()
+		}
+		if err := a.sink.Write(record); err != nil {
+			util.LOG.Errorf("failed to write audit record %s of %s %s by %s, err: %s", record.ID, record.Method, record.Path, record.Principal, err.Error())
+		}
+		return stop
+	}
+}
+
+// auditCapture records the status and the beginning of the body written by the resource
+type auditCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (c *auditCapture) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *auditCapture) Write(buf []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if remaining := maxAuditedErrorBytes - c.body.Len(); remaining > 0 {
+		if len(buf) < remaining {
+			remaining = len(buf)
+		}
+		c.body.Write(buf[:remaining])
+	}
+	return c.ResponseWriter.Write(buf)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/torchcc/crank4go/router/audit"
+	"github.com/torchcc/crank4go/util"
+)
+
+const (
+	auditResourceBasePath string = "/audit"
+	defaultAuditLimit            = 100
+)
+
+type AuditResource struct {
+	basePath string
+	sink     audit.Sink
+	*Filter
+}
+
+func NewAuditResource(sink audit.Sink) *AuditResource {
+	return &AuditResource{
+		basePath: auditResourceBasePath,
+		sink:     sink,
+		Filter:   &Filter{},
+	}
+}
+
+// GetRecords the latest audit records with from <= time < to, from and to are RFC3339 and optional, limit defaults to 100
+func (a *AuditResource) GetRecords(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
+	query := r.URL.Query()
+	from, err := parseAuditTime(query.Get("from"))
+	if err != nil {
+		a.invalidQuery(w, r, "invalid from: "+err.Error())
+		return true
+	}
+	to, err := parseAuditTime(query.Get("to"))
+	if err != nil {
+		a.invalidQuery(w, r, "invalid to: "+err.Error())
+		return true
+	}
+	limit := defaultAuditLimit
+	if s := query.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			a.invalidQuery(w, r, fmt.Sprintf("invalid limit %s, it must be a positive integer", s))
+			return true
+		}
+	}
+	records, err := a.sink.Query(from, to, limit)
+	if err != nil {
+		errorID := uuid.New().String()
+		util.LOG.Errorf("failed to query audit records, ErrorID=%s, err: %s", errorID, err.Error())
+		apiError := &ApiError{Status: http.StatusInternalServerError, Code: "audit_query_failed", Message: "failed to query audit records", ErrorID: errorID}
+		respondError(w, r, apiError, "failed to query audit records, ErrorID="+errorID)
+		return true
+	}
+	respondJson(w, r, http.StatusOK, map[string]interface{}{"records": records})
+	return true
+}
+
+func (a *AuditResource) invalidQuery(w http.ResponseWriter, r *http.Request, msg string) {
+	errorID := uuid.New().String()
+	util.LOG.Warningf("Receive invalid audit query: %s, errorID: %s", msg, errorID)
+	respondError(w, r, &ApiError{Status: http.StatusBadRequest, Code: "invalid_query", Message: msg, ErrorID: errorID}, msg+", ErrorID: "+errorID)
+}
+
+func parseAuditTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func (a *AuditResource) RegisterResourceToHttpRouter(httpRouter *httprouter.Router, rootPath string) {
+	basePath := rootPath + a.basePath
+	httpRouter.GET(basePath, a.convertToHttpRouterHandlerWithFilters(a.GetRecords))
+}
+
+func (a *AuditResource) Operations(rootPath string) []*Operation {
+	return []*Operation{{
+		Method:  http.MethodGet,
+		Path:    rootPath + a.basePath,
+		Summary: "the latest audit records of mutating admin calls",
+		Query: []QueryParam{
+			{Name: "from", Description: "RFC3339, records at or after it"},
+			{Name: "to", Description: "RFC3339, records before it"},
+			{Name: "limit", Description: "the maximum number of the latest records, 100 by default"},
+		},
+		Response: "AuditRecords",
+	}}
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/torchcc/crank4go/router/audit"
+	"github.com/torchcc/crank4go/router/darklaunch_manager"
+	"github.com/torchcc/crank4go/router/handler"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuditor(t *testing.T) {
+	sum := func(s string) string {
+		hash, _ := bcrypt.GenerateFromPassword([]byte(s), bcrypt.MinCost)
+		return string(hash)
+	}
+	path := filepath.Join(t.TempDir(), "users")
+	content := "alice:operator:" + sum("alice-pw") + "\nbob:reader:" + sum("bob-pw") + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	basicAuth, err := handler.NewBasicAuthFile(path)
+	if err != nil {
+		t.Fatalf("NewBasicAuthFile() error = %v", err)
+	}
+	sink := audit.NewMemorySink(10)
+	httpRouter := httprouter.New()
+	manager := darklaunch_manager.NewDarkLaunchManager().SetServiceListener(&noopServiceListener{})
+	resource := NewDarkLaunchServiceResource(manager)
+	resource.AddReqFilters(handler.NewAuthFilter(basicAuth)).SetAuditor(sink, nil)
+	resource.RegisterResourceToHttpRouter(httpRouter, "/api")
+
+	tests := []struct {
+		name          string
+		method        string
+		user          string
+		password      string
+		wantStatus    int
+		wantPrincipal string
+		wantResult    string
+	}{
+		{name: "anonymous", method: http.MethodPut, wantStatus: http.StatusUnauthorized, wantPrincipal: "anonymous", wantResult: "failure"},
+		{name: "reader writes", method: http.MethodPut, user: "bob", password: "bob-pw", wantStatus: http.StatusForbidden, wantPrincipal: "bob", wantResult: "failure"},
+		{name: "operator writes", method: http.MethodPut, user: "alice", password: "alice-pw", wantStatus: http.StatusOK, wantPrincipal: "alice", wantResult: "success"},
+		{name: "reader reads", method: http.MethodGet, user: "bob", password: "bob-pw", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, _ := sink.Query(time.Time{}, time.Time{}, 0)
+			r := httptest.NewRequest(tt.method, "/api/dark-launch/service/order", nil)
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+			w := httptest.NewRecorder()
+			httpRouter.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			records, _ := sink.Query(time.Time{}, time.Time{}, 0)
+			if tt.wantResult == "" {
+				if len(records) != len(before) {
+					t.Errorf("a %s call was audited", tt.method)
+				}
+				return
+			}
+			if len(records) != len(before)+1 {
+				t.Fatalf("%d records, want the call audited", len(records)-len(before))
+			}
+			record := records[len(records)-1]
+			if record.Status != tt.wantStatus || record.Principal != tt.wantPrincipal || record.Result != tt.wantResult {
+				t.Errorf("record = %+v, want status %d by %s with %s", record, tt.wantStatus, tt.wantPrincipal, tt.wantResult)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/torchcc/crank4go/router/router_socket"
+	"github.com/torchcc/crank4go/util"
+)
+
+const concurrencyResourceBasePath string = "/concurrency"
+
+// ConcurrencyResource shows and adjusts the limits of simultaneous in-flight requests at runtime, a limit of 0 means no limit
+type ConcurrencyResource struct {
+	basePath      string
+	websocketFarm *router_socket.WebsocketFarm
+	*Filter
+}
+
+func NewConcurrencyResource(websocketFarm *router_socket.WebsocketFarm) *ConcurrencyResource {
+	return &ConcurrencyResource{
+		basePath:      concurrencyResourceBasePath,
+		websocketFarm: websocketFarm,
+		Filter:        &Filter{},
+	}
+}
+
+func (c *ConcurrencyResource) GetLimits(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
+	respondJson(w, r, http.StatusOK, c.websocketFarm.ConcurrencyLimits().Status())
+	return true
+}
+
+// PutLimits changes the default limits, @QueryParam("routeLimit"), @QueryParam("instanceLimit"), @QueryParam("queueTimeout") e.g. 500ms
+func (c *ConcurrencyResource) PutLimits(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
+	query := r.URL.Query()
+	routeLimit, ok := c.parseLimit(w, r, query.Get("routeLimit"))
+	if !ok {
+		return true
+	}
+	instanceLimit, ok := c.parseLimit(w, r, query.Get("instanceLimit"))
+	if !ok {
+		return true
+	}
+	var queueTimeout time.Duration
+	if s := query.Get("queueTimeout"); s != "" {
+		var err error
+		if queueTimeout, err = time.ParseDuration(s); err != nil || queueTimeout < 0 {
+			c.invalidLimit(w, r, fmt.Sprintf("invalid queueTimeout %s, it must be a non-negative duration, e.g. 500ms", s))
+			return true
+		}
+	}
+	limits := c.websocketFarm.ConcurrencyLimits()
+	if routeLimit >= 0 {
+		limits.SetRouteLimit(routeLimit)
+	}
+	if instanceLimit >= 0 {
+		limits.SetInstanceLimit(instanceLimit)
+	}
+	if query.Get("queueTimeout") != "" {
+		limits.SetQueueTimeout(queueTimeout)
+	}
+	respondJson(w, r, http.StatusOK, limits.Status())
+	return true
+}
+
+// @Path("/routes/{route}"), @QueryParam("limit")
+func (c *ConcurrencyResource) PutRouteLimit(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
+	if limit, ok := c.requireLimit(w, r); ok {
+		respondJson(w, r, http.StatusOK, c.websocketFarm.ConcurrencyLimits().SetRouteLimitOf(params.ByName("route"), limit).Status())
+	}
+	return true
+}
+
+// @Path("/routes/{route}"), the route is limited by the default route limit again
+func (c *ConcurrencyResource) DeleteRouteLimit(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
+	respondJson(w, r, http.StatusOK, c.websocketFarm.ConcurrencyLimits().RemoveRouteLimitOf(params.ByName("route")).Status())
+	return true
+}
+
+// @Path("/instances/{connectorInstanceID}"), @QueryParam("limit")
+func (c *ConcurrencyResource) PutInstanceLimit(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
+	if limit, ok := c.requireLimit(w, r); ok {
+		respondJson(w, r, http.StatusOK, c.websocketFarm.ConcurrencyLimits().SetInstanceLimitOf(params.ByName("connectorInstanceID"), limit).Status())
+	}
+	return true
+}
+
+// @Path("/instances/{connectorInstanceID}"), the instance is limited by the default instance limit again
+func (c *ConcurrencyResource) DeleteInstanceLimit(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
+	respondJson(w, r, http.StatusOK, c.websocketFarm.ConcurrencyLimits().RemoveInstanceLimitOf(params.ByName("connectorInstanceID")).Status())
+	return true
+}
+
+func (c *ConcurrencyResource) requireLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	s := r.URL.Query().Get("limit")
+	if s == "" {
+		c.invalidLimit(w, r, "limit is required")
+		return 0, false
+	}
+	return c.parseLimit(w, r, s)
+}
+
+// parseLimit -1 is returned for a blank limit
+func (c *ConcurrencyResource) parseLimit(w http.ResponseWriter, r *http.Request, s string) (int, bool) {
+	if s == "" {
+		return -1, true
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit < 0 {
+		c.invalidLimit(w, r, fmt.Sprintf("invalid limit %s, it must be a non-negative integer", s))
+		return 0, false
+	}
+	return limit, true
+}
+
+func (c *ConcurrencyResource) invalidLimit(w http.ResponseWriter, r *http.Request, msg string) {
+	errorID := uuid.New().String()
+	util.LOG.Warningf("Receive invalid concurrency limit: %s, errorID: %s", msg, errorID)
+	apiError := &ApiError{Status: http.StatusBadRequest, Code: "invalid_limit", Message: msg, ErrorID: errorID}
+	writeJson(w, negotiateJson(r), apiError.Status, &apiErrorBody{Error: apiError})
+}
+
+func (c *ConcurrencyResource) RegisterResourceToHttpRouter(httpRouter *httprouter.Router, rootPath string) {
+	basePath := rootPath + c.basePath
+	httpRouter.GET(basePath, c.convertToHttpRouterHandlerWithFilters(c.GetLimits))
+	httpRouter.PUT(basePath, c.convertToHttpRouterHandlerWithFilters(c.PutLimits))
+	httpRouter.PUT(basePath+"/routes/:route", c.convertToHttpRouterHandlerWithFilters(c.PutRouteLimit))
+	httpRouter.DELETE(basePath+"/routes/:route", c.convertToHttpRouterHandlerWithFilters(c.DeleteRouteLimit))
+	httpRouter.PUT(basePath+"/instances/:connectorInstanceID", c.convertToHttpRouterHandlerWithFilters(c.PutInstanceLimit))
+	httpRouter.DELETE(basePath+"/instances/:connectorInstanceID", c.convertToHttpRouterHandlerWithFilters(c.DeleteInstanceLimit))
+}
+
+func (c *ConcurrencyResource) Operations(rootPath string) []*Operation {
+	basePath := rootPath + c.basePath
+	limit := []QueryParam{{Name: "limit", Description: "the maximum of simultaneous in-flight requests, 0 for no limit"}}
+	return []*Operation{
+		{Method: http.MethodGet, Path: basePath, Summary: "the concurrency limits and the in-flight requests counted against them", Response: "ConcurrencyStatus"},
+		{Method: http.MethodPut, Path: basePath, Summary: "change the default limits per route and per connector instance", Response: "ConcurrencyStatus", Query: []QueryParam{
+			{Name: "routeLimit", Description: "the limit of every route without a limit of its own"},
+			{Name: "instanceLimit", Description: "the limit of every connector instance without a limit of its own"},
+			{Name: "queueTimeout", Description: "how long a request over the route limit waits before it is rejected with 503, e.g. 500ms"},
+		}},
+		{Method: http.MethodPut, Path: basePath + "/routes/:route", Summary: "set the limit of the route", Response: "ConcurrencyStatus", Query: limit},
+		{Method: http.MethodDelete, Path: basePath + "/routes/:route", Summary: "limit the route by the default route limit again", Response: "ConcurrencyStatus"},
+		{Method: http.MethodPut, Path: basePath + "/instances/:connectorInstanceID", Summary: "set the limit of the connector instance", Response: "ConcurrencyStatus", Query: limit},
+		{Method: http.MethodDelete, Path: basePath + "/instances/:connectorInstanceID", Summary: "limit the connector instance by the default instance limit again", Response: "ConcurrencyStatus"},
+	}
+}
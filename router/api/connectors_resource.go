@@ -0,0 +1,109 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/torchcc/crank4go/router/router_socket"
+	"github.com/torchcc/crank4go/util"
+)
+
+const connectorsResourceBasePath string = "/connectors"
+
+// ConnectorsResource lets operators drain or evict a connector instance from the router side
+type ConnectorsResource struct {
+	basePath      string
+	websocketFarm *router_socket.WebsocketFarm
+	*Filter
+}
+
+func NewConnectorsResource(websocketFarm *router_socket.WebsocketFarm) *ConnectorsResource {
+	return &ConnectorsResource{
+		basePath:      connectorsResourceBasePath,
+		websocketFarm: websocketFarm,
+		Filter:        &Filter{},
+	}
+}
+
+func (c *ConnectorsResource) GetInstances(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
+	respondJson(w, r, http.StatusOK, map[string]interface{}{"instances": c.websocketFarm.Instances()})
+	return true
+}
+
+// @Path("/{connectorInstanceID}")
+func (c *ConnectorsResource) GetInstance(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
+	connectorInstanceID := params.ByName("connectorInstanceID")
+	if instance, ok := c.websocketFarm.Instances()[connectorInstanceID]; ok {
+		respondJson(w, r, http.StatusOK, instance)
+	} else {
+		c.notFound(w, r, connectorInstanceID)
+	}
+	return true
+}
+
+// @Path("/{connectorInstanceID}/drain"), idle sockets are closed and in-flight requests are left to finish
+func (c *ConnectorsResource) PostDrain(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
+	connectorInstanceID := params.ByName("connectorInstanceID")
+	if _, ok := c.websocketFarm.Instances()[connectorInstanceID]; !ok {
+		c.notFound(w, r, connectorInstanceID)
+		return true
+	}
+	respondJson(w, r, http.StatusOK, c.websocketFarm.Drain(connectorInstanceID))
+	return true
+}
+
+// @Path("/{connectorInstanceID}/drain"), the instance may register again
+func (c *ConnectorsResource) DeleteDrain(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
+	connectorInstanceID := params.ByName("connectorInstanceID")
+	if !c.websocketFarm.Resume(connectorInstanceID) {
+		c.notFound(w, r, connectorInstanceID)
+		return true
+	}
+	respondJson(w, r, http.StatusOK, &router_socket.InstanceSockets{ConnectorInstanceID: connectorInstanceID})
+	return true
+}
+
+// @Path("/{connectorInstanceID}"), every socket of the instance is closed at once
+func (c *ConnectorsResource) DeleteInstance(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
+	connectorInstanceID := params.ByName("connectorInstanceID")
+	if _, ok := c.websocketFarm.Instances()[connectorInstanceID]; !ok {
+		c.notFound(w, r, connectorInstanceID)
+		return true
+	}
+	respondJson(w, r, http.StatusOK, c.websocketFarm.Evict(connectorInstanceID))
+	return true
+}
+
+func (c *ConnectorsResource) notFound(w http.ResponseWriter, r *http.Request, connectorInstanceID string) {
+	errorID := uuid.New().String()
+	util.LOG.Warningf("Receive unknown connector instance: %s, action: %s %s, errorID: %s", connectorInstanceID, r.Method, r.URL.Path, errorID)
+	apiError := &ApiError{
+		Status:  http.StatusNotFound,
+		Code:    "unknown_connector_instance",
+		Message: fmt.Sprintf("connector instance %s is not connected", connectorInstanceID),
+		ErrorID: errorID,
+	}
+	writeJson(w, negotiateJson(r), apiError.Status, &apiErrorBody{Error: apiError})
+}
+
+func (c *ConnectorsResource) RegisterResourceToHttpRouter(httpRouter *httprouter.Router, rootPath string) {
+	basePath := rootPath + c.basePath
+	httpRouter.GET(basePath, c.convertToHttpRouterHandlerWithFilters(c.GetInstances))
+	httpRouter.GET(basePath+"/:connectorInstanceID", c.convertToHttpRouterHandlerWithFilters(c.GetInstance))
+	httpRouter.DELETE(basePath+"/:connectorInstanceID", c.convertToHttpRouterHandlerWithFilters(c.DeleteInstance))
+	httpRouter.POST(basePath+"/:connectorInstanceID/drain", c.convertToHttpRouterHandlerWithFilters(c.PostDrain))
+	httpRouter.DELETE(basePath+"/:connectorInstanceID/drain", c.convertToHttpRouterHandlerWithFilters(c.DeleteDrain))
+}
+
+func (c *ConnectorsResource) Operations(rootPath string) []*Operation {
+	basePath := rootPath + c.basePath
+	return []*Operation{
+		{Method: http.MethodGet, Path: basePath, Summary: "the idle and in-flight sockets of every connector instance", Response: "Object"},
+		{Method: http.MethodGet, Path: basePath + "/:connectorInstanceID", Summary: "the idle and in-flight sockets of the connector instance", Response: "InstanceSockets"},
+		{Method: http.MethodDelete, Path: basePath + "/:connectorInstanceID", Summary: "evict the connector instance, closing all its sockets at once", Response: "InstanceSockets"},
+		{Method: http.MethodPost, Path: basePath + "/:connectorInstanceID/drain", Summary: "drain the connector instance, closing its idle sockets and letting in-flight requests finish", Response: "InstanceSockets"},
+		{Method: http.MethodDelete, Path: basePath + "/:connectorInstanceID/drain", Summary: "accept registrations of the drained connector instance again", Response: "InstanceSockets"},
+	}
+}
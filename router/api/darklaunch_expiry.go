@@ -0,0 +1,48 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// parseExpiry reads the optional expiry of a dark launch entry from the query string,
+// either as a ttl e.g. `?ttl=30m` or as an absolute time e.g. `?expiresAt=2021-06-01T10:00:00Z`.
+// a zero time is returned if neither is given, which means the entry never expires
+func parseExpiry(r *http.Request) (time.Time, error) {
+	ttl, expiresAt := r.URL.Query().Get("ttl"), r.URL.Query().Get("expiresAt")
+	switch {
+	case ttl != "" && expiresAt != "":
+		return time.Time{}, errors.New("only one of ttl and expiresAt can be given")
+	case ttl != "":
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			return time.Time{}, fmt.Errorf("invalid ttl: %s", ttl)
+		}
+		return time.Now().Add(d), nil
+	case expiresAt != "":
+		t, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil || !t.After(time.Now()) {
+			return time.Time{}, fmt.Errorf("invalid expiresAt: %s", expiresAt)
+		}
+		return t, nil
+	default:
+		return time.Time{}, nil
+	}
+}
+
+// formatExpiries formats map[entry]expiresAt as `{entry=expiresAt, ...}` in the order of entries
+func formatExpiries(expiries map[string]time.Time) string {
+	entries := make([]string, 0, len(expiries))
+	for entry := range expiries {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+	for i, entry := range entries {
+		entries[i] = entry + "=" + expiries[entry].UTC().Format(time.RFC3339)
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
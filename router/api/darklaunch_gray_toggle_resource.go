@@ -26,18 +26,17 @@ func NewDarkLaunchGrayToggleResource(darkLaunchManager *darklaunch_manager.DarkL
 }
 
 func (t *DarkLaunchGrayToggleResource) GetDetail(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
-	RespTextPlainOk(w, fmt.Sprintf("DarkMode=%v, darkModeGrayTestToggle=%v",
-		t.darkLaunchManager.IsDarkModeOn(), darklaunch_manager.IsGrayTestingOn()))
+	t.respondToggle(w, r)
 	return true
 }
 
 // PAth("/on")
 func (t *DarkLaunchGrayToggleResource) PutOn(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
 	if !t.darkLaunchManager.IsDarkModeOn() {
-		RespTextPlainWithStatus(w, "Forbidden request, ErrorID="+uuid.New().String(), http.StatusForbidden)
+		t.darkModeOffError(w, r)
 	} else {
 		darklaunch_manager.TurnGrayTestingOn("turn on gray testing by rest call")
-		RespTextPlainOk(w, fmt.Sprintf("DarkMode=%v, darkModeGrayTestToggle=%v", t.darkLaunchManager.IsDarkModeOn(), darklaunch_manager.IsGrayTestingOn()))
+		t.respondToggle(w, r)
 	}
 	return true
 }
@@ -45,17 +44,42 @@ func (t *DarkLaunchGrayToggleResource) PutOn(w http.ResponseWriter, r *http.Requ
 // PAth("/off")
 func (t *DarkLaunchGrayToggleResource) PutOff(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
 	if !t.darkLaunchManager.IsDarkModeOn() {
-		RespTextPlainWithStatus(w, "Forbidden request, ErrorID="+uuid.New().String(), http.StatusForbidden)
+		t.darkModeOffError(w, r)
 	} else {
 		darklaunch_manager.TurnGrayTestingOff("turn off gray testing by rest call")
-		RespTextPlainOk(w, fmt.Sprintf("DarkMode=%v, darkModeGrayTestToggle=%v", t.darkLaunchManager.IsDarkModeOn(), darklaunch_manager.IsGrayTestingOn()))
+		t.respondToggle(w, r)
 	}
 	return true
 }
 
+func (t *DarkLaunchGrayToggleResource) respondToggle(w http.ResponseWriter, r *http.Request) {
+	toggle := &GrayToggle{DarkMode: t.darkLaunchManager.IsDarkModeOn(), GrayTesting: darklaunch_manager.IsGrayTestingOn()}
+	respond(w, r, http.StatusOK, fmt.Sprintf("DarkMode=%v, darkModeGrayTestToggle=%v", toggle.DarkMode, toggle.GrayTesting), toggle)
+}
+
+func (t *DarkLaunchGrayToggleResource) darkModeOffError(w http.ResponseWriter, r *http.Request) {
+	errorID := uuid.New().String()
+	apiError := &ApiError{
+		Status:  http.StatusForbidden,
+		Code:    "dark_mode_off",
+		Message: "gray testing can only be toggled while dark mode is on",
+		ErrorID: errorID,
+	}
+	respondError(w, r, apiError, "Forbidden request, ErrorID="+errorID)
+}
+
 func (t *DarkLaunchGrayToggleResource) RegisterResourceToHttpRouter(httpRouter *httprouter.Router, rootPath string) {
 	basePath := rootPath + t.basePath
 	httpRouter.GET(basePath, t.convertToHttpRouterHandlerWithFilters(t.GetDetail))
 	httpRouter.PUT(basePath+"/on", t.convertToHttpRouterHandlerWithFilters(t.PutOn))
 	httpRouter.PUT(basePath+"/off", t.convertToHttpRouterHandlerWithFilters(t.PutOff))
 }
+
+func (t *DarkLaunchGrayToggleResource) Operations(rootPath string) []*Operation {
+	basePath := rootPath + t.basePath
+	return []*Operation{
+		{Method: http.MethodGet, Path: basePath, Summary: "the dark mode and gray testing switches", Response: "GrayToggle", Text: true},
+		{Method: http.MethodPut, Path: basePath + "/on", Summary: "turn gray testing on, dark mode must be on", Response: "GrayToggle", Text: true},
+		{Method: http.MethodPut, Path: basePath + "/off", Summary: "turn gray testing off, dark mode must be on", Response: "GrayToggle", Text: true},
+	}
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/torchcc/crank4go/router/darklaunch_manager"
+	"github.com/torchcc/crank4go/util"
+)
+
+const instanceResourceBasePath string = "/dark-launch/instance"
+
+// DarkLaunchInstanceResource dark launches single connector instances, identified by the connectorInstanceID they register with
+type DarkLaunchInstanceResource struct {
+	basePath          string
+	darkLaunchManager *darklaunch_manager.DarkLaunchManager
+	*Filter
+}
+
+func NewDarkLaunchInstanceResource(manager *darklaunch_manager.DarkLaunchManager) *DarkLaunchInstanceResource {
+	return &DarkLaunchInstanceResource{
+		basePath:          instanceResourceBasePath,
+		darkLaunchManager: manager,
+		Filter:            &Filter{},
+	}
+}
+
+func (d *DarkLaunchInstanceResource) GetDarkInstances(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
+	list, expiries := d.darkLaunchManager.InstanceList(), d.darkLaunchManager.InstanceExpiries()
+	text := fmt.Sprintf("DarkMode Instances = %v, expiries = %s", list, formatExpiries(expiries))
+	respond(w, r, http.StatusOK, text, darkLaunchEntriesOf("instance", list, expiries))
+	return true
+}
+
+// @Path("/{instance}")
+func (d *DarkLaunchInstanceResource) GetDarkModeByInstance(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
+	instance := params.ByName("instance")
+	darkMode, expiries := d.darkLaunchManager.ContainsInstance(instance), d.darkLaunchManager.InstanceExpiries()
+	text := fmt.Sprintf("DarkMode = %v for instance = %s", darkMode, instance)
+	if expiresAt, ok := expiries[instance]; ok {
+		text += fmt.Sprintf(", expiresAt = %s", expiresAt.UTC().Format(time.RFC3339))
+	}
+	respond(w, r, http.StatusOK, text, darkLaunchEntryOf("instance", instance, darkMode, expiries))
+	return true
+}
+
+// @Path("/{instance}")
+func (d *DarkLaunchInstanceResource) PutEnableDarkModeByInstance(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
+	instance := params.ByName("instance")
+	if expiresAt, err := parseExpiry(r); err != nil {
+		d.errorHandle(w, r, instance, "Add instance, "+err.Error())
+	} else if err = d.darkLaunchManager.AddInstanceWithExpiry(instance, expiresAt); err != nil {
+		d.errorHandle(w, r, instance, "Add instance")
+	} else {
+		util.LOG.Infof("darkLaunch update: true, action: Add instance, instance: %s, expiresAt: %v", instance, expiresAt)
+		respond(w, r, http.StatusOK, "update dark launch manager successfully", darkLaunchEntryOf("instance", instance, true, d.darkLaunchManager.InstanceExpiries()))
+	}
+	return true
+}
+
+// @Path("/{instance}")
+func (d *DarkLaunchInstanceResource) DeleteDarkModeByInstance(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
+	instance := params.ByName("instance")
+	if err := d.darkLaunchManager.RemoveInstance(instance); err != nil {
+		d.errorHandle(w, r, instance, "Remove instance")
+	} else {
+		util.LOG.Infof("darkLaunch update: true, action: Remove instance, instance: %s", instance)
+		respond(w, r, http.StatusOK, fmt.Sprintf("instance: %s was deleted successfully from dark launch manager", instance), darkLaunchEntryOf("instance", instance, false, nil))
+	}
+	return true
+}
+
+func (d *DarkLaunchInstanceResource) errorHandle(respWriter http.ResponseWriter, req *http.Request, instance, action string) {
+	errorID := uuid.New().String()
+	util.LOG.Warningf("Receive invalid instance: %s, action: %s, errorID: %s", instance, action, errorID)
+	apiError := &ApiError{
+		Status:  http.StatusBadRequest,
+		Code:    "invalid_instance",
+		Message: fmt.Sprintf("invalid instance: %s, action: %s", instance, action),
+		ErrorID: errorID,
+	}
+	respondError(respWriter, req, apiError, fmt.Sprintf("Invalid request, invalid instance: %s, action: %s, ErrorID: %s", instance, action, errorID))
+}
+
+func (d *DarkLaunchInstanceResource) RegisterResourceToHttpRouter(httpRouter *httprouter.Router, rootPath string) {
+	basePath := rootPath + d.basePath
+	httpRouter.GET(basePath, d.convertToHttpRouterHandlerWithFilters(d.GetDarkInstances))
+	httpRouter.GET(basePath+"/:instance", d.convertToHttpRouterHandlerWithFilters(d.GetDarkModeByInstance))
+	httpRouter.PUT(basePath+"/:instance", d.convertToHttpRouterHandlerWithFilters(d.PutEnableDarkModeByInstance))
+	httpRouter.DELETE(basePath+"/:instance", d.convertToHttpRouterHandlerWithFilters(d.DeleteDarkModeByInstance))
+}
+
+func (d *DarkLaunchInstanceResource) Operations(rootPath string) []*Operation {
+	return darkLaunchOperations(rootPath+d.basePath, "instance", ":instance")
+}
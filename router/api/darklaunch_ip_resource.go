@@ -3,10 +3,11 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
-	MediaType "github.com/torchcc/crank4go/router/api/media_type"
 	"github.com/torchcc/crank4go/router/darklaunch_manager"
 	"github.com/torchcc/crank4go/util"
 )
@@ -28,37 +29,48 @@ func NewDarkLaunchIpResource(manager *darklaunch_manager.DarkLaunchManager) *Dar
 }
 
 func (d *DarkLaunchIpResource) GetDarkIps(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
-	RespTextPlainOk(w, fmt.Sprintf("DarkMode IPs = %v", d.darkLaunchManager.IpList()))
+	list, expiries := d.darkLaunchManager.IpList(), d.darkLaunchManager.IpExpiries()
+	text := fmt.Sprintf("DarkMode IPs = %v, expiries = %s", list, formatExpiries(expiries))
+	respond(w, r, http.StatusOK, text, darkLaunchEntriesOf("ip", list, expiries))
 	return true
 }
 
-// @Path("/{ip}")
+// @Path("/{ip}"), ip may be a CIDR range like 10.0.0.0/8
 func (d *DarkLaunchIpResource) GetDarkModeByHost(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
-	ip := params.ByName("ip")
-	RespTextPlainOk(w, fmt.Sprintf("DarkMode = %v for ip = %s", d.darkLaunchManager.ContainsIp(ip), ip))
+	ip := ipParam(params)
+	// the expiries are listed by the normalized ip, e.g. 1.2.3.4 for ::ffff:1.2.3.4 or 1.2.3.4:5432
+	key := darklaunch_manager.NormalizeIp(ip)
+	darkMode, expiries := d.darkLaunchManager.ContainsIp(ip), d.darkLaunchManager.IpExpiries()
+	text := fmt.Sprintf("DarkMode = %v for ip = %s", darkMode, ip)
+	if expiresAt, ok := expiries[key]; ok {
+		text += fmt.Sprintf(", expiresAt = %s", expiresAt.UTC().Format(time.RFC3339))
+	}
+	respond(w, r, http.StatusOK, text, darkLaunchEntryOf("ip", key, darkMode, expiries))
 	return true
 }
 
-// @Path("/{ip}")
+// @Path("/{ip}"), ip may be a CIDR range like 10.0.0.0/8
 func (d *DarkLaunchIpResource) PutEnableDarkModeByIp(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
-	ip := params.ByName("ip")
-	if err := d.darkLaunchManager.AddIp(ip); err != nil {
+	ip := ipParam(params)
+	if expiresAt, err := parseExpiry(r); err != nil {
+		d.errorHandle(w, r, ip, "Add IP, "+err.Error())
+	} else if err = d.darkLaunchManager.AddIpWithExpiry(ip, expiresAt); err != nil {
 		d.errorHandle(w, r, ip, "Add IP")
 	} else {
-		util.LOG.Infof("darkLaunch update: true, action: Add IP, ip: %s", ip)
-		RespTextPlainOk(w, "update dark launch manager successfully")
+		util.LOG.Infof("darkLaunch update: true, action: Add IP, ip: %s, expiresAt: %v", ip, expiresAt)
+		respond(w, r, http.StatusOK, "update dark launch manager successfully", darkLaunchEntryOf("ip", ip, true, d.darkLaunchManager.IpExpiries()))
 	}
 	return true
 }
 
-// @Path("/{ip}")
+// @Path("/{ip}"), ip may be a CIDR range like 10.0.0.0/8
 func (d *DarkLaunchIpResource) DeleteDarkModeByIp(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
-	ip := params.ByName("ip")
+	ip := ipParam(params)
 	if err := d.darkLaunchManager.RemoveIp(ip); err != nil {
 		d.errorHandle(w, r, ip, "Remove IP")
 	} else {
 		util.LOG.Infof("darkLaunch update: true, action: Remove IP, ip: %s", ip)
-		RespTextPlainOk(w, fmt.Sprintf("ip: %s was deleted successfully from dark launch manager", ip))
+		respond(w, r, http.StatusOK, fmt.Sprintf("ip: %s was deleted successfully from dark launch manager", ip), darkLaunchEntryOf("ip", ip, false, nil))
 	}
 	return true
 }
@@ -66,15 +78,28 @@ func (d *DarkLaunchIpResource) DeleteDarkModeByIp(w http.ResponseWriter, r *http
 func (d *DarkLaunchIpResource) errorHandle(respWriter http.ResponseWriter, req *http.Request, ip, action string) {
 	errorID := uuid.New().String()
 	util.LOG.Warningf("Receive invalid ip: %s, action: %s, errorID: %s", ip, action, errorID)
-	respWriter.Header().Add("Content-Type", MediaType.TextPlain)
-	respWriter.WriteHeader(http.StatusBadRequest)
-	_, _ = respWriter.Write([]byte(fmt.Sprintf("Invalid request, invalid ip: %s, action: %s, ErrorID: %s", ip, action, errorID)))
+	apiError := &ApiError{
+		Status:  http.StatusBadRequest,
+		Code:    "invalid_ip",
+		Message: fmt.Sprintf("invalid ip: %s, action: %s", ip, action),
+		ErrorID: errorID,
+	}
+	respondError(respWriter, req, apiError, fmt.Sprintf("Invalid request, invalid ip: %s, action: %s, ErrorID: %s", ip, action, errorID))
 }
 
 func (d *DarkLaunchIpResource) RegisterResourceToHttpRouter(httpRouter *httprouter.Router, rootPath string) {
 	basePath := rootPath + d.basePath
 	httpRouter.GET(basePath, d.convertToHttpRouterHandlerWithFilters(d.GetDarkIps))
-	httpRouter.GET(basePath+"/:ip", d.convertToHttpRouterHandlerWithFilters(d.GetDarkModeByHost))
-	httpRouter.PUT(basePath+"/:ip", d.convertToHttpRouterHandlerWithFilters(d.PutEnableDarkModeByIp))
-	httpRouter.DELETE(basePath+"/:ip", d.convertToHttpRouterHandlerWithFilters(d.DeleteDarkModeByIp))
+	httpRouter.GET(basePath+"/*ip", d.convertToHttpRouterHandlerWithFilters(d.GetDarkModeByHost))
+	httpRouter.PUT(basePath+"/*ip", d.convertToHttpRouterHandlerWithFilters(d.PutEnableDarkModeByIp))
+	httpRouter.DELETE(basePath+"/*ip", d.convertToHttpRouterHandlerWithFilters(d.DeleteDarkModeByIp))
+}
+
+func (d *DarkLaunchIpResource) Operations(rootPath string) []*Operation {
+	return darkLaunchOperations(rootPath+d.basePath, "ip", "*ip")
+}
+
+// ipParam the catch-all param keeps the slash of CIDR ranges, its leading slash is trimmed
+func ipParam(params httprouter.Params) string {
+	return strings.TrimPrefix(params.ByName("ip"), "/")
 }
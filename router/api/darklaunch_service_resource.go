@@ -3,10 +3,10 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
-	MediaType "github.com/torchcc/crank4go/router/api/media_type"
 	"github.com/torchcc/crank4go/router/darklaunch_manager"
 	"github.com/torchcc/crank4go/router/handler"
 	"github.com/torchcc/crank4go/util"
@@ -29,25 +29,34 @@ func NewDarkLaunchServiceResource(manager *darklaunch_manager.DarkLaunchManager)
 }
 
 func (d *DarkLaunchServiceResource) GetDarkServices(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
-	RespTextPlainOk(w, fmt.Sprintf("DarkMode Services = %v", d.darkLaunchManager.ServiceList()))
+	list, expiries := d.darkLaunchManager.ServiceList(), d.darkLaunchManager.ServiceExpiries()
+	text := fmt.Sprintf("DarkMode Services = %v, expiries = %s", list, formatExpiries(expiries))
+	respond(w, r, http.StatusOK, text, darkLaunchEntriesOf("service", list, expiries))
 	return true
 }
 
 // @Path("/{service}")
 func (d *DarkLaunchServiceResource) GetDarkModeByHost(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
 	service := params.ByName("service")
-	RespTextPlainOk(w, fmt.Sprintf("DarkMode = %v for service = %s", d.darkLaunchManager.ContainsService(service), service))
+	darkMode, expiries := d.darkLaunchManager.ContainsService(service), d.darkLaunchManager.ServiceExpiries()
+	text := fmt.Sprintf("DarkMode = %v for service = %s", darkMode, service)
+	if expiresAt, ok := expiries[service]; ok {
+		text += fmt.Sprintf(", expiresAt = %s", expiresAt.UTC().Format(time.RFC3339))
+	}
+	respond(w, r, http.StatusOK, text, darkLaunchEntryOf("service", service, darkMode, expiries))
 	return true
 }
 
 // @Path("/{service}")
 func (d *DarkLaunchServiceResource) PutEnableDarkModeByService(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
 	service := params.ByName("service")
-	if err := d.darkLaunchManager.AddService(service); err != nil {
+	if expiresAt, err := parseExpiry(r); err != nil {
+		d.errorHandle(w, r, service, "Add service, "+err.Error())
+	} else if err = d.darkLaunchManager.AddServiceWithExpiry(service, expiresAt); err != nil {
 		d.errorHandle(w, r, service, "Add service")
 	} else {
-		util.LOG.Infof("darkLaunch update: true, action: Add service, service: %s", service)
-		RespTextPlainOk(w, "update dark launch manager successfully")
+		util.LOG.Infof("darkLaunch update: true, action: Add service, service: %s, expiresAt: %v", service, expiresAt)
+		respond(w, r, http.StatusOK, "update dark launch manager successfully", darkLaunchEntryOf("service", service, true, d.darkLaunchManager.ServiceExpiries()))
 	}
 	return true
 }
@@ -59,7 +68,7 @@ func (d *DarkLaunchServiceResource) DeleteDarkModeByService(w http.ResponseWrite
 		d.errorHandle(w, r, service, "Remove service")
 	} else {
 		util.LOG.Infof("darkLaunch update: true, action: Remove service, service: %s", service)
-		RespTextPlainOk(w, fmt.Sprintf("service: %s was deleted successfully from dark launch manager", service))
+		respond(w, r, http.StatusOK, fmt.Sprintf("service: %s was deleted successfully from dark launch manager", service), darkLaunchEntryOf("service", service, false, nil))
 	}
 	return true
 }
@@ -67,9 +76,13 @@ func (d *DarkLaunchServiceResource) DeleteDarkModeByService(w http.ResponseWrite
 func (d *DarkLaunchServiceResource) errorHandle(respWriter http.ResponseWriter, req *http.Request, service, action string) {
 	errorID := uuid.New().String()
 	util.LOG.Warningf("Receive invalid service: %s, action: %s, errorID: %s", service, action, errorID)
-	respWriter.Header().Add("Content-Type", MediaType.TextPlain)
-	respWriter.WriteHeader(http.StatusBadRequest)
-	_, _ = respWriter.Write([]byte(fmt.Sprintf("Invalid request, invalid service: %s, action: %s, ErrorID: %s", service, action, errorID)))
+	apiError := &ApiError{
+		Status:  http.StatusBadRequest,
+		Code:    "invalid_service",
+		Message: fmt.Sprintf("invalid service: %s, action: %s", service, action),
+		ErrorID: errorID,
+	}
+	respondError(respWriter, req, apiError, fmt.Sprintf("Invalid request, invalid service: %s, action: %s, ErrorID: %s", service, action, errorID))
 }
 
 func (d *DarkLaunchServiceResource) RegisterResourceToHttpRouter(httpRouter *httprouter.Router, rootPath string) {
@@ -80,9 +93,14 @@ func (d *DarkLaunchServiceResource) RegisterResourceToHttpRouter(httpRouter *htt
 	httpRouter.DELETE(basePath+"/:service", d.convertToHttpRouterHandlerWithFilters(d.DeleteDarkModeByService))
 }
 
+func (d *DarkLaunchServiceResource) Operations(rootPath string) []*Operation {
+	return darkLaunchOperations(rootPath+d.basePath, "service", ":service")
+}
+
 type Filter struct {
 	reqFilters  []handler.XHandler
 	respFilters []handler.XHandler
+	auditor     *auditor
 }
 
 func (f *Filter) RespFilters() []handler.XHandler {
@@ -113,9 +131,23 @@ func (f *Filter) AddRespFilters(handlers ...handler.XHandler) *Filter {
 	return f
 }
 
+// convertToHttpRouterHandlerWithFilters the auditor is the outermost handler, so the calls rejected by the filters are audited too
 func (f *Filter) convertToHttpRouterHandlerWithFilters(function func(http.ResponseWriter, *http.Request, httprouter.Params) bool) func(http.ResponseWriter, *http.Request, httprouter.Params) {
-	return handler.NewXHttpHandler(handler.XHandlerFunc(function)).
+	serve := handler.NewXHttpHandler(handler.XHandlerFunc(function)).
 		AddReqHandlers(f.ReqFilters()...).
 		AddRespHandlers(f.RespFilters()...).
 		ServeXHTTP
+	if f.auditor == nil {
+		return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+			serve(w, handler.WithPrincipalHolder(r), params)
+		}
+	}
+	audited := f.auditor.wrap(func(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
+		serve(w, r, params)
+		return true
+	})
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		// the auditor reads the caller the AuthFilter records in the holder once the filters ran
+		audited(w, handler.WithPrincipalHolder(r), params)
+	}
 }
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/torchcc/crank4go/router/darklaunch_manager"
+	"github.com/torchcc/crank4go/util"
+)
+
+const versionResourceBasePath string = "/dark-launch/version"
+
+// DarkLaunchVersionResource dark launches all the connectors registered with a version label
+type DarkLaunchVersionResource struct {
+	basePath          string
+	darkLaunchManager *darklaunch_manager.DarkLaunchManager
+	*Filter
+}
+
+func NewDarkLaunchVersionResource(manager *darklaunch_manager.DarkLaunchManager) *DarkLaunchVersionResource {
+	return &DarkLaunchVersionResource{
+		basePath:          versionResourceBasePath,
+		darkLaunchManager: manager,
+		Filter:            &Filter{},
+	}
+}
+
+func (d *DarkLaunchVersionResource) GetDarkVersions(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
+	list, expiries := d.darkLaunchManager.VersionList(), d.darkLaunchManager.VersionExpiries()
+	text := fmt.Sprintf("DarkMode Versions = %v, expiries = %s", list, formatExpiries(expiries))
+	respond(w, r, http.StatusOK, text, darkLaunchEntriesOf("version", list, expiries))
+	return true
+}
+
+// @Path("/{version}")
+func (d *DarkLaunchVersionResource) GetDarkModeByVersion(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
+	version := params.ByName("version")
+	darkMode, expiries := d.darkLaunchManager.ContainsVersion(version), d.darkLaunchManager.VersionExpiries()
+	text := fmt.Sprintf("DarkMode = %v for version = %s", darkMode, version)
+	if expiresAt, ok := expiries[version]; ok {
+		text += fmt.Sprintf(", expiresAt = %s", expiresAt.UTC().Format(time.RFC3339))
+	}
+	respond(w, r, http.StatusOK, text, darkLaunchEntryOf("version", version, darkMode, expiries))
+	return true
+}
+
+// @Path("/{version}")
+func (d *DarkLaunchVersionResource) PutEnableDarkModeByVersion(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
+	version := params.ByName("version")
+	if expiresAt, err := parseExpiry(r); err != nil {
+		d.errorHandle(w, r, version, "Add version, "+err.Error())
+	} else if err = d.darkLaunchManager.AddVersionWithExpiry(version, expiresAt); err != nil {
+		d.errorHandle(w, r, version, "Add version")
+	} else {
+		util.LOG.Infof("darkLaunch update: true, action: Add version, version: %s, expiresAt: %v", version, expiresAt)
+		respond(w, r, http.StatusOK, "update dark launch manager successfully", darkLaunchEntryOf("version", version, true, d.darkLaunchManager.VersionExpiries()))
+	}
+	return true
+}
+
+// @Path("/{version}")
+func (d *DarkLaunchVersionResource) DeleteDarkModeByVersion(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
+	version := params.ByName("version")
+	if err := d.darkLaunchManager.RemoveVersion(version); err != nil {
+		d.errorHandle(w, r, version, "Remove version")
+	} else {
+		util.LOG.Infof("darkLaunch update: true, action: Remove version, version: %s", version)
+		respond(w, r, http.StatusOK, fmt.Sprintf("version: %s was deleted successfully from dark launch manager", version), darkLaunchEntryOf("version", version, false, nil))
+	}
+	return true
+}
+
+func (d *DarkLaunchVersionResource) errorHandle(respWriter http.ResponseWriter, req *http.Request, version, action string) {
+	errorID := uuid.New().String()
+	util.LOG.Warningf("Receive invalid version: %s, action: %s, errorID: %s", version, action, errorID)
+	apiError := &ApiError{
+		Status:  http.StatusBadRequest,
+		Code:    "invalid_version",
+		Message: fmt.Sprintf("invalid version: %s, action: %s", version, action),
+		ErrorID: errorID,
+	}
+	respondError(respWriter, req, apiError, fmt.Sprintf("Invalid request, invalid version: %s, action: %s, ErrorID: %s", version, action, errorID))
+}
+
+func (d *DarkLaunchVersionResource) RegisterResourceToHttpRouter(httpRouter *httprouter.Router, rootPath string) {
+	basePath := rootPath + d.basePath
+	httpRouter.GET(basePath, d.convertToHttpRouterHandlerWithFilters(d.GetDarkVersions))
+	httpRouter.GET(basePath+"/:version", d.convertToHttpRouterHandlerWithFilters(d.GetDarkModeByVersion))
+	httpRouter.PUT(basePath+"/:version", d.convertToHttpRouterHandlerWithFilters(d.PutEnableDarkModeByVersion))
+	httpRouter.DELETE(basePath+"/:version", d.convertToHttpRouterHandlerWithFilters(d.DeleteDarkModeByVersion))
+}
+
+func (d *DarkLaunchVersionResource) Operations(rootPath string) []*Operation {
+	return darkLaunchOperations(rootPath+d.basePath, "version", ":version")
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	MediaType "github.com/torchcc/crank4go/router/api/media_type"
+	"github.com/torchcc/crank4go/router/router_socket"
+	"github.com/torchcc/crank4go/util"
+)
+
+const (
+	eventsResourceBasePath  string = "/events"
+	eventsBufferSize               = 256
+	eventsHeartbeatInterval        = 15 * time.Second
+)
+
+// EventsResource streams the events of the websocketFarm as server-sent events, one JSON FarmEvent per event
+type EventsResource struct {
+	basePath      string
+	websocketFarm *router_socket.WebsocketFarm
+	*Filter
+}
+
+func NewEventsResource(websocketFarm *router_socket.WebsocketFarm) *EventsResource {
+	return &EventsResource{
+		basePath:      eventsResourceBasePath,
+		websocketFarm: websocketFarm,
+		Filter:        &Filter{},
+	}
+}
+
+// eventSubscriber buffers the events of one stream, the events which overflow the buffer of a slow client are dropped and counted
+type eventSubscriber struct {
+	events  chan *router_socket.FarmEvent
+	types   map[router_socket.FarmEventType]struct{}
+	dropped int64
+}
+
+func (s *eventSubscriber) AfterFarmEvent(event *router_socket.FarmEvent) {
+	if _, ok := s.types[event.Type]; len(s.types) > 0 && !ok {
+		return
+	}
+	select {
+	case s.events <- event:
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+	}
+}
+
+// GetEvents streams till the client goes away, `?types=route_appeared,route_emptied` streams only the given types
+func (e *EventsResource) GetEvents(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		errorID := uuid.New().String()
+		util.LOG.Errorf("the response writer does not support streaming, ErrorID=%s", errorID)
+		apiError := &ApiError{Status: http.StatusInternalServerError, Code: "streaming_unsupported", Message: "streaming is not supported", ErrorID: errorID}
+		writeJson(w, negotiateJson(r), apiError.Status, &apiErrorBody{Error: apiError})
+		return true
+	}
+	subscriber := &eventSubscriber{
+		events: make(chan *router_socket.FarmEvent, eventsBufferSize),
+		types:  make(map[router_socket.FarmEventType]struct{}),
+	}
+	for _, t := range strings.Split(r.URL.Query().Get("types"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			subscriber.types[router_socket.FarmEventType(t)] = struct{}{}
+		}
+	}
+	e.websocketFarm.AddListener(subscriber)
+	defer e.websocketFarm.RemoveListener(subscriber)
+
+	w.Header().Set("Content-Type", MediaType.TextEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeatInterval)
+	defer heartbeat.Stop()
+	var id int64
+	for {
+		select {
+		case <-r.Context().Done():
+			return true
+		case event := <-subscriber.events:
+			if dropped := atomic.SwapInt64(&subscriber.dropped, 0); dropped > 0 {
+				id++
+				_, _ = fmt.Fprintf(w, "id: %d\nevent: dropped\ndata: {\"dropped\":%d}\n\n", id, dropped)
+			}
+			data, _ := json.Marshal(event)
+			id++
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event.Type, data); err != nil {
+				util.LOG.Debugf("event stream to %s closed, err: %s", r.RemoteAddr, err.Error())
+				return true
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return true
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (e *EventsResource) RegisterResourceToHttpRouter(httpRouter *httprouter.Router, rootPath string) {
+	httpRouter.GET(rootPath+e.basePath, e.convertToHttpRouterHandlerWithFilters(e.GetEvents))
+}
+
+func (e *EventsResource) Operations(rootPath string) []*Operation {
+	return []*Operation{{
+		Method:   http.MethodGet,
+		Path:     rootPath + e.basePath,
+		Summary:  "server-sent events of the websocket farm, e.g. route_appeared, route_emptied, instance_appeared, dark_move and gray_toggle, each data is a JSON object",
+		Query:    []QueryParam{{Name: "types", Description: "comma separated event types to stream, all by default"}},
+		Response: "Object",
+	}}
+}
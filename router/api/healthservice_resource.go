@@ -25,19 +25,19 @@ func NewHealthResource(healthService service.HealthService) *HealthResource {
 
 // basePath
 func (h *HealthResource) GetHealthInfo(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
-	RespJsonOk(w, h.healthService.CreateHealthReport())
+	respondJson(w, r, http.StatusOK, h.healthService.CreateHealthReport())
 	return true
 }
 
 // @Path("/connectors")
 func (h *HealthResource) GetConnectorsInfo(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
-	RespJsonOk(w, h.healthService.CreateConnectorsReport())
+	respondJson(w, r, http.StatusOK, h.healthService.CreateConnectorsReport())
 	return true
 }
 
 // @Path("/categorizedConnectors")
 func (h *HealthResource) GetCategorizedConnectorsInfo(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
-	RespJsonOk(w, h.healthService.CreateCategorizedConnectorsReport())
+	respondJson(w, r, http.StatusOK, h.healthService.CreateCategorizedConnectorsReport())
 	return true
 }
 
@@ -65,13 +65,13 @@ func NewHealthServiceResource2(healthService service.HealthService) *HealthServi
 }
 
 func (h *HealthServiceResource2) GetHealthInfo(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
-	RespJsonOk(w, h.healthService.CreateHealthReport())
+	respondJson(w, r, http.StatusOK, h.healthService.CreateHealthReport())
 	return true
 }
 
 // @Path("/connectors")
 func (h *HealthServiceResource2) GetConnectorsInfo(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
-	RespJsonOk(w, h.healthService.CreateConnectorsReport())
+	respondJson(w, r, http.StatusOK, h.healthService.CreateConnectorsReport())
 	return true
 }
 
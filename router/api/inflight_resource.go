@@ -0,0 +1,66 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/torchcc/crank4go/router/router_socket"
+	"github.com/torchcc/crank4go/util"
+)
+
+const inflightResourceBasePath string = "/inflight"
+
+// InflightResource lists the proxied requests being served and aborts the stuck ones
+type InflightResource struct {
+	basePath      string
+	websocketFarm *router_socket.WebsocketFarm
+	*Filter
+}
+
+func NewInflightResource(websocketFarm *router_socket.WebsocketFarm) *InflightResource {
+	return &InflightResource{
+		basePath:      inflightResourceBasePath,
+		websocketFarm: websocketFarm,
+		Filter:        &Filter{},
+	}
+}
+
+func (i *InflightResource) GetInflight(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
+	respondJson(w, r, http.StatusOK, map[string]interface{}{"requests": i.websocketFarm.Inflight()})
+	return true
+}
+
+// @Path("/{id}"), the socket serving the request is closed and the client gets a 502
+func (i *InflightResource) DeleteInflight(w http.ResponseWriter, r *http.Request, params httprouter.Params) bool {
+	id := params.ByName("id")
+	if i.websocketFarm.CancelInflight(id) {
+		respondJson(w, r, http.StatusOK, map[string]interface{}{"id": id, "cancelled": true})
+		return true
+	}
+	errorID := uuid.New().String()
+	util.LOG.Warningf("Receive unknown in-flight request: %s, action: cancel, errorID: %s", id, errorID)
+	apiError := &ApiError{
+		Status:  http.StatusNotFound,
+		Code:    "unknown_inflight_request",
+		Message: fmt.Sprintf("request %s is not in flight", id),
+		ErrorID: errorID,
+	}
+	writeJson(w, negotiateJson(r), apiError.Status, &apiErrorBody{Error: apiError})
+	return true
+}
+
+func (i *InflightResource) RegisterResourceToHttpRouter(httpRouter *httprouter.Router, rootPath string) {
+	basePath := rootPath + i.basePath
+	httpRouter.GET(basePath, i.convertToHttpRouterHandlerWithFilters(i.GetInflight))
+	httpRouter.DELETE(basePath+"/:id", i.convertToHttpRouterHandlerWithFilters(i.DeleteInflight))
+}
+
+func (i *InflightResource) Operations(rootPath string) []*Operation {
+	basePath := rootPath + i.basePath
+	return []*Operation{
+		{Method: http.MethodGet, Path: basePath, Summary: "the proxied requests being served, the longest running first", Response: "InflightRequests"},
+		{Method: http.MethodDelete, Path: basePath + "/:id", Summary: "abort the request and close its socket, the client gets a 502", Response: "Object"},
+	}
+}
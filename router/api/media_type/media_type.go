@@ -9,4 +9,8 @@ const (
 	TextPlain                 = "text/plain"
 	TextXml                   = "text/xml"
 	TextHtml                  = "text/html"
+	TextEventStream           = "text/event-stream"
+
+	// ApplicationCrankV1Json the versioned JSON representation of the admin API, ask for it to pin the schema version
+	ApplicationCrankV1Json = "application/vnd.crank4go.v1+json"
 )
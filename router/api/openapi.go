@@ -0,0 +1,190 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	MediaType "github.com/torchcc/crank4go/router/api/media_type"
+)
+
+const openApiResourceBasePath string = "/openapi.json"
+
+// Operation an endpoint of the admin API as described in the OpenAPI document
+type Operation struct {
+	Method   string
+	Path     string // in httprouter syntax, path params like :service and *ip are documented as {service} and {ip}
+	Summary  string
+	Query    []QueryParam
+	Response string // the name of the response schema, see openApiSchemas
+	Text     bool   // the endpoint serves text/plain unless JSON is asked for by Accept
+}
+
+type QueryParam struct {
+	Name        string
+	Description string
+}
+
+// Documented resources describe their endpoints for the OpenAPI document
+type Documented interface {
+	Operations(rootPath string) []*Operation
+}
+
+// OpenApiResource serves the OpenAPI 3 document generated from the operations of the documented resources
+type OpenApiResource struct {
+	basePath string
+	document map[string]interface{}
+	*Filter
+}
+
+func NewOpenApiResource(title, version string) *OpenApiResource {
+	return &OpenApiResource{
+		basePath: openApiResourceBasePath,
+		document: map[string]interface{}{
+			"openapi": "3.0.3",
+			"info":    map[string]interface{}{"title": title, "version": version},
+			"paths":   map[string]interface{}{},
+			"components": map[string]interface{}{
+				"schemas": openApiSchemas(),
+				"securitySchemes": map[string]interface{}{
+					"basicAuth":  map[string]interface{}{"type": "http", "scheme": "basic"},
+					"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+				},
+			},
+			// authentication is optional unless admin authenticators are configured on the router
+			"security": []interface{}{map[string]interface{}{}, map[string]interface{}{"basicAuth": []string{}}, map[string]interface{}{"bearerAuth": []string{}}},
+		},
+		Filter: &Filter{},
+	}
+}
+
+// Describe adds the operations of the resources which are Documented under rootPath
+func (o *OpenApiResource) Describe(rootPath string, resources ...Resource) *OpenApiResource {
+	paths := o.document["paths"].(map[string]interface{})
+	for _, resource := range resources {
+		documented, ok := resource.(Documented)
+		if !ok {
+			continue
+		}
+		for _, op := range documented.Operations(rootPath) {
+			path, params := openApiPath(op.Path)
+			item, ok := paths[path].(map[string]interface{})
+			if !ok {
+				item = make(map[string]interface{})
+				paths[path] = item
+			}
+			for _, q := range op.Query {
+				params = append(params, map[string]interface{}{
+					"name": q.Name, "in": "query", "description": q.Description, "schema": map[string]interface{}{"type": "string"},
+				})
+			}
+			item[strings.ToLower(op.Method)] = map[string]interface{}{
+				"summary":    op.Summary,
+				"parameters": params,
+				"responses": map[string]interface{}{
+					"200":     map[string]interface{}{"description": "OK", "content": openApiContent(op.Response, op.Text)},
+					"default": map[string]interface{}{"description": "Error", "content": openApiContent("ApiError", op.Text)},
+				},
+			}
+		}
+	}
+	return o
+}
+
+func (o *OpenApiResource) GetDocument(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
+	respondJson(w, r, http.StatusOK, o.document)
+	return true
+}
+
+func (o *OpenApiResource) RegisterResourceToHttpRouter(httpRouter *httprouter.Router, rootPath string) {
+	httpRouter.GET(rootPath+o.basePath, o.convertToHttpRouterHandlerWithFilters(o.GetDocument))
+}
+
+// darkLaunchOperations the operations of the dark launch resources of each kind, param is the path param in httprouter syntax
+func darkLaunchOperations(basePath, kind, param string) []*Operation {
+	expiry := []QueryParam{
+		{Name: "ttl", Description: "the entry expires after the duration, e.g. 30m"},
+		{Name: "expiresAt", Description: "the entry expires at the RFC3339 time"},
+	}
+	return []*Operation{
+		{Method: http.MethodGet, Path: basePath, Summary: "list the " + kind + "s in dark mode", Response: "DarkLaunchEntries", Text: true},
+		{Method: http.MethodGet, Path: basePath + "/" + param, Summary: "whether the " + kind + " is in dark mode", Response: "DarkLaunchEntry", Text: true},
+		{Method: http.MethodPut, Path: basePath + "/" + param, Summary: "put the " + kind + " in dark mode", Query: expiry, Response: "DarkLaunchEntry", Text: true},
+		{Method: http.MethodDelete, Path: basePath + "/" + param, Summary: "remove the " + kind + " from dark mode", Response: "DarkLaunchEntry", Text: true},
+	}
+}
+
+// openApiPath converts a httprouter path to an OpenAPI path and its path params
+func openApiPath(routerPath string) (string, []interface{}) {
+	segments := strings.Split(routerPath, "/")
+	params := make([]interface{}, 0, 2)
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+			params = append(params, map[string]interface{}{
+				"name": segment[1:], "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+func openApiContent(schema string, text bool) map[string]interface{} {
+	ref := map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/" + schema}}
+	content := map[string]interface{}{MediaType.ApplicationJson: ref, MediaType.ApplicationCrankV1Json: ref}
+	if text {
+		content[MediaType.TextPlain] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+	}
+	return content
+}
+
+func openApiSchemas() map[string]interface{} {
+	str := map[string]interface{}{"type": "string"}
+	dateTime := map[string]interface{}{"type": "string", "format": "date-time"}
+	boolean := map[string]interface{}{"type": "boolean"}
+	integer := map[string]interface{}{"type": "integer"}
+	counts := map[string]interface{}{"type": "object", "additionalProperties": integer}
+	object := func(properties map[string]interface{}) map[string]interface{} {
+		required := make([]string, 0, len(properties))
+		for name := range properties {
+			required = append(required, name)
+		}
+		sort.Strings(required)
+		return map[string]interface{}{"type": "object", "properties": properties, "required": required}
+	}
+	entry := object(map[string]interface{}{"kind": str, "value": str, "darkMode": boolean})
+	entry["properties"].(map[string]interface{})["expiresAt"] = dateTime
+	record := map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+		"id": str, "time": dateTime, "principal": str, "role": str, "authMethod": str, "remoteAddr": str,
+		"method": str, "path": str, "params": map[string]interface{}{"type": "object", "additionalProperties": str},
+		"before": map[string]interface{}{}, "after": map[string]interface{}{}, "status": integer, "result": str, "error": str,
+	}}
+	return map[string]interface{}{
+		"ApiError": object(map[string]interface{}{
+			"error": object(map[string]interface{}{"status": integer, "code": str, "message": str, "errorId": str}),
+		}),
+		"DarkLaunchEntry": entry,
+		"DarkLaunchEntries": object(map[string]interface{}{
+			"kind": str, "entries": map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/components/schemas/DarkLaunchEntry"}},
+		}),
+		"GrayToggle": object(map[string]interface{}{"darkMode": boolean, "grayTesting": boolean}),
+		"AuditRecords": object(map[string]interface{}{
+			"records": map[string]interface{}{"type": "array", "items": record},
+		}),
+		"InstanceSockets": object(map[string]interface{}{
+			"connectorInstanceID": str, "draining": boolean, "idleSockets": integer, "inFlightSockets": integer,
+		}),
+		"InflightRequests": object(map[string]interface{}{
+			"requests": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+				"id": str, "method": str, "path": str, "route": str, "clientAddr": str, "routerSocketID": str,
+				"connectorInstanceID": str, "dark": boolean, "startTime": dateTime, "bytesSent": integer, "bytesReceived": integer,
+			})},
+		}),
+		"ConcurrencyStatus": object(map[string]interface{}{
+			"routeLimit": integer, "routeLimits": counts, "instanceLimit": integer, "instanceLimits": counts,
+			"queueTimeoutMs": integer, "routeInflight": counts, "instanceInflight": counts, "rejected": integer,
+		}),
+		"Object": map[string]interface{}{"type": "object"},
+	}
+}
@@ -44,7 +44,7 @@ func (r *RegistrationsResource) GetRegisterInfo(w http.ResponseWriter, req *http
 	}
 	servicesRegisterMap["default"] = remoteAddrs
 	util.LOG.Debugf("getRegisterInfo spent %v time, the request is from %s", time.Now().Sub(begin), req.RemoteAddr)
-	RespJsonOk(w, servicesRegisterMap)
+	respondJson(w, req, http.StatusOK, servicesRegisterMap)
 	return true
 }
 
@@ -52,3 +52,9 @@ func (r *RegistrationsResource) RegisterResourceToHttpRouter(httpRouter *httprou
 	basePath := rootPath + r.basePath
 	httpRouter.GET(basePath, r.convertToHttpRouterHandlerWithFilters(r.GetRegisterInfo))
 }
+
+func (r *RegistrationsResource) Operations(rootPath string) []*Operation {
+	return []*Operation{
+		{Method: http.MethodGet, Path: rootPath + r.basePath, Summary: "the connector ips registered for each route", Response: "Object"},
+	}
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	MediaType "github.com/torchcc/crank4go/router/api/media_type"
+)
+
+// ApiError the body of every failed admin call in JSON, ErrorID is also logged by the router
+type ApiError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"` // machine-readable, e.g. invalid_ip, dark_mode_off
+	Message string `json:"message"`
+	ErrorID string `json:"errorId"`
+}
+
+type apiErrorBody struct {
+	Error *ApiError `json:"error"`
+}
+
+// DarkLaunchEntry one ip, service, instance or version and whether it is in dark mode
+type DarkLaunchEntry struct {
+	Kind      string     `json:"kind"`
+	Value     string     `json:"value"`
+	DarkMode  bool       `json:"darkMode"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// DarkLaunchEntries all the entries of a kind in dark mode, in the order of their values
+type DarkLaunchEntries struct {
+	Kind    string             `json:"kind"`
+	Entries []*DarkLaunchEntry `json:"entries"`
+}
+
+// GrayToggle the dark mode switches of the router
+type GrayToggle struct {
+	DarkMode    bool `json:"darkMode"`
+	GrayTesting bool `json:"grayTesting"`
+}
+
+func darkLaunchEntryOf(kind, value string, darkMode bool, expiries map[string]time.Time) *DarkLaunchEntry {
+	entry := &DarkLaunchEntry{Kind: kind, Value: value, DarkMode: darkMode}
+	if expiresAt, ok := expiries[value]; ok && darkMode {
+		utc := expiresAt.UTC()
+		entry.ExpiresAt = &utc
+	}
+	return entry
+}
+
+func darkLaunchEntriesOf(kind string, values []string, expiries map[string]time.Time) *DarkLaunchEntries {
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	entries := &DarkLaunchEntries{Kind: kind, Entries: make([]*DarkLaunchEntry, 0, len(sorted))}
+	for _, value := range sorted {
+		entries.Entries = append(entries.Entries, darkLaunchEntryOf(kind, value, true, expiries))
+	}
+	return entries
+}
+
+// negotiate picks the media type of the response by the Accept header of the request, among the versioned JSON,
+// application/json and text/plain. defaultType is returned if the request accepts anything or none of them,
+// the resources which always served text keep text/plain as default so existing scripts do not break
+func negotiate(r *http.Request, defaultType string) string {
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	ranges := make([]mediaRange, 0, 4)
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && kv[0] == "q" {
+				if parsed, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, mediaRange{mediaType, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	for _, accepted := range ranges {
+		switch accepted.mediaType {
+		case MediaType.ApplicationCrankV1Json:
+			return MediaType.ApplicationCrankV1Json
+		case MediaType.ApplicationJson, "application/*":
+			return MediaType.ApplicationJson
+		case MediaType.TextPlain, "text/*":
+			return MediaType.TextPlain
+		case "*/*":
+			return defaultType
+		}
+	}
+	return defaultType
+}
+
+// respond writes text to clients which want text/plain, which is the default, and body in JSON to the others
+func respond(w http.ResponseWriter, r *http.Request, status int, text string, body interface{}) {
+	mediaType := negotiate(r, MediaType.TextPlain)
+	if mediaType == MediaType.TextPlain {
+		RespTextPlainWithStatus(w, text, status)
+	} else {
+		writeJson(w, mediaType, status, body)
+	}
+}
+
+// respondJson writes body in JSON, in the versioned media type if the client asks for it
+func respondJson(w http.ResponseWriter, r *http.Request, status int, body interface{}) {
+	writeJson(w, negotiateJson(r), status, body)
+}
+
+// negotiateJson the JSON media type for the resources which only serve JSON
+func negotiateJson(r *http.Request) string {
+	if mediaType := negotiate(r, MediaType.ApplicationJson); mediaType != MediaType.TextPlain {
+		return mediaType
+	}
+	return MediaType.ApplicationJson
+}
+
+// respondError writes apiError, or text to clients which want text/plain
+func respondError(w http.ResponseWriter, r *http.Request, apiError *ApiError, text string) {
+	respond(w, r, apiError.Status, text, &apiErrorBody{Error: apiError})
+}
+
+func writeJson(w http.ResponseWriter, mediaType string, status int, body interface{}) {
+	bytes, err := json.Marshal(body)
+	if err != nil {
+		RespTextPlainWithStatus(w, "failed to encode the response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", mediaType)
+	w.WriteHeader(status)
+	_, _ = w.Write(bytes)
+}
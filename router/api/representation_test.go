@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	MediaType "github.com/torchcc/crank4go/router/api/media_type"
+	"github.com/torchcc/crank4go/router/darklaunch_manager"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", MediaType.TextPlain},
+		{"*/*", MediaType.TextPlain},
+		{"application/json", MediaType.ApplicationJson},
+		{"application/vnd.crank4go.v1+json", MediaType.ApplicationCrankV1Json},
+		{"text/plain;q=0.5, application/json", MediaType.ApplicationJson},
+		{"application/json;q=0.2, text/*;q=0.8", MediaType.TextPlain},
+		{"application/json;q=0, */*", MediaType.TextPlain},
+		{"image/png", MediaType.TextPlain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept", tt.accept)
+			if got := negotiate(r, MediaType.TextPlain); got != tt.want {
+				t.Errorf("negotiate(%q) = %s, want %s", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+type noopServiceListener struct{}
+
+func (l *noopServiceListener) AfterDarkServiceAdded(service string)   {}
+func (l *noopServiceListener) AfterDarkServiceRevoked(service string) {}
+
+func TestDarkLaunchServiceRepresentations(t *testing.T) {
+	httpRouter := httprouter.New()
+	manager := darklaunch_manager.NewDarkLaunchManager().SetServiceListener(&noopServiceListener{})
+	NewDarkLaunchServiceResource(manager).RegisterResourceToHttpRouter(httpRouter, "/api")
+	call := func(method, path, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		httpRouter.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := call(http.MethodPut, "/api/dark-launch/service/order?ttl=1h", ""); !strings.Contains(w.Body.String(), "successfully") {
+		t.Errorf("text response of PUT is %q", w.Body.String())
+	}
+
+	w := call(http.MethodGet, "/api/dark-launch/service", MediaType.ApplicationCrankV1Json)
+	if w.Header().Get("Content-Type") != MediaType.ApplicationCrankV1Json {
+		t.Errorf("got Content-Type %s, want the versioned one", w.Header().Get("Content-Type"))
+	}
+	entries := &DarkLaunchEntries{}
+	if err := json.Unmarshal(w.Body.Bytes(), entries); err != nil {
+		t.Fatalf("invalid JSON %q: %s", w.Body.String(), err)
+	}
+	if entries.Kind != "service" || len(entries.Entries) != 1 || entries.Entries[0].Value != "order" || entries.Entries[0].ExpiresAt == nil {
+		t.Errorf("got %+v, want the order service with its expiry", entries)
+	}
+
+	w = call(http.MethodPut, "/api/dark-launch/service/order?ttl=forever", MediaType.ApplicationJson)
+	body := &apiErrorBody{}
+	if err := json.Unmarshal(w.Body.Bytes(), body); err != nil || w.Code != http.StatusBadRequest {
+		t.Fatalf("got %d %q, want a JSON error", w.Code, w.Body.String())
+	}
+	if body.Error.Code != "invalid_service" || body.Error.ErrorID == "" || body.Error.Status != http.StatusBadRequest {
+		t.Errorf("got %+v, want an invalid_service error with an error id", body.Error)
+	}
+}
+
+type noopIpListener struct{}
+
+func (l *noopIpListener) AfterDarkIpAdded(ip string)   {}
+func (l *noopIpListener) AfterDarkIpRevoked(ip string) {}
+
+func TestDarkLaunchIpExpiry(t *testing.T) {
+	httpRouter := httprouter.New()
+	manager := darklaunch_manager.NewDarkLaunchManager().SetIpListener(&noopIpListener{})
+	NewDarkLaunchIpResource(manager).RegisterResourceToHttpRouter(httpRouter, "/api")
+	put := httptest.NewRequest(http.MethodPut, "/api/dark-launch/ip/1.2.3.4?ttl=1h", nil)
+	httpRouter.ServeHTTP(httptest.NewRecorder(), put)
+
+	for _, ip := range []string{"1.2.3.4", "::ffff:1.2.3.4", "1.2.3.4:5432"} {
+		t.Run(ip, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/dark-launch/ip/"+ip, nil)
+			w := httptest.NewRecorder()
+			httpRouter.ServeHTTP(w, r)
+			if !strings.Contains(w.Body.String(), "DarkMode = true") || !strings.Contains(w.Body.String(), "expiresAt = ") {
+				t.Errorf("text response is %q, want the ip dark with its expiry", w.Body.String())
+			}
+
+			r.Header.Set("Accept", MediaType.ApplicationJson)
+			w = httptest.NewRecorder()
+			httpRouter.ServeHTTP(w, r)
+			entry := &DarkLaunchEntry{}
+			if err := json.Unmarshal(w.Body.Bytes(), entry); err != nil {
+				t.Fatalf("invalid JSON %q: %s", w.Body.String(), err)
+			}
+			if !entry.DarkMode || entry.Value != "1.2.3.4" || entry.ExpiresAt == nil {
+				t.Errorf("got %+v, want 1.2.3.4 with its expiry", entry)
+			}
+		})
+	}
+}
+
+func TestOpenApiPath(t *testing.T) {
+	path, params := openApiPath("/api/dark-launch/ip/*ip")
+	if path != "/api/dark-launch/ip/{ip}" || len(params) != 1 {
+		t.Errorf("got %s with %d params", path, len(params))
+	}
+	doc := NewOpenApiResource("test", "v1").Describe("/api", NewDarkLaunchServiceResource(darklaunch_manager.NewDarkLaunchManager()))
+	if _, ok := doc.document["paths"].(map[string]interface{})["/api/dark-launch/service/{service}"]; !ok {
+		t.Errorf("the service path is not documented")
+	}
+}
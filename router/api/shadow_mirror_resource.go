@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/torchcc/crank4go/router/shadow_mirror"
+)
+
+const shadowMirrorResourceBasePath string = "/shadow-mirror"
+
+type ShadowMirrorResource struct {
+	basePath     string
+	shadowMirror *shadow_mirror.ShadowMirror
+	*Filter
+}
+
+func NewShadowMirrorResource(shadowMirror *shadow_mirror.ShadowMirror) *ShadowMirrorResource {
+	return &ShadowMirrorResource{
+		basePath:     shadowMirrorResourceBasePath,
+		shadowMirror: shadowMirror,
+		Filter:       &Filter{},
+	}
+}
+
+// GetReport the counters of mirrored requests and the latest mismatches between primary and mirrored responses
+func (s *ShadowMirrorResource) GetReport(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
+	respondJson(w, r, http.StatusOK, s.shadowMirror.Report().ToMap())
+	return true
+}
+
+func (s *ShadowMirrorResource) DeleteReport(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
+	s.shadowMirror.Report().Reset()
+	respond(w, r, http.StatusOK, "shadow mirror report was reset successfully", s.shadowMirror.Report().ToMap())
+	return true
+}
+
+func (s *ShadowMirrorResource) RegisterResourceToHttpRouter(httpRouter *httprouter.Router, rootPath string) {
+	basePath := rootPath + s.basePath
+	httpRouter.GET(basePath, s.convertToHttpRouterHandlerWithFilters(s.GetReport))
+	httpRouter.DELETE(basePath, s.convertToHttpRouterHandlerWithFilters(s.DeleteReport))
+}
+
+func (s *ShadowMirrorResource) Operations(rootPath string) []*Operation {
+	basePath := rootPath + s.basePath
+	return []*Operation{
+		{Method: http.MethodGet, Path: basePath, Summary: "the counters and latest mismatches of mirrored requests", Response: "Object"},
+		{Method: http.MethodDelete, Path: basePath, Summary: "reset the shadow mirror report", Response: "Object", Text: true},
+	}
+}
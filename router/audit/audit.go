@@ -0,0 +1,148 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record one mutating call of the admin API
+type Record struct {
+	ID         string            `json:"id"`
+	Time       time.Time         `json:"time"`
+	Principal  string            `json:"principal"`            // who made the call, "anonymous" if the admin API is not authenticated
+	Role       string            `json:"role,omitempty"`       // the role of the principal
+	AuthMethod string            `json:"authMethod,omitempty"` // bearer, basic or mtls
+	RemoteAddr string            `json:"remoteAddr"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Params     map[string]string `json:"params,omitempty"`
+	Before     interface{}       `json:"before,omitempty"` // the state of the resource before the call
+	After      interface{}       `json:"after,omitempty"`  // the state of the resource after the call
+	Status     int               `json:"status"`
+	Result     string            `json:"result"`          // success or failure
+	Error      string            `json:"error,omitempty"` // the response body of a failed call
+}
+
+// Sink stores audit records
+type Sink interface {
+	Write(record *Record) error
+	// Query returns the records with from <= time < to in time order, zero from or to means unbounded.
+	// only the latest limit records are returned if there are more
+	Query(from, to time.Time, limit int) ([]*Record, error)
+}
+
+// DefaultFilePath the file the router audits to unless another sink is configured, relative to the working directory
+const DefaultFilePath = "crank4go-audit.jsonl"
+
+// FileSink appends records to a file as JSON lines, the file is created on the first write
+type FileSink struct {
+	path string
+	lock sync.Mutex
+	file *os.File
+}
+
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+func (s *FileSink) Write(record *Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file == nil {
+		if s.file, err = os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640); err != nil {
+			return err
+		}
+	}
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+func (s *FileSink) Query(from, to time.Time, limit int) ([]*Record, error) {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return make([]*Record, 0), nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	records := make([]*Record, 0, 64)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		record := &Record{}
+		if json.Unmarshal(scanner.Bytes(), record) != nil {
+			continue // a line torn by a crash is skipped
+		}
+		if matches(record, from, to) {
+			records = append(records, record)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return latest(records, limit), nil
+}
+
+// Close closes the file, it is opened again on the next write
+func (s *FileSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// MemorySink keeps the latest records in memory, it is meant for tests and routers without persistent storage
+type MemorySink struct {
+	lock       sync.Mutex
+	maxRecords int
+	records    []*Record
+}
+
+func NewMemorySink(maxRecords int) *MemorySink {
+	return &MemorySink{maxRecords: maxRecords, records: make([]*Record, 0, 64)}
+}
+
+func (s *MemorySink) Write(record *Record) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.maxRecords > 0 && len(s.records) >= s.maxRecords {
+		s.records = s.records[1:]
+	}
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *MemorySink) Query(from, to time.Time, limit int) ([]*Record, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	records := make([]*Record, 0, len(s.records))
+	for _, record := range s.records {
+		if matches(record, from, to) {
+			records = append(records, record)
+		}
+	}
+	return latest(records, limit), nil
+}
+
+func matches(record *Record, from, to time.Time) bool {
+	return (from.IsZero() || !record.Time.Before(from)) && (to.IsZero() || record.Time.Before(to))
+}
+
+func latest(records []*Record, limit int) []*Record {
+	if limit > 0 && len(records) > limit {
+		return records[len(records)-limit:]
+	}
+	return records
+}
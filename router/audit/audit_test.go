@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSinks(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fileSink := NewFileSink(filepath.Join(t.TempDir(), "audit.jsonl"))
+	defer fileSink.Close()
+	sinks := map[string]Sink{"file": fileSink, "memory": NewMemorySink(0)}
+
+	for name, sink := range sinks {
+		for i := 0; i < 5; i++ {
+			record := &Record{ID: string(rune('a' + i)), Time: base.Add(time.Duration(i) * time.Minute), Method: "PUT", Status: 200, Result: "success"}
+			if err := sink.Write(record); err != nil {
+				t.Fatalf("%s: failed to write record: %s", name, err)
+			}
+		}
+		tests := []struct {
+			name     string
+			from, to time.Time
+			limit    int
+			want     []string
+		}{
+			{"unbounded", time.Time{}, time.Time{}, 0, []string{"a", "b", "c", "d", "e"}},
+			{"from is inclusive", base.Add(3 * time.Minute), time.Time{}, 0, []string{"d", "e"}},
+			{"to is exclusive", time.Time{}, base.Add(2 * time.Minute), 0, []string{"a", "b"}},
+			{"range", base.Add(time.Minute), base.Add(4 * time.Minute), 0, []string{"b", "c", "d"}},
+			{"limit keeps the latest", time.Time{}, time.Time{}, 2, []string{"d", "e"}},
+		}
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				records, err := sink.Query(tt.from, tt.to, tt.limit)
+				if err != nil {
+					t.Fatalf("query failed: %s", err)
+				}
+				if len(records) != len(tt.want) {
+					t.Fatalf("got %d records, want %v", len(records), tt.want)
+				}
+				for i, record := range records {
+					if record.ID != tt.want[i] {
+						t.Errorf("record %d is %s, want %s", i, record.ID, tt.want[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestFileSinkSkipsTornLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink := NewFileSink(path)
+	defer sink.Close()
+	_ = sink.Write(&Record{ID: "a", Time: time.Now()})
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0640)
+	_, _ = file.WriteString("{\"id\":\"torn\n")
+	_ = file.Close()
+	_ = sink.Write(&Record{ID: "b", Time: time.Now()})
+
+	records, err := sink.Query(time.Time{}, time.Time{}, 0)
+	if err != nil || len(records) != 2 || records[0].ID != "a" || records[1].ID != "b" {
+		t.Errorf("got %v, %v, want records a and b", records, err)
+	}
+}
+
+func TestMissingFileHasNoRecords(t *testing.T) {
+	records, err := NewFileSink(filepath.Join(t.TempDir(), "none.jsonl")).Query(time.Time{}, time.Time{}, 0)
+	if err != nil || len(records) != 0 {
+		t.Errorf("got %v, %v, want no records", records, err)
+	}
+}
+
+func TestMemorySinkKeepsLatest(t *testing.T) {
+	sink := NewMemorySink(2)
+	for _, id := range []string{"a", "b", "c"} {
+		_ = sink.Write(&Record{ID: id, Time: time.Now()})
+	}
+	records, _ := sink.Query(time.Time{}, time.Time{}, 0)
+	if len(records) != 2 || records[0].ID != "b" || records[1].ID != "c" {
+		t.Errorf("got %v, want records b and c", records)
+	}
+}
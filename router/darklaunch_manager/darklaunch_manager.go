@@ -2,9 +2,13 @@ package darklaunch_manager
 
 import (
 	"errors"
+	"net/netip"
 	"regexp"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/torchcc/crank4go/util"
 )
 
 type IpListener interface {
@@ -24,30 +28,54 @@ type ServiceListener interface {
 	AfterDarkServiceRevoked(revokedService string)
 }
 
+type InstanceListener interface {
+	// AfterDarkInstanceAdded trigger something after a connector instance is marked as dark instance
+	AfterDarkInstanceAdded(addedInstance string)
+
+	// AfterDarkInstanceRevoked trigger something after a connector instance is revoked from dark instance list
+	AfterDarkInstanceRevoked(revokedInstance string)
+}
+
+type VersionListener interface {
+	// AfterDarkVersionAdded trigger something after a connector version label is marked as dark version
+	AfterDarkVersionAdded(addedVersion string)
+
+	// AfterDarkVersionRevoked trigger something after a connector version label is revoked from dark version list
+	AfterDarkVersionRevoked(revokedVersion string)
+}
+
 type DarkLaunchManager struct {
-	currentIps      map[string]struct{}
-	currentServices map[string]struct{}
-	ipListener      IpListener
-	serviceListener ServiceListener
-	path            string
+	lock             sync.RWMutex
+	currentIps       map[string]time.Time // the value is the expiry of the entry, zero value means it never expires
+	currentServices  map[string]time.Time
+	currentInstances map[string]time.Time // connectorInstanceIDs
+	currentVersions  map[string]time.Time // version labels sent by connectors at registration
+	ipListener       IpListener
+	serviceListener  ServiceListener
+	instanceListener InstanceListener
+	versionListener  VersionListener
+	errorRateMonitor *ErrorRateMonitor
+	cancelSweeper    func()
+	path             string
 }
 
 func NewDarkLaunchManager() *DarkLaunchManager {
-	return &DarkLaunchManager{
-		currentIps:      make(map[string]struct{}),
-		currentServices: make(map[string]struct{}),
-	}
+	return NewDarkLaunchManager2("")
 }
 
 func NewDarkLaunchManager2(path string) *DarkLaunchManager {
 	return &DarkLaunchManager{
-		path:            path,
-		currentIps:      make(map[string]struct{}),
-		currentServices: make(map[string]struct{}),
+		path:             path,
+		currentIps:       make(map[string]time.Time),
+		currentServices:  make(map[string]time.Time),
+		currentInstances: make(map[string]time.Time),
+		currentVersions:  make(map[string]time.Time),
 	}
 }
 
 func (m *DarkLaunchManager) IpList() []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 	list := make([]string, 0, 8)
 	for ip := range m.currentIps {
 		list = append(list, ip)
@@ -56,6 +84,8 @@ func (m *DarkLaunchManager) IpList() []string {
 }
 
 func (m *DarkLaunchManager) ServiceList() []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 	list := make([]string, 0, 8)
 	for service := range m.currentServices {
 		list = append(list, service)
@@ -63,9 +93,97 @@ func (m *DarkLaunchManager) ServiceList() []string {
 	return list
 }
 
+func (m *DarkLaunchManager) InstanceList() []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return keysOf(m.currentInstances)
+}
+
+func (m *DarkLaunchManager) VersionList() []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return keysOf(m.currentVersions)
+}
+
+func keysOf(entries map[string]time.Time) []string {
+	list := make([]string, 0, 8)
+	for entry := range entries {
+		list = append(list, entry)
+	}
+	return list
+}
+
+// IpExpiries returns the dark ips which expire, in format of map[ip]expiresAt
+func (m *DarkLaunchManager) IpExpiries() map[string]time.Time {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return expiriesOf(m.currentIps)
+}
+
+// ServiceExpiries returns the dark services which expire, in format of map[service]expiresAt
+func (m *DarkLaunchManager) ServiceExpiries() map[string]time.Time {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return expiriesOf(m.currentServices)
+}
+
+func (m *DarkLaunchManager) InstanceExpiries() map[string]time.Time {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return expiriesOf(m.currentInstances)
+}
+
+func (m *DarkLaunchManager) VersionExpiries() map[string]time.Time {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return expiriesOf(m.currentVersions)
+}
+
+// Snapshot the whole dark launch state, entries map to their expiry in RFC3339, or "" if they never expire
+func (m *DarkLaunchManager) Snapshot() map[string]interface{} {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return map[string]interface{}{
+		"grayTesting": IsGrayTestingOn(),
+		"ips":         snapshotOf(m.currentIps),
+		"services":    snapshotOf(m.currentServices),
+		"instances":   snapshotOf(m.currentInstances),
+		"versions":    snapshotOf(m.currentVersions),
+	}
+}
+
+func snapshotOf(entries map[string]time.Time) map[string]string {
+	snapshot := make(map[string]string)
+	for entry, expiresAt := range entries {
+		snapshot[entry] = ""
+		if !expiresAt.IsZero() {
+			snapshot[entry] = expiresAt.UTC().Format(time.RFC3339)
+		}
+	}
+	return snapshot
+}
+
+func expiriesOf(entries map[string]time.Time) map[string]time.Time {
+	expiries := make(map[string]time.Time)
+	for entry, expiresAt := range entries {
+		if !expiresAt.IsZero() {
+			expiries[entry] = expiresAt
+		}
+	}
+	return expiries
+}
+
 func (m *DarkLaunchManager) AddIp(ip string) error {
-	if isValidIp(ip) {
-		m.currentIps[ip] = struct{}{}
+	return m.AddIpWithExpiry(ip, time.Time{})
+}
+
+// AddIpWithExpiry the ip is revoked automatically by the expiry sweeper after expiresAt, a zero expiresAt never expires.
+// adding an existing ip updates its expiry
+func (m *DarkLaunchManager) AddIpWithExpiry(ip string, expiresAt time.Time) error {
+	if ip, ok := normalizeIp(ip); ok {
+		m.lock.Lock()
+		m.currentIps[ip] = expiresAt
+		m.lock.Unlock()
 		m.ipListener.AfterDarkIpAdded(ip)
 		return nil
 	} else {
@@ -74,8 +192,16 @@ func (m *DarkLaunchManager) AddIp(ip string) error {
 }
 
 func (m *DarkLaunchManager) AddService(service string) error {
+	return m.AddServiceWithExpiry(service, time.Time{})
+}
+
+// AddServiceWithExpiry the service is revoked automatically by the expiry sweeper after expiresAt, a zero expiresAt never expires.
+// adding an existing service updates its expiry
+func (m *DarkLaunchManager) AddServiceWithExpiry(service string, expiresAt time.Time) error {
 	if isValidService(service) {
-		m.currentServices[service] = struct{}{}
+		m.lock.Lock()
+		m.currentServices[service] = expiresAt
+		m.lock.Unlock()
 		m.serviceListener.AfterDarkServiceAdded(service)
 		return nil
 	} else {
@@ -83,56 +209,261 @@ func (m *DarkLaunchManager) AddService(service string) error {
 	}
 }
 
+func (m *DarkLaunchManager) AddInstance(connectorInstanceID string) error {
+	return m.AddInstanceWithExpiry(connectorInstanceID, time.Time{})
+}
+
+func (m *DarkLaunchManager) AddVersion(version string) error {
+	return m.AddVersionWithExpiry(version, time.Time{})
+}
+
+// AddInstanceWithExpiry marks all sockets of the connector instance as dark, a zero expiresAt never expires
+func (m *DarkLaunchManager) AddInstanceWithExpiry(connectorInstanceID string, expiresAt time.Time) error {
+	if !isValidLabel(connectorInstanceID) {
+		return errors.New("invalid connector instance: " + connectorInstanceID)
+	}
+	m.lock.Lock()
+	m.currentInstances[connectorInstanceID] = expiresAt
+	m.lock.Unlock()
+	m.instanceListener.AfterDarkInstanceAdded(connectorInstanceID)
+	return nil
+}
+
+// AddVersionWithExpiry marks all sockets registered with the version label as dark, a zero expiresAt never expires
+func (m *DarkLaunchManager) AddVersionWithExpiry(version string, expiresAt time.Time) error {
+	if !isValidLabel(version) {
+		return errors.New("invalid version: " + version)
+	}
+	m.lock.Lock()
+	m.currentVersions[version] = expiresAt
+	m.lock.Unlock()
+	m.versionListener.AfterDarkVersionAdded(version)
+	return nil
+}
+
+func (m *DarkLaunchManager) RemoveInstance(connectorInstanceID string) error {
+	m.lock.Lock()
+	if _, ok := m.currentInstances[connectorInstanceID]; !ok {
+		m.lock.Unlock()
+		return errors.New("connector instance: " + connectorInstanceID + " is not in current list")
+	}
+	delete(m.currentInstances, connectorInstanceID)
+	m.lock.Unlock()
+	m.instanceListener.AfterDarkInstanceRevoked(connectorInstanceID)
+	return nil
+}
+
+func (m *DarkLaunchManager) RemoveVersion(version string) error {
+	m.lock.Lock()
+	if _, ok := m.currentVersions[version]; !ok {
+		m.lock.Unlock()
+		return errors.New("version: " + version + " is not in current list")
+	}
+	delete(m.currentVersions, version)
+	m.lock.Unlock()
+	m.versionListener.AfterDarkVersionRevoked(version)
+	return nil
+}
+
 func (m *DarkLaunchManager) RemoveIp(ip string) error {
+	ip, _ = normalizeIp(ip)
+	m.lock.Lock()
 	if _, ok := m.currentIps[ip]; ok {
 		delete(m.currentIps, ip)
+		m.lock.Unlock()
 		m.ipListener.AfterDarkIpRevoked(ip)
 		return nil
 	} else {
+		m.lock.Unlock()
 		return errors.New("ip: " + ip + " is not in current list")
 	}
 }
 
 func (m *DarkLaunchManager) RemoveService(service string) error {
+	m.lock.Lock()
 	if _, ok := m.currentServices[service]; ok {
 		delete(m.currentServices, service)
+		m.lock.Unlock()
 		m.serviceListener.AfterDarkServiceRevoked(service)
 		return nil
 	} else {
+		m.lock.Unlock()
 		return errors.New("service: " + service + " is not in current list")
 	}
 }
 
 func (m *DarkLaunchManager) IsDarkModeOn() bool {
-	return len(m.currentServices) != 0 || len(m.currentIps) != 0
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return len(m.currentServices) != 0 || len(m.currentIps) != 0 || len(m.currentInstances) != 0 || len(m.currentVersions) != 0
 }
 
-// judge if ip is in dark mode
+// ContainsIp judges if ip is in dark mode, ip may also be a remote address with port or a configured CIDR range
 func (m *DarkLaunchManager) ContainsIp(ip string) bool {
-	if _, ok := m.currentIps[ip]; ok {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	normalized, _ := normalizeIp(ip)
+	if _, ok := m.currentIps[normalized]; ok {
 		return true
 	}
+	// ip may be a remote address with port, or be covered by a CIDR range
+	addr, ok := util.ParseHostAddr(ip)
+	if !ok {
+		return false
+	}
+	if _, ok = m.currentIps[addr.String()]; ok {
+		return true
+	}
+	for entry := range m.currentIps {
+		if !strings.Contains(entry, "/") {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
 	return false
 }
 
-// judge if ip is in dark mode
+// judge if service is in dark mode
 func (m *DarkLaunchManager) ContainsService(service string) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 	if _, ok := m.currentServices[service]; ok {
 		return true
 	}
 	return false
 }
 
+// judge if connector instance is in dark mode
+func (m *DarkLaunchManager) ContainsInstance(connectorInstanceID string) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	_, ok := m.currentInstances[connectorInstanceID]
+	return ok
+}
+
+// judge if version label is in dark mode, blank version never is
+func (m *DarkLaunchManager) ContainsVersion(version string) bool {
+	if version == "" {
+		return false
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	_, ok := m.currentVersions[version]
+	return ok
+}
+
+// StartExpirySweeper revokes expired ips and services every interval through the registered listeners
+func (m *DarkLaunchManager) StartExpirySweeper(interval time.Duration) {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	done := make(chan struct{})
+	m.lock.Lock()
+	if m.cancelSweeper != nil {
+		m.lock.Unlock()
+		return
+	}
+	m.cancelSweeper = func() { close(done) }
+	m.lock.Unlock()
+	util.LOG.Infof("dark launch expiry sweeper started with %v period", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				m.sweepExpired(now)
+			}
+		}
+	}()
+}
+
+func (m *DarkLaunchManager) StopExpirySweeper() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.cancelSweeper != nil {
+		m.cancelSweeper()
+		m.cancelSweeper = nil
+	}
+}
+
+func (m *DarkLaunchManager) sweepExpired(now time.Time) {
+	for _, ip := range m.takeExpired(m.currentIps, now) {
+		util.LOG.Infof("darkLaunch update: true, action: Expire IP, ip: %s", ip)
+		m.ipListener.AfterDarkIpRevoked(ip)
+	}
+	for _, service := range m.takeExpired(m.currentServices, now) {
+		util.LOG.Infof("darkLaunch update: true, action: Expire service, service: %s", service)
+		m.serviceListener.AfterDarkServiceRevoked(service)
+	}
+	for _, instance := range m.takeExpired(m.currentInstances, now) {
+		util.LOG.Infof("darkLaunch update: true, action: Expire instance, instance: %s", instance)
+		m.instanceListener.AfterDarkInstanceRevoked(instance)
+	}
+	for _, version := range m.takeExpired(m.currentVersions, now) {
+		util.LOG.Infof("darkLaunch update: true, action: Expire version, version: %s", version)
+		m.versionListener.AfterDarkVersionRevoked(version)
+	}
+}
+
+// takeExpired deletes the expired entries and returns them
+func (m *DarkLaunchManager) takeExpired(entries map[string]time.Time, now time.Time) []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	expired := make([]string, 0, 8)
+	for entry, expiresAt := range entries {
+		if !expiresAt.IsZero() && !expiresAt.After(now) {
+			delete(entries, entry)
+			expired = append(expired, entry)
+		}
+	}
+	return expired
+}
+
 func (m *DarkLaunchManager) SetServiceListener(serviceListener ServiceListener) *DarkLaunchManager {
 	m.serviceListener = serviceListener
 	return m
 }
 
+func (m *DarkLaunchManager) ErrorRateMonitor() *ErrorRateMonitor {
+	return m.errorRateMonitor
+}
+
+// SetErrorRateMonitor attaches a monitor which rolls the dark launch back when dark connectors keep failing
+func (m *DarkLaunchManager) SetErrorRateMonitor(errorRateMonitor *ErrorRateMonitor) *DarkLaunchManager {
+	if errorRateMonitor != nil {
+		errorRateMonitor.manager = m
+	}
+	m.errorRateMonitor = errorRateMonitor
+	return m
+}
+
 func (m *DarkLaunchManager) SetIpListener(ipListener IpListener) *DarkLaunchManager {
 	m.ipListener = ipListener
 	return m
 }
 
+func (m *DarkLaunchManager) SetInstanceListener(instanceListener InstanceListener) *DarkLaunchManager {
+	m.instanceListener = instanceListener
+	return m
+}
+
+func (m *DarkLaunchManager) SetVersionListener(versionListener VersionListener) *DarkLaunchManager {
+	m.versionListener = versionListener
+	return m
+}
+
+var labelPattern = regexp.MustCompile("^[\\w][\\w.:+-]*$")
+
+// isValidLabel validates connectorInstanceIDs and version labels
+func isValidLabel(label string) bool {
+	return len(label) <= 128 && labelPattern.MatchString(label)
+}
+
 func isValidService(service string) bool {
 	if matched, err := regexp.MatchString("^[a-zA-Z]+((-|_)?\\w*)*$", service); err != nil || !matched {
 		return false
@@ -140,21 +471,23 @@ func isValidService(service string) bool {
 	return true
 }
 
-func isValidIp(ip string) bool {
-	if ip == "" {
-		return false
+// NormalizeIp the key an ip, a CIDR range or a remote address with port is listed by, e.g. 10.0.0.1 for ::ffff:10.0.0.1 or 10.0.0.1:5432.
+// an invalid ip is returned as it is
+func NormalizeIp(ip string) string {
+	if normalized, ok := normalizeIp(ip); ok {
+		return normalized
 	}
-	if groups := strings.Split(ip, "."); len(groups) != 4 {
-		return false
-	} else {
-		for _, s := range groups {
-			if len(s) == 0 {
-				return false
-			}
-			if i, err := strconv.Atoi(s); err != nil || i < 0 || i > 255 {
-				return false
-			}
-		}
-		return true
+	if addr, ok := util.ParseHostAddr(ip); ok {
+		return addr.String()
+	}
+	return ip
+}
+
+// normalizeIp formats ips and CIDR ranges canonically, e.g. ::ffff:10.0.0.1 to 10.0.0.1 and 10.1.2.3/8 to 10.0.0.0/8
+func normalizeIp(ip string) (string, bool) {
+	prefix, err := util.ParseIpRange(ip)
+	if err != nil {
+		return ip, false
 	}
+	return util.FormatIpRange(prefix), true
 }
@@ -0,0 +1,119 @@
+package darklaunch_manager
+
+import (
+	"testing"
+	"time"
+)
+
+type recordingListener struct {
+	revoked []string
+}
+
+func (l *recordingListener) AfterDarkIpAdded(string) {}
+func (l *recordingListener) AfterDarkIpRevoked(revokedIp string) {
+	l.revoked = append(l.revoked, revokedIp)
+}
+func (l *recordingListener) AfterDarkServiceAdded(string) {}
+func (l *recordingListener) AfterDarkServiceRevoked(revoked string) {
+	l.revoked = append(l.revoked, revoked)
+}
+func (l *recordingListener) AfterDarkInstanceAdded(string) {}
+func (l *recordingListener) AfterDarkInstanceRevoked(revoked string) {
+	l.revoked = append(l.revoked, revoked)
+}
+func (l *recordingListener) AfterDarkVersionAdded(string) {}
+func (l *recordingListener) AfterDarkVersionRevoked(revoked string) {
+	l.revoked = append(l.revoked, revoked)
+}
+
+func TestSweepExpired(t *testing.T) {
+	now := time.Now()
+	listener := &recordingListener{}
+	m := NewDarkLaunchManager().SetIpListener(listener).SetServiceListener(listener)
+	_ = m.AddIpWithExpiry("10.0.0.1", now.Add(-time.Second))
+	_ = m.AddIpWithExpiry("10.0.0.2", now.Add(time.Hour))
+	_ = m.AddIp("10.0.0.3")
+	_ = m.AddServiceWithExpiry("a", now)
+	_ = m.AddService("b")
+
+	m.sweepExpired(now)
+
+	if len(listener.revoked) != 2 {
+		t.Fatalf("revoked = %v, want [10.0.0.1 a]", listener.revoked)
+	}
+	for entry, want := range map[string]bool{"10.0.0.1": false, "10.0.0.2": true, "10.0.0.3": true} {
+		if got := m.ContainsIp(entry); got != want {
+			t.Errorf("ContainsIp(%s) = %v, want %v", entry, got, want)
+		}
+	}
+	if m.ContainsService("a") || !m.ContainsService("b") {
+		t.Errorf("services = %v, want [b]", m.ServiceList())
+	}
+	if expiries := m.IpExpiries(); len(expiries) != 1 || !expiries["10.0.0.2"].Equal(now.Add(time.Hour)) {
+		t.Errorf("IpExpiries() = %v", expiries)
+	}
+}
+
+func TestInstancesAndVersions(t *testing.T) {
+	listener := &recordingListener{}
+	m := NewDarkLaunchManager().SetInstanceListener(listener).SetVersionListener(listener)
+	tests := []struct {
+		name    string
+		add     func(string) error
+		entry   string
+		wantErr bool
+	}{
+		{name: "instance", add: m.AddInstance, entry: "1b4e28ba-2fa1-11d2-883f-0016d3cca427"},
+		{name: "version", add: m.AddVersion, entry: "v2.3.1+build.7"},
+		{name: "blankVersion", add: m.AddVersion, entry: "", wantErr: true},
+		{name: "versionWithSpace", add: m.AddVersion, entry: "v2 beta", wantErr: true},
+		{name: "instanceWithSlash", add: m.AddInstance, entry: "a/b", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.add(tt.entry); (err != nil) != tt.wantErr {
+				t.Errorf("add(%q) err = %v, wantErr %v", tt.entry, err, tt.wantErr)
+			}
+		})
+	}
+	if !m.ContainsInstance("1b4e28ba-2fa1-11d2-883f-0016d3cca427") || !m.ContainsVersion("v2.3.1+build.7") {
+		t.Errorf("instances = %v, versions = %v", m.InstanceList(), m.VersionList())
+	}
+	if m.ContainsVersion("") {
+		t.Errorf("a connector without version label must never be dark")
+	}
+	if err := m.RemoveVersion("v2.3.1+build.7"); err != nil || m.ContainsVersion("v2.3.1+build.7") || len(listener.revoked) != 1 {
+		t.Errorf("RemoveVersion() err = %v, versions = %v, revoked = %v", err, m.VersionList(), listener.revoked)
+	}
+}
+
+func TestContainsIpRange(t *testing.T) {
+	m := NewDarkLaunchManager().SetIpListener(&noopListener{})
+	for _, ip := range []string{"10.0.0.0/8", "2001:db8::/32", "::ffff:192.168.0.1"} {
+		if err := m.AddIp(ip); err != nil {
+			t.Fatalf("AddIp(%s) error = %v", ip, err)
+		}
+	}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "10.9.8.7", want: true},
+		{ip: "10.9.8.7:43210", want: true},
+		{ip: "[2001:db8:1::5]:443", want: true},
+		{ip: "192.168.0.1", want: true},
+		{ip: "10.0.0.0/8", want: true},
+		{ip: "11.0.0.1", want: false},
+		{ip: "2001:db9::1", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := m.ContainsIp(tt.ip); got != tt.want {
+				t.Errorf("ContainsIp(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+	if err := m.RemoveIp("::ffff:192.168.0.1"); err != nil || m.ContainsIp("192.168.0.1") {
+		t.Errorf("RemoveIp() error = %v, ips = %v", err, m.IpList())
+	}
+}
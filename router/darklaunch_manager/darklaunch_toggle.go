@@ -1,6 +1,7 @@
 package darklaunch_manager
 
 import (
+	"sync"
 	"sync/atomic"
 
 	"github.com/torchcc/crank4go/util"
@@ -8,14 +9,40 @@ import (
 
 var toggle int32
 
+// GrayToggleListener is notified when gray testing is actually turned on or off, not when it is turned to its current state
+type GrayToggleListener interface {
+	AfterGrayTestingToggled(isOn bool, req string)
+}
+
+var grayToggleListeners = &sync.Map{} // in format of map[GrayToggleListener]struct{}
+
+func AddGrayToggleListener(listener GrayToggleListener) {
+	grayToggleListeners.Store(listener, struct{}{})
+}
+
+func RemoveGrayToggleListener(listener GrayToggleListener) {
+	grayToggleListeners.Delete(listener)
+}
+
 func TurnGrayTestingOn(req string) {
 	util.LOG.Infof("turn gray testing on as user request %s", req)
-	atomic.StoreInt32(&toggle, 1)
+	if atomic.CompareAndSwapInt32(&toggle, 0, 1) {
+		notifyGrayToggled(true, req)
+	}
 }
 
 func TurnGrayTestingOff(req string) {
 	util.LOG.Infof("turn gray testing off as user request %s", req)
-	atomic.StoreInt32(&toggle, 0)
+	if atomic.CompareAndSwapInt32(&toggle, 1, 0) {
+		notifyGrayToggled(false, req)
+	}
+}
+
+func notifyGrayToggled(isOn bool, req string) {
+	grayToggleListeners.Range(func(listener, _ interface{}) bool {
+		listener.(GrayToggleListener).AfterGrayTestingToggled(isOn, req)
+		return true
+	})
 }
 
 func IsGrayTestingOn() bool {
@@ -0,0 +1,221 @@
+package darklaunch_manager
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/torchcc/crank4go/util"
+)
+
+const errorWindowBuckets = 10
+
+type RollbackAction int
+
+const (
+	// RollbackTurnGrayTestingOff stops sending traffic to dark connectors but keeps them registered as dark
+	RollbackTurnGrayTestingOff RollbackAction = iota
+	// RollbackRevokeService revokes the failing route from the dark service list, falls back to turning gray testing off
+	// if the route is dark because of its ip
+	RollbackRevokeService
+)
+
+func (a RollbackAction) String() string {
+	switch a {
+	case RollbackTurnGrayTestingOff:
+		return "turnGrayTestingOff"
+	case RollbackRevokeService:
+		return "revokeService"
+	default:
+		return fmt.Sprintf("RollbackAction(%d)", int(a))
+	}
+}
+
+// RollbackEvent describes an automatic rollback of a dark launch
+type RollbackEvent struct {
+	Route           string
+	Action          RollbackAction
+	DarkErrorRate   float64
+	NormalErrorRate float64
+	DarkRequests    int
+	Time            time.Time
+}
+
+type RollbackListener interface {
+	// AfterDarkLaunchRolledBack trigger something after the dark launch of a route is rolled back automatically
+	AfterDarkLaunchRolledBack(event *RollbackEvent)
+}
+
+// ErrorRateMonitor tracks 5xx and transport failure rates of the dark and the normal queues of each route,
+// and rolls the dark launch back once the dark error rate crosses the threshold within the window
+type ErrorRateMonitor struct {
+	manager     *DarkLaunchManager
+	threshold   float64
+	window      time.Duration
+	minRequests int
+	action      RollbackAction
+	listeners   []RollbackListener
+	lock        sync.Mutex
+	stats       map[string]*routeErrorStats
+}
+
+type routeErrorStats struct {
+	dark   *errorWindow
+	normal *errorWindow
+}
+
+// errorWindow a sliding window made of errorWindowBuckets buckets
+type errorWindow struct {
+	bucketSize time.Duration
+	buckets    [errorWindowBuckets]errorBucket
+}
+
+type errorBucket struct {
+	epoch             int64
+	requests          int
+	serverErrors      int
+	transportFailures int
+}
+
+// NewErrorRateMonitor
+// @param threshold the error rate, between 0 and 1, which triggers the rollback
+// @param window the time span the error rate is calculated over
+// @param minRequests the minimum number of dark requests within the window before the error rate is trusted
+// @param action what to do when the threshold is crossed
+func NewErrorRateMonitor(threshold float64, window time.Duration, minRequests int, action RollbackAction) *ErrorRateMonitor {
+	if window < errorWindowBuckets*time.Millisecond {
+		window = time.Minute
+	}
+	if minRequests <= 0 {
+		minRequests = 1
+	}
+	return &ErrorRateMonitor{
+		threshold:   threshold,
+		window:      window,
+		minRequests: minRequests,
+		action:      action,
+		listeners:   make([]RollbackListener, 0, 8),
+		stats:       make(map[string]*routeErrorStats),
+	}
+}
+
+func (m *ErrorRateMonitor) AddRollbackListener(listeners ...RollbackListener) *ErrorRateMonitor {
+	m.listeners = append(m.listeners, listeners...)
+	return m
+}
+
+// Record is called once a proxied request is finished
+// @param isDark true if the request was served by a socket from the dark queues
+// @param transportFailure true if the request failed because the websocket to the connector broke
+func (m *ErrorRateMonitor) Record(route string, isDark bool, status int, transportFailure bool) {
+	if route == "" {
+		route = "*"
+	}
+	now := time.Now()
+	m.lock.Lock()
+	stats, ok := m.stats[route]
+	if !ok {
+		stats = &routeErrorStats{dark: newErrorWindow(m.window), normal: newErrorWindow(m.window)}
+		m.stats[route] = stats
+	}
+	w := stats.normal
+	if isDark {
+		w = stats.dark
+	}
+	w.add(now, status >= 500, transportFailure)
+
+	var event *RollbackEvent
+	if isDark && m.manager != nil && IsGrayTestingOn() {
+		requests, failures := stats.dark.sum(now)
+		if requests >= m.minRequests && float64(failures)/float64(requests) >= m.threshold {
+			normalRequests, normalFailures := stats.normal.sum(now)
+			event = &RollbackEvent{
+				Route:           route,
+				Action:          m.action,
+				DarkErrorRate:   float64(failures) / float64(requests),
+				NormalErrorRate: rate(normalRequests, normalFailures),
+				DarkRequests:    requests,
+				Time:            now,
+			}
+			stats.dark = newErrorWindow(m.window)
+		}
+	}
+	m.lock.Unlock()
+
+	if event != nil {
+		m.rollback(event)
+	}
+}
+
+func (m *ErrorRateMonitor) rollback(event *RollbackEvent) {
+	util.LOG.Warningf("dark launch of route %s is rolled back, action: %s, darkErrorRate: %.3f, normalErrorRate: %.3f, darkRequests: %d, threshold: %.3f",
+		event.Route, event.Action, event.DarkErrorRate, event.NormalErrorRate, event.DarkRequests, m.threshold)
+	reason := fmt.Sprintf("rollback as dark error rate %.3f of route %s crossed threshold %.3f", event.DarkErrorRate, event.Route, m.threshold)
+	// revoking the service leaves gray testing on for the other routes, the service listener decides what else to do
+	if event.Action == RollbackRevokeService && m.manager.ContainsService(event.Route) {
+		if err := m.manager.RemoveService(event.Route); err != nil {
+			util.LOG.Warningf("failed to revoke dark service %s, err: %s", event.Route, err.Error())
+		}
+		util.LOG.Warningf("dark service %s is revoked, %s", event.Route, reason)
+	} else {
+		event.Action = RollbackTurnGrayTestingOff
+		TurnGrayTestingOff(reason)
+	}
+	for _, l := range m.listeners {
+		l.AfterDarkLaunchRolledBack(event)
+	}
+}
+
+// ErrorRates returns the error rates of the dark and the normal queues of each route within the current window
+func (m *ErrorRateMonitor) ErrorRates() map[string]interface{} {
+	now := time.Now()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	rates := make(map[string]interface{})
+	for route, stats := range m.stats {
+		darkRequests, darkFailures := stats.dark.sum(now)
+		normalRequests, normalFailures := stats.normal.sum(now)
+		rates[route] = map[string]interface{}{
+			"dark":   map[string]interface{}{"requests": darkRequests, "errorRate": rate(darkRequests, darkFailures)},
+			"normal": map[string]interface{}{"requests": normalRequests, "errorRate": rate(normalRequests, normalFailures)},
+		}
+	}
+	return rates
+}
+
+func rate(requests, failures int) float64 {
+	if requests == 0 {
+		return 0
+	}
+	return float64(failures) / float64(requests)
+}
+
+func newErrorWindow(window time.Duration) *errorWindow {
+	return &errorWindow{bucketSize: window / errorWindowBuckets}
+}
+
+func (w *errorWindow) add(now time.Time, isServerErr, transportFailure bool) {
+	epoch := now.UnixNano() / int64(w.bucketSize)
+	b := &w.buckets[epoch%errorWindowBuckets]
+	if b.epoch != epoch {
+		*b = errorBucket{epoch: epoch}
+	}
+	b.requests++
+	if transportFailure {
+		b.transportFailures++
+	} else if isServerErr {
+		b.serverErrors++
+	}
+}
+
+// sum returns the number of requests and failed ones, failures include 5xx responses and transport failures
+func (w *errorWindow) sum(now time.Time) (requests, failures int) {
+	epoch := now.UnixNano() / int64(w.bucketSize)
+	for _, b := range w.buckets {
+		if epoch-b.epoch < errorWindowBuckets {
+			requests += b.requests
+			failures += b.serverErrors + b.transportFailures
+		}
+	}
+	return
+}
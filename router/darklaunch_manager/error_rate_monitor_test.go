@@ -0,0 +1,65 @@
+package darklaunch_manager
+
+import (
+	"testing"
+	"time"
+)
+
+type noopListener struct{}
+
+func (l *noopListener) AfterDarkIpAdded(string)        {}
+func (l *noopListener) AfterDarkIpRevoked(string)      {}
+func (l *noopListener) AfterDarkServiceAdded(string)   {}
+func (l *noopListener) AfterDarkServiceRevoked(string) {}
+
+type recordingRollbackListener struct {
+	events []*RollbackEvent
+}
+
+func (l *recordingRollbackListener) AfterDarkLaunchRolledBack(event *RollbackEvent) {
+	l.events = append(l.events, event)
+}
+
+func TestErrorRateMonitorRollback(t *testing.T) {
+	tests := []struct {
+		name         string
+		action       RollbackAction
+		statuses     []int
+		wantRollback bool
+		wantAction   RollbackAction
+		wantService  bool
+		wantGrayOn   bool
+	}{
+		{name: "belowMinRequests", action: RollbackTurnGrayTestingOff, statuses: []int{500, 502}, wantRollback: false, wantService: true, wantGrayOn: true},
+		{name: "belowThreshold", action: RollbackTurnGrayTestingOff, statuses: []int{200, 200, 200, 500}, wantRollback: false, wantService: true, wantGrayOn: true},
+		{name: "turnGrayOff", action: RollbackTurnGrayTestingOff, statuses: []int{200, 500, 503, 502}, wantRollback: true, wantAction: RollbackTurnGrayTestingOff, wantService: true},
+		{name: "revokeService", action: RollbackRevokeService, statuses: []int{500, 500, 500, 200}, wantRollback: true, wantAction: RollbackRevokeService, wantService: false, wantGrayOn: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener := &recordingRollbackListener{}
+			manager := NewDarkLaunchManager().SetIpListener(&noopListener{}).SetServiceListener(&noopListener{})
+			manager.SetErrorRateMonitor(NewErrorRateMonitor(0.5, time.Minute, 4, tt.action).AddRollbackListener(listener))
+			_ = manager.AddService("a")
+			TurnGrayTestingOn("test")
+			defer TurnGrayTestingOff("test")
+
+			for _, status := range tt.statuses {
+				manager.ErrorRateMonitor().Record("a", false, 500, false)
+				manager.ErrorRateMonitor().Record("a", true, status, false)
+			}
+			if got := len(listener.events) == 1; got != tt.wantRollback {
+				t.Fatalf("rollback = %v, want %v", got, tt.wantRollback)
+			}
+			if IsGrayTestingOn() != tt.wantGrayOn {
+				t.Errorf("IsGrayTestingOn() = %v, want %v", IsGrayTestingOn(), tt.wantGrayOn)
+			}
+			if tt.wantRollback && listener.events[0].Action != tt.wantAction {
+				t.Errorf("action = %s, want %s", listener.events[0].Action, tt.wantAction)
+			}
+			if got := manager.ContainsService("a"); got != tt.wantService {
+				t.Errorf("ContainsService() = %v, want %v", got, tt.wantService)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package router
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+
+	ptc "github.com/torchcc/crank4go/protocol"
+	"github.com/torchcc/crank4go/util"
+)
+
+// the forwarding headers a client sends are only passed on if it is a trusted proxy, the router writes them itself
+var forwardingHeaders = map[string]struct{}{
+	"Forwarded": {}, "X-Forwarded-For": {}, "X-Forwarded-Proto": {}, "X-Forwarded-Host": {}, "X-Forwarded-Server": {}, "X-Real-Ip": {},
+}
+
+// addProxyForwardingHeaders appends the router to the Forwarded and X-Forwarded-For chains of a trusted proxy, or starts
+// them over for any other client. X-Real-IP is the client ip worked out from the chain
+func addProxyForwardingHeaders(headers *ptc.HeadersBuilder, req *http.Request, trustedProxies util.IpRanges) {
+	peer := util.HostOf(req.RemoteAddr)
+	trusted := trustedProxies.Contains(req.RemoteAddr)
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	host := req.Host
+	by := ip
+
+	element := "for=" + forwardedNode(peer) + ";proto=" + forwardedValue(proto) + ";host=" + forwardedValue(host) + ";by=" + forwardedNode(by)
+	xfor := peer
+	if trusted {
+		if chain := joinHeaderValues(req.Header.Values("Forwarded")); chain != "" {
+			element = chain + ", " + element
+		}
+		if chain := joinHeaderValues(req.Header.Values("X-Forwarded-For")); chain != "" {
+			xfor = chain + ", " + xfor
+		}
+	}
+	headers.AppendHeader("Forwarded", element)
+	headers.AppendHeader("X-Forwarded-For", xfor)
+	headers.AppendHeader("X-Forwarded-Proto", forwardedOr(req, trusted, "X-Forwarded-Proto", proto))
+	headers.AppendHeader("X-Forwarded-Host", forwardedOr(req, trusted, "X-Forwarded-Host", host))
+	headers.AppendHeader("X-Forwarded-Server", forwardedOr(req, trusted, "X-Forwarded-Server", by))
+	headers.AppendHeader("X-Real-IP", util.ClientIp(req, trustedProxies))
+}
+
+// forwardedOr the header sent by a trusted proxy, otherwise the value seen by the router
+func forwardedOr(req *http.Request, trusted bool, header, value string) string {
+	if trusted {
+		if sent := req.Header.Get(header); sent != "" {
+			return sent
+		}
+	}
+	return value
+}
+
+// joinHeaderValues the comma separated elements of all the values of a header, blank elements are dropped
+func joinHeaderValues(values []string) string {
+	elements := make([]string, 0, len(values))
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			if element = strings.TrimSpace(element); element != "" {
+				elements = append(elements, element)
+			}
+		}
+	}
+	return strings.Join(elements, ", ")
+}
+
+// forwardedNode an ip as a node of the Forwarded header, IPv6 addresses are bracketed and quoted, see RFC 7239 section 6
+func forwardedNode(host string) string {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		if host == "" {
+			return "unknown"
+		}
+		return forwardedValue(host)
+	}
+	if addr = addr.Unmap(); addr.Is6() {
+		return `"[` + addr.String() + `]"`
+	}
+	return addr.String()
+}
+
+// forwardedValue a token as it is, anything else as a quoted-string, see RFC 7239 section 4
+func forwardedValue(value string) string {
+	if value != "" && strings.IndexFunc(value, func(r rune) bool { return !isTokenChar(r) }) < 0 {
+		return value
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+func isTokenChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+}
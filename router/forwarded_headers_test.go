@@ -0,0 +1,94 @@
+package router
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ptc "github.com/torchcc/crank4go/protocol"
+	"github.com/torchcc/crank4go/util"
+)
+
+func TestAddProxyForwardingHeaders(t *testing.T) {
+	trustedProxies, _ := util.ParseIpRanges([]string{"10.0.0.0/8", "fd00::/8"})
+	by := forwardedNode(ip)
+	tests := []struct {
+		name       string
+		remoteAddr string
+		tls        bool
+		host       string
+		sent       map[string]string
+		want       map[string]string
+	}{
+		{
+			name: "direct client", remoteAddr: "203.0.113.7:51000", host: "example.com",
+			sent: map[string]string{"X-Forwarded-For": "1.2.3.4", "Forwarded": "for=1.2.3.4", "X-Forwarded-Proto": "https"},
+			want: map[string]string{
+				"Forwarded":         "for=203.0.113.7;proto=http;host=example.com;by=" + by,
+				"X-Forwarded-For":   "203.0.113.7",
+				"X-Forwarded-Proto": "http",
+				"X-Real-IP":         "203.0.113.7",
+			},
+		},
+		{
+			name: "ipv6 client over tls", remoteAddr: "[2001:db8::1]:51000", tls: true, host: "example.com:8443",
+			want: map[string]string{
+				"Forwarded":         `for="[2001:db8::1]";proto=https;host="example.com:8443";by=` + by,
+				"X-Forwarded-For":   "2001:db8::1",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "example.com:8443",
+			},
+		},
+		{
+			name: "trusted proxy", remoteAddr: "10.0.0.2:40000", host: "example.com",
+			sent: map[string]string{"X-Forwarded-For": "198.51.100.9, 10.0.0.1", "Forwarded": `for=198.51.100.9, for="[fd00::1]"`, "X-Forwarded-Proto": "https"},
+			want: map[string]string{
+				"Forwarded":         `for=198.51.100.9, for="[fd00::1]", for=10.0.0.2;proto=http;host=example.com;by=` + by,
+				"X-Forwarded-For":   "198.51.100.9, 10.0.0.1, 10.0.0.2",
+				"X-Forwarded-Proto": "https",
+				"X-Real-IP":         "198.51.100.9",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/svc/hello", nil)
+			req.RemoteAddr, req.Host = tt.remoteAddr, tt.host
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			for header, value := range tt.sent {
+				req.Header.Set(header, value)
+			}
+			headers := new(ptc.HeadersBuilder)
+			addProxyForwardingHeaders(headers, req, trustedProxies)
+			got := make(map[string]string)
+			for _, line := range strings.Split(strings.TrimSpace(headers.String()), "\n") {
+				if pos := strings.Index(line, ":"); pos > 0 {
+					got[line[:pos]] = line[pos+1:]
+				}
+			}
+			for header, value := range tt.want {
+				if got[header] != value {
+					t.Errorf("%s = %q, want %q", header, got[header], value)
+				}
+			}
+		})
+	}
+}
+
+func TestForwardedValue(t *testing.T) {
+	tests := []struct{ value, want string }{
+		{"http", "http"},
+		{"example.com:8443", `"example.com:8443"`},
+		{`a"b\c`, `"a\"b\\c"`},
+		{"", `""`},
+	}
+	for _, tt := range tests {
+		if got := forwardedValue(tt.value); got != tt.want {
+			t.Errorf("forwardedValue(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
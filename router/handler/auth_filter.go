@@ -0,0 +1,305 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/torchcc/crank4go/credential"
+	"github.com/torchcc/crank4go/util"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role what an authenticated caller of the admin API may do, a higher role includes the lower ones
+type Role int
+
+const (
+	RoleNone Role = iota
+	// RoleReader may call GET, HEAD and OPTIONS endpoints
+	RoleReader
+	// RoleOperator may also call the mutating endpoints
+	RoleOperator
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleReader:
+		return "reader"
+	case RoleOperator:
+		return "operator"
+	default:
+		return "none"
+	}
+}
+
+func ParseRole(s string) Role {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "reader":
+		return RoleReader
+	case "operator":
+		return RoleOperator
+	default:
+		return RoleNone
+	}
+}
+
+// Principal the authenticated caller of the admin API
+type Principal struct {
+	Name   string
+	Role   Role
+	Method string // how the caller was authenticated: bearer, basic or mtls
+}
+
+func (p *Principal) String() string {
+	return fmt.Sprintf("%s(%s by %s)", p.Name, p.Role, p.Method)
+}
+
+// Authenticator authenticates admin API callers by one kind of credential
+type Authenticator interface {
+	// Authenticate returns nil, nil if the request carries no credential of its kind, so the next authenticator is tried
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+type principalKey struct{}
+
+// principalHolder is filled in by the AuthFilter, the request handed to the filters can not be replaced by them
+type principalHolder struct {
+	principal *Principal
+}
+
+// WithPrincipalHolder returns a copy of the request carrying an empty holder of the caller. the AuthFilter records
+// the caller it authenticates in it, so both the handlers after the filters and those wrapping them can read it
+func WithPrincipalHolder(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, &principalHolder{}))
+}
+
+// PrincipalFrom returns the caller authenticated by the AuthFilter, nil if the request was not authenticated
+// or carries no holder, see WithPrincipalHolder
+func PrincipalFrom(r *http.Request) *Principal {
+	if holder, ok := r.Context().Value(principalKey{}).(*principalHolder); ok {
+		return holder.principal
+	}
+	return nil
+}
+
+// AuthFilter authenticates admin API callers with the first authenticator which finds a credential, reading endpoints
+// require RoleReader and all the others RoleOperator. the principal is recorded in the request context, see PrincipalFrom
+type AuthFilter struct {
+	authenticators []Authenticator
+}
+
+func NewAuthFilter(authenticators ...Authenticator) *AuthFilter {
+	return &AuthFilter{authenticators: authenticators}
+}
+
+func (f *AuthFilter) Handle(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
+	required := RoleOperator
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		required = RoleReader
+	}
+	var principal *Principal
+	for _, authenticator := range f.authenticators {
+		p, err := authenticator.Authenticate(r)
+		if err != nil {
+			util.LOG.Warningf("admin API authentication failed, API {%s} called by {%s} through {%s}, err: %s", r.URL.String(), r.RemoteAddr, r.Method, err.Error())
+			f.reject(w, http.StatusUnauthorized, "authentication failed")
+			return true
+		}
+		if p != nil {
+			principal = p
+			break
+		}
+	}
+	if principal == nil {
+		f.reject(w, http.StatusUnauthorized, "authentication required")
+		return true
+	}
+	// recorded before the role is checked, so a denied call is audited with its caller
+	if holder, ok := r.Context().Value(principalKey{}).(*principalHolder); ok {
+		holder.principal = principal
+	}
+	if principal.Role < required {
+		util.LOG.Warningf("admin API access denied, API {%s} called by %s through {%s} requires role %s", r.URL.String(), principal, r.Method, required)
+		f.reject(w, http.StatusForbidden, fmt.Sprintf("role %s is required", required))
+		return true
+	}
+	return false
+}
+
+func (f *AuthFilter) reject(w http.ResponseWriter, status int, msg string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Add("WWW-Authenticate", `Basic realm="crank4go"`)
+		w.Header().Add("WWW-Authenticate", `Bearer realm="crank4go"`)
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(msg))
+}
+
+// BearerAuthenticator authenticates signed tokens, the highest role of the token's roles claim is granted
+type BearerAuthenticator struct {
+	verifier credential.Verifier
+}
+
+func NewBearerAuthenticator(verifier credential.Verifier) *BearerAuthenticator {
+	return &BearerAuthenticator{verifier: verifier}
+}
+
+func (a *BearerAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token := credential.BearerToken(r)
+	if token == "" {
+		return nil, nil
+	}
+	identity, err := a.verifier.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	principal := &Principal{Name: identity.Subject, Method: "bearer"}
+	for _, role := range identity.Roles {
+		if parsed := ParseRole(role); parsed > principal.Role {
+			principal.Role = parsed
+		}
+	}
+	return principal, nil
+}
+
+// BasicAuthFile authenticates basic auth against a local credentials file with one "username:role:bcrypt-hash-of-password"
+// per line, blank lines and lines starting with # are skipped. the hashes can be made with HashPassword or
+// htpasswd -nbB. the file is read again once it is modified
+type BasicAuthFile struct {
+	path    string
+	lock    sync.Mutex
+	modTime time.Time
+	users   map[string]basicAuthUser
+}
+
+type basicAuthUser struct {
+	role         Role
+	passwordHash []byte
+}
+
+// unknownUserHash is compared with the passwords of unknown users, so they take as long to reject as wrong passwords
+var (
+	unknownUserHash     []byte
+	unknownUserHashOnce sync.Once
+)
+
+// HashPassword the bcrypt hash of a password for the credentials file of BasicAuthFile
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func NewBasicAuthFile(path string) (*BasicAuthFile, error) {
+	a := &BasicAuthFile{path: path}
+	if err := a.reloadIfModified(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *BasicAuthFile) Authenticate(r *http.Request) (*Principal, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	if err := a.reloadIfModified(); err != nil {
+		util.LOG.Warningf("failed to reload basic auth file %s, the current credentials are kept, err: %s", a.path, err.Error())
+	}
+	a.lock.Lock()
+	user, found := a.users[username]
+	a.lock.Unlock()
+	if !found {
+		unknownUserHashOnce.Do(func() {
+			unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+		})
+		_ = bcrypt.CompareHashAndPassword(unknownUserHash, []byte(password))
+		return nil, errors.New("invalid username or password for " + username)
+	}
+	if err := bcrypt.CompareHashAndPassword(user.passwordHash, []byte(password)); err != nil {
+		return nil, errors.New("invalid username or password for " + username)
+	}
+	return &Principal{Name: username, Role: user.role, Method: "basic"}, nil
+}
+
+func (a *BasicAuthFile) reloadIfModified() error {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return err
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.users != nil && info.ModTime().Equal(a.modTime) {
+		return nil
+	}
+	content, err := os.ReadFile(a.path)
+	if err != nil {
+		return err
+	}
+	users := make(map[string]basicAuthUser)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 || ParseRole(parts[1]) == RoleNone {
+			return fmt.Errorf("invalid line %d of %s, want username:role:bcrypt-hash-of-password", lineNo, a.path)
+		}
+		passwordHash := []byte(parts[2])
+		if _, err := bcrypt.Cost(passwordHash); err != nil {
+			return fmt.Errorf("invalid bcrypt password hash at line %d of %s, err: %s", lineNo, a.path, err.Error())
+		}
+		users[parts[0]] = basicAuthUser{role: ParseRole(parts[1]), passwordHash: passwordHash}
+	}
+	a.users, a.modTime = users, info.ModTime()
+	return nil
+}
+
+// CertRoleAuthenticator grants roles to verified client certificates by their common name or SANs.
+// client certificates must be verified by the registration server, see RouterConfig.SetConnectorCertPolicy
+type CertRoleAuthenticator struct {
+	lock  sync.RWMutex
+	roles map[string]Role
+}
+
+func NewCertRoleAuthenticator() *CertRoleAuthenticator {
+	return &CertRoleAuthenticator{roles: make(map[string]Role)}
+}
+
+// Grant grants the role to the certificate identity, i.e. its common name or any DNS, URI or email SAN
+func (a *CertRoleAuthenticator) Grant(identity string, role Role) *CertRoleAuthenticator {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.roles[identity] = role
+	return a
+}
+
+func (a *CertRoleAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	principal := &Principal{Name: cert.Subject.CommonName, Method: "mtls"}
+	for _, name := range credential.CertNames(cert) {
+		if role := a.roles[name]; role > principal.Role {
+			principal.Role = role
+		}
+	}
+	if principal.Role == RoleNone {
+		// a certificate without role may belong to a connector, let other authenticators try
+		return nil, nil
+	}
+	return principal, nil
+}
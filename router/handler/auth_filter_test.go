@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/torchcc/crank4go/credential"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuthFilter(t *testing.T) {
+	secret := []byte("admin-secret")
+	path := filepath.Join(t.TempDir(), "users")
+	content := "# username:role:bcrypt-hash-of-password\n" +
+		"alice:operator:" + bcryptHash(t, "alice-pw") + "\n" +
+		"bob:reader:" + bcryptHash(t, "bob-pw") + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	basicAuth, err := NewBasicAuthFile(path)
+	if err != nil {
+		t.Fatalf("NewBasicAuthFile() error = %v", err)
+	}
+	filter := NewAuthFilter(NewBearerAuthenticator(credential.NewHmacVerifier(secret)), basicAuth)
+	readerToken, _ := credential.Sign(&credential.Claims{Subject: "dashboard", Roles: []string{"reader"}, ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		credential.AlgHS256, "", hmacSigner(secret))
+
+	tests := []struct {
+		name       string
+		method     string
+		auth       func(r *http.Request)
+		wantStatus int
+		wantName   string
+	}{
+		{name: "anonymous", method: http.MethodGet, auth: func(r *http.Request) {}, wantStatus: http.StatusUnauthorized},
+		{name: "unknownUser", method: http.MethodGet, auth: func(r *http.Request) { r.SetBasicAuth("carol", "bob-pw") }, wantStatus: http.StatusUnauthorized},
+		{name: "wrongPassword", method: http.MethodGet, auth: func(r *http.Request) { r.SetBasicAuth("bob", "nope") }, wantStatus: http.StatusUnauthorized},
+		{name: "readerReads", method: http.MethodGet, auth: func(r *http.Request) { r.SetBasicAuth("bob", "bob-pw") }, wantName: "bob"},
+		{name: "readerWrites", method: http.MethodPut, auth: func(r *http.Request) { r.SetBasicAuth("bob", "bob-pw") }, wantStatus: http.StatusForbidden},
+		{name: "operatorWrites", method: http.MethodDelete, auth: func(r *http.Request) { r.SetBasicAuth("alice", "alice-pw") }, wantName: "alice"},
+		{name: "bearerReader", method: http.MethodGet, auth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+readerToken) }, wantName: "dashboard"},
+		{name: "bearerReaderWrites", method: http.MethodPut, auth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+readerToken) }, wantStatus: http.StatusForbidden},
+		{name: "badBearer", method: http.MethodGet, auth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer x.y.z") }, wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := WithPrincipalHolder(httptest.NewRequest(tt.method, "/api/dark-launch/ip/10.0.0.1", nil))
+			tt.auth(r)
+			stopped := filter.Handle(w, r, nil)
+			if tt.wantStatus != 0 {
+				if !stopped || w.Code != tt.wantStatus {
+					t.Errorf("Handle() stopped = %v, status = %d, want %d", stopped, w.Code, tt.wantStatus)
+				}
+				return
+			}
+			if stopped {
+				t.Fatalf("Handle() stopped with status %d", w.Code)
+			}
+			if principal := PrincipalFrom(r); principal == nil || principal.Name != tt.wantName {
+				t.Errorf("PrincipalFrom() = %v, want %s", principal, tt.wantName)
+			}
+		})
+	}
+}
+
+func bcryptHash(t *testing.T, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func TestBasicAuthFileFormat(t *testing.T) {
+	hash, err := HashPassword("pw")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "bcrypt", content: "alice:operator:" + hash + "\n"},
+		{name: "htpasswd", content: "alice:operator:$2y$05$ZTmBrc6DTB9Iq1jBvKZWNOo3rsfSeCB8pCrwUgU6Z5i7i8TaOUsBm\n"},
+		{name: "sha256", content: "alice:operator:" + strings.Repeat("ab", 32) + "\n", wantErr: true},
+		{name: "no role", content: "alice:" + hash + "\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "users")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := NewBasicAuthFile(path); (err != nil) != tt.wantErr {
+				t.Errorf("NewBasicAuthFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func hmacSigner(secret []byte) func([]byte) ([]byte, error) {
+	return func(signingInput []byte) ([]byte, error) {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signingInput)
+		return mac.Sum(nil), nil
+	}
+}
@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/torchcc/crank4go/util"
+)
+
+// buckets not touched for this long are refilled anyway, they are dropped to keep the memory bounded
+const rateLimitSweepInterval = time.Minute
+
+// RateLimit a token bucket refilled with Rate tokens per second holding at most Burst tokens
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l *RateLimit) valid() bool {
+	return l != nil && l.Rate > 0 && l.Burst > 0
+}
+
+// RateLimitFilter a request handler limiting the requests on the router's httpServer per route, per client ip and per
+// api key. a request takes a token from each of the buckets it falls into, it is rejected with 429 when one of them is empty
+type RateLimitFilter struct {
+	sync.Mutex
+	routeLimit      *RateLimit
+	routeLimits     map[string]*RateLimit
+	ipLimit         *RateLimit
+	trustedProxies  util.IpRanges
+	keyLimit        *RateLimit
+	keyHeader       string
+	publishHandlers []util.DataPublishHandler
+	buckets         map[string]*tokenBucket
+	rejected        map[string]int
+	lastSweep       time.Time
+	now             func() time.Time
+}
+
+func NewRateLimitFilter() *RateLimitFilter {
+	return &RateLimitFilter{
+		routeLimits: make(map[string]*RateLimit),
+		buckets:     make(map[string]*tokenBucket),
+		rejected:    make(map[string]int),
+		now:         time.Now,
+	}
+}
+
+// SetRouteLimit limits the requests of every route, the route is the first segment of the request path
+func (f *RateLimitFilter) SetRouteLimit(rate float64, burst int) *RateLimitFilter {
+	f.routeLimit = &RateLimit{Rate: rate, Burst: burst}
+	return f
+}
+
+// SetRouteLimitOf overrides the route limit for one route, use "*" for requests without a route
+func (f *RateLimitFilter) SetRouteLimitOf(route string, rate float64, burst int) *RateLimitFilter {
+	f.routeLimits[route] = &RateLimit{Rate: rate, Burst: burst}
+	return f
+}
+
+// SetIpLimit limits the requests of every client ip. X-Forwarded-For is only honoured for requests from trustedProxies,
+// the router's trusted proxies when they are nil
+func (f *RateLimitFilter) SetIpLimit(rate float64, burst int, trustedProxies util.IpRanges) *RateLimitFilter {
+	f.ipLimit = &RateLimit{Rate: rate, Burst: burst}
+	f.trustedProxies = trustedProxies
+	return f
+}
+
+// SetTrustedProxies the proxies whose X-Forwarded-For is honoured to find the client ip, the router's trusted proxies when none is set
+func (f *RateLimitFilter) SetTrustedProxies(trustedProxies util.IpRanges) *RateLimitFilter {
+	f.trustedProxies = trustedProxies
+	return f
+}
+
+func (f *RateLimitFilter) TrustedProxies() util.IpRanges {
+	return f.trustedProxies
+}
+
+// SetKeyLimit limits the requests of every value of the header, e.g. X-Api-Key. requests without the header are not limited by key
+func (f *RateLimitFilter) SetKeyLimit(header string, rate float64, burst int) *RateLimitFilter {
+	f.keyHeader = header
+	f.keyLimit = &RateLimit{Rate: rate, Burst: burst}
+	return f
+}
+
+// SetDataPublishHandlers the total of rejected requests is published as ratelimit.rejected,by=route|ip|key
+func (f *RateLimitFilter) SetDataPublishHandlers(handlers ...util.DataPublishHandler) *RateLimitFilter {
+	f.publishHandlers = handlers
+	return f
+}
+
+func (f *RateLimitFilter) DataPublishHandlers() []util.DataPublishHandler {
+	return f.publishHandlers
+}
+
+// limitedBucket a bucket a request falls into
+type limitedBucket struct {
+	by    string
+	key   string
+	limit *RateLimit
+}
+
+func (f *RateLimitFilter) bucketsOf(r *http.Request) []limitedBucket {
+	buckets := make([]limitedBucket, 0, 3)
+	route := routeOf(r.URL.Path)
+	if limit, ok := f.routeLimits[route]; ok {
+		if limit.valid() {
+			buckets = append(buckets, limitedBucket{"route", route, limit})
+		}
+	} else if f.routeLimit.valid() {
+		buckets = append(buckets, limitedBucket{"route", route, f.routeLimit})
+	}
+	if f.ipLimit.valid() {
+		buckets = append(buckets, limitedBucket{"ip", util.ClientIp(r, f.trustedProxies), f.ipLimit})
+	}
+	if f.keyLimit.valid() {
+		if key := r.Header.Get(f.keyHeader); key != "" {
+			buckets = append(buckets, limitedBucket{"key", key, f.keyLimit})
+		}
+	}
+	return buckets
+}
+
+func (f *RateLimitFilter) Handle(w http.ResponseWriter, r *http.Request, _ httprouter.Params) bool {
+	buckets := f.bucketsOf(r)
+	if len(buckets) == 0 {
+		return false
+	}
+	f.Lock()
+	now := f.now()
+	f.sweep(now)
+	tokens := make([]*tokenBucket, len(buckets))
+	rejectedBy := -1
+	for i, b := range buckets {
+		tokens[i] = f.bucket(b, now)
+		if tokens[i].tokens < 1 && (rejectedBy < 0 || tokens[i].waitFor(1) > tokens[rejectedBy].waitFor(1)) {
+			rejectedBy = i
+		}
+	}
+	// the bucket with the fewest tokens left is the one advertised in the RateLimit headers
+	tightest := 0
+	for i, t := range tokens {
+		if rejectedBy < 0 {
+			// a request only takes tokens when all its buckets allow it
+			t.tokens--
+		}
+		if t.tokens < tokens[tightest].tokens {
+			tightest = i
+		}
+	}
+	limit, remaining, reset := buckets[tightest].limit.Burst, int(tokens[tightest].tokens), tokens[tightest].waitFor(float64(buckets[tightest].limit.Burst))
+	var retryAfter time.Duration
+	var total int
+	if rejectedBy >= 0 {
+		retryAfter = tokens[rejectedBy].waitFor(1)
+		f.rejected[buckets[rejectedBy].by]++
+		total = f.rejected[buckets[rejectedBy].by]
+	}
+	f.Unlock()
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(reset)))
+	if rejectedBy < 0 {
+		return false
+	}
+	b := buckets[rejectedBy]
+	util.LOG.Warningf("rate limit of %s %s exceeded by %s %s from client %s", b.by, b.key, r.Method, r.URL.Path, r.RemoteAddr)
+	for _, h := range f.publishHandlers {
+		h.PublishData("ratelimit.rejected,by="+b.by, total)
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+	w.WriteHeader(http.StatusTooManyRequests)
+	_, _ = w.Write([]byte("Too Many Requests"))
+	return true
+}
+
+// bucket the refilled bucket of b, it is created full
+func (f *RateLimitFilter) bucket(b limitedBucket, now time.Time) *tokenBucket {
+	id := b.by + ":" + b.key
+	t, ok := f.buckets[id]
+	if !ok {
+		t = &tokenBucket{limit: b.limit, tokens: float64(b.limit.Burst), updated: now}
+		f.buckets[id] = t
+		return t
+	}
+	t.refill(now)
+	return t
+}
+
+// sweep drops the buckets which have been refilled to the full, dropping them changes nothing
+func (f *RateLimitFilter) sweep(now time.Time) {
+	if now.Sub(f.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	f.lastSweep = now
+	for id, t := range f.buckets {
+		if t.refill(now); t.tokens >= float64(t.limit.Burst) {
+			delete(f.buckets, id)
+		}
+	}
+}
+
+type tokenBucket struct {
+	limit   *RateLimit
+	tokens  float64
+	updated time.Time
+}
+
+func (t *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(t.updated); elapsed > 0 {
+		t.tokens = math.Min(float64(t.limit.Burst), t.tokens+elapsed.Seconds()*t.limit.Rate)
+		t.updated = now
+	}
+}
+
+// waitFor how long until the bucket holds n tokens
+func (t *tokenBucket) waitFor(n float64) time.Duration {
+	if t.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - t.tokens) / t.limit.Rate * float64(time.Second))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// routeOf the first segment of the path, the same route the websocket farm dispatches the request to
+func routeOf(path string) string {
+	route := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+	if route == "" {
+		return "*"
+	}
+	return route
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/torchcc/crank4go/util"
+)
+
+func TestRateLimitFilter(t *testing.T) {
+	trusted, _ := util.ParseIpRanges([]string{"10.0.0.0/8"})
+	now := time.Unix(1700000000, 0)
+	published := make(map[string]int)
+	filter := NewRateLimitFilter().
+		SetRouteLimit(100, 100).
+		SetRouteLimitOf("slow", 1, 2).
+		SetIpLimit(1, 3, trusted).
+		SetKeyLimit("X-Api-Key", 1, 1).
+		SetDataPublishHandlers(util.DataPublishHandlerFunc(func(key string, value int) { published[key] = value }))
+	filter.now = func() time.Time { return now }
+
+	tests := []struct {
+		name           string
+		path           string
+		remoteAddr     string
+		xff            string
+		apiKey         string
+		advance        time.Duration
+		wantStatus     int
+		wantRemaining  string
+		wantRetryAfter string
+	}{
+		{"route burst 1", "/slow/a", "1.1.1.1:1000", "", "", 0, 200, "1", ""},
+		{"route burst 2", "/slow/b", "1.1.1.2:1000", "", "", 0, 200, "0", ""},
+		{"route exhausted", "/slow/c", "1.1.1.3:1000", "", "", 0, 429, "0", "1"},
+		{"route refilled", "/slow/c", "1.1.1.3:1000", "", "", time.Second, 200, "0", ""},
+		{"ip burst via trusted proxy", "/fast", "10.0.0.1:1000", "2.2.2.2", "", 0, 200, "2", ""},
+		{"same ip directly", "/fast", "2.2.2.2:1000", "", "", 0, 200, "1", ""},
+		{"spoofed xff is ignored", "/fast", "3.3.3.3:1000", "2.2.2.2", "", 0, 200, "2", ""},
+		{"same ip last token", "/fast", "2.2.2.2:2000", "", "", 0, 200, "0", ""},
+		{"ip exhausted", "/fast", "10.0.0.2:1000", "2.2.2.2", "", 0, 429, "0", "1"},
+		{"api key", "/fast", "4.4.4.4:1000", "", "k1", 0, 200, "0", ""},
+		{"api key exhausted", "/fast", "5.5.5.5:1000", "", "k1", 0, 429, "0", "1"},
+		{"other api key", "/fast", "5.5.5.5:1000", "", "k2", 0, 200, "0", ""},
+		{"rejected request took no ip token", "/fast", "5.5.5.5:1000", "", "", 0, 200, "1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.apiKey != "" {
+				r.Header.Set("X-Api-Key", tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+			stop := filter.Handle(w, r, nil)
+			if stop != (tt.wantStatus == 429) || w.Code != tt.wantStatus {
+				t.Fatalf("Handle() = %v with status %d, want status %d", stop, w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("RateLimit-Remaining"); got != tt.wantRemaining {
+				t.Errorf("RateLimit-Remaining = %s, want %s", got, tt.wantRemaining)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %s, want %s", got, tt.wantRetryAfter)
+			}
+		})
+	}
+	want := map[string]int{"ratelimit.rejected,by=route": 1, "ratelimit.rejected,by=ip": 1, "ratelimit.rejected,by=key": 1}
+	for key, value := range want {
+		if published[key] != value {
+			t.Errorf("published %s = %d, want %d", key, published[key], value)
+		}
+	}
+
+	now = now.Add(2 * rateLimitSweepInterval)
+	filter.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil), nil)
+	if len(filter.buckets) != 2 {
+		t.Errorf("buckets after sweep = %d, want only the 2 of the last request", len(filter.buckets))
+	}
+}
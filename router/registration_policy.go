@@ -0,0 +1,100 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/torchcc/crank4go/router/router_socket"
+	"github.com/torchcc/crank4go/util"
+)
+
+// RegistrationRequest what a connector asks for when it registers a socket
+type RegistrationRequest struct {
+	Route               string
+	ConnectorInstanceID string
+	ComponentName       string
+	RemoteAddr          string
+}
+
+// RegistrationPolicy decides which routes may be registered, it is evaluated for every socket before the websocket upgrade
+type RegistrationPolicy interface {
+	// Evaluate returns a *util.CrankerErr carrying the http status to reject the registration with, nil to accept it.
+	// stats must not be kept after Evaluate returns
+	Evaluate(req *RegistrationRequest, stats router_socket.RegistrationStats) error
+}
+
+// DefaultRegistrationPolicy rejects reserved routes and the catch-all route, and limits the sockets of each connector
+// instance and the instances of each route. a limit of 0 means unlimited. nothing is rejected by default
+type DefaultRegistrationPolicy struct {
+	reservedRoutes        map[string]struct{}
+	isCatchAllBlocked     bool
+	maxSocketsPerInstance int
+	maxInstancesPerRoute  int
+}
+
+func NewDefaultRegistrationPolicy() *DefaultRegistrationPolicy {
+	return &DefaultRegistrationPolicy{reservedRoutes: make(map[string]struct{})}
+}
+
+// SetReservedRoutes routes no connector may register, compared case-insensitively
+func (p *DefaultRegistrationPolicy) SetReservedRoutes(routes ...string) *DefaultRegistrationPolicy {
+	p.reservedRoutes = make(map[string]struct{})
+	for _, route := range routes {
+		p.reservedRoutes[strings.ToLower(route)] = struct{}{}
+	}
+	return p
+}
+
+// SetCatchAllBlocked rejects registrations to the catch-all route, "" or "*", which receives every request no other route matches
+func (p *DefaultRegistrationPolicy) SetCatchAllBlocked(isCatchAllBlocked bool) *DefaultRegistrationPolicy {
+	p.isCatchAllBlocked = isCatchAllBlocked
+	return p
+}
+
+// SetMaxSocketsPerInstance limits the connected sockets of a connector instance over all its routes, including busy ones
+func (p *DefaultRegistrationPolicy) SetMaxSocketsPerInstance(maxSocketsPerInstance int) *DefaultRegistrationPolicy {
+	p.maxSocketsPerInstance = maxSocketsPerInstance
+	return p
+}
+
+// SetMaxInstancesPerRoute limits the distinct connector instances serving a route
+func (p *DefaultRegistrationPolicy) SetMaxInstancesPerRoute(maxInstancesPerRoute int) *DefaultRegistrationPolicy {
+	p.maxInstancesPerRoute = maxInstancesPerRoute
+	return p
+}
+
+func (p *DefaultRegistrationPolicy) Evaluate(req *RegistrationRequest, stats router_socket.RegistrationStats) error {
+	if req.Route == "" || req.Route == "*" {
+		if p.isCatchAllBlocked {
+			return &util.CrankerErr{Msg: "registration to the catch-all route is not allowed", Code: http.StatusForbidden}
+		}
+	} else if _, ok := p.reservedRoutes[strings.ToLower(req.Route)]; ok {
+		return &util.CrankerErr{Msg: fmt.Sprintf("route %s is reserved", req.Route), Code: http.StatusForbidden}
+	}
+	if p.maxSocketsPerInstance > 0 && stats.SocketsOfInstance(req.ConnectorInstanceID) >= p.maxSocketsPerInstance {
+		return &util.CrankerErr{
+			Msg:  fmt.Sprintf("connector instance %s already has %d sockets", req.ConnectorInstanceID, p.maxSocketsPerInstance),
+			Code: http.StatusTooManyRequests,
+		}
+	}
+	if p.maxInstancesPerRoute > 0 {
+		instances := stats.InstancesOfRoute(req.Route)
+		if len(instances) >= p.maxInstancesPerRoute && !contains(instances, req.ConnectorInstanceID) {
+			return &util.CrankerErr{
+				Msg:  fmt.Sprintf("route %s already has %d connector instances", req.Route, p.maxInstancesPerRoute),
+				Code: http.StatusTooManyRequests,
+			}
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package router
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/torchcc/crank4go/router/router_socket"
+	"github.com/torchcc/crank4go/util"
+)
+
+func TestDefaultRegistrationPolicy(t *testing.T) {
+	policy := NewDefaultRegistrationPolicy().
+		SetReservedRoutes("API", "health").
+		SetCatchAllBlocked(true).
+		SetMaxSocketsPerInstance(2).
+		SetMaxInstancesPerRoute(2)
+	registry := router_socket.NewConnectionRegistry()
+	admit := func(route, instance string) error {
+		_, err := registry.Admit(route, instance, func(stats router_socket.RegistrationStats) error {
+			return policy.Evaluate(&RegistrationRequest{Route: route, ConnectorInstanceID: instance}, stats)
+		})
+		return err
+	}
+	tests := []struct {
+		name       string
+		route      string
+		instance   string
+		wantStatus int
+	}{
+		{name: "reserved", route: "api", instance: "a", wantStatus: http.StatusForbidden},
+		{name: "blankCatchAll", route: "", instance: "a", wantStatus: http.StatusForbidden},
+		{name: "starCatchAll", route: "*", instance: "a", wantStatus: http.StatusForbidden},
+		{name: "firstSocketOfA", route: "orders", instance: "a"},
+		{name: "secondSocketOfA", route: "orders", instance: "a"},
+		{name: "thirdSocketOfA", route: "orders", instance: "a", wantStatus: http.StatusTooManyRequests},
+		{name: "secondInstance", route: "orders", instance: "b"},
+		{name: "thirdInstance", route: "orders", instance: "c", wantStatus: http.StatusTooManyRequests},
+		{name: "thirdInstanceOtherRoute", route: "payments", instance: "c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := admit(tt.route, tt.instance)
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Errorf("Admit(%s, %s) error = %v", tt.route, tt.instance, err)
+				}
+				return
+			}
+			if e, ok := err.(*util.CrankerErr); !ok || e.Code != tt.wantStatus {
+				t.Errorf("Admit(%s, %s) error = %v, want status %d", tt.route, tt.instance, err, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestConnectionRegistryRelease(t *testing.T) {
+	registry := router_socket.NewConnectionRegistry()
+	release, _ := registry.Admit("orders", "a", nil)
+	release2, _ := registry.Admit("orders", "a", nil)
+	release()
+	release() // releasing twice must not count twice
+	if got := registry.Snapshot()["orders"]["a"]; got != 1 {
+		t.Fatalf("sockets of a = %d, want 1", got)
+	}
+	release2()
+	if snapshot := registry.Snapshot(); len(snapshot) != 0 {
+		t.Errorf("Snapshot() = %v, want empty", snapshot)
+	}
+}
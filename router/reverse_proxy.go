@@ -1,17 +1,22 @@
 package router
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	ptc "github.com/torchcc/crank4go/protocol"
+	"github.com/torchcc/crank4go/router/darklaunch_manager"
 	itc "github.com/torchcc/crank4go/router/interceptor"
 	"github.com/torchcc/crank4go/router/router_socket"
+	"github.com/torchcc/crank4go/router/shadow_mirror"
 	"github.com/torchcc/crank4go/util"
 )
 
@@ -48,9 +53,16 @@ type ReverseProxy struct {
 	websocketFarm         *router_socket.WebsocketFarm
 	reqComponentHeader    string
 	interceptors          []itc.ProxyInterceptor
+	shadowMirror          *shadow_mirror.ShadowMirror
+	trustedProxies        util.IpRanges
 }
 
 func NewReverseProxy(farm *router_socket.WebsocketFarm, reqComponentHeader string, interceptors []itc.ProxyInterceptor) *ReverseProxy {
+	return NewReverseProxy2(farm, reqComponentHeader, interceptors, nil)
+}
+
+// NewReverseProxy2 with a non-nil shadowMirror, a copy of each live request is sent to a dark socket while gray testing is off
+func NewReverseProxy2(farm *router_socket.WebsocketFarm, reqComponentHeader string, interceptors []itc.ProxyInterceptor, shadowMirror *shadow_mirror.ShadowMirror) *ReverseProxy {
 	proxy := &ReverseProxy{
 		HopByHopHeadersFields: map[string]struct{}{
 			"Connection": {}, "Keep-Alive": {}, "Proxy-Authenticate": {}, "Proxy-Authorization": {},
@@ -58,6 +70,7 @@ func NewReverseProxy(farm *router_socket.WebsocketFarm, reqComponentHeader strin
 		websocketFarm:      farm,
 		reqComponentHeader: reqComponentHeader,
 		interceptors:       interceptors,
+		shadowMirror:       shadowMirror,
 	}
 	if interceptors == nil {
 		proxy.interceptors = make([]itc.ProxyInterceptor, 0, 8)
@@ -66,6 +79,12 @@ func NewReverseProxy(farm *router_socket.WebsocketFarm, reqComponentHeader strin
 	return proxy
 }
 
+// SetTrustedProxies the forwarding headers sent by these proxies are passed on, those of any other client are replaced
+func (p *ReverseProxy) SetTrustedProxies(trustedProxies util.IpRanges) *ReverseProxy {
+	p.trustedProxies = trustedProxies
+	return p
+}
+
 func (p *ReverseProxy) String() string {
 	return fmt.Sprintf("ReverseProxy{websocketFarm=%v, requestComponentHeader=%s, interceptors=%s}",
 		p.websocketFarm, p.reqComponentHeader, p.interceptors)
@@ -85,6 +104,12 @@ func (p *ReverseProxy) Handle(w http.ResponseWriter, r *http.Request, params htt
 			w.WriteHeader(http.StatusNotFound)
 			_, _ = w.Write([]byte("Service Not Found"))
 			return true
+		} else if overloadErr, ok := err.(util.OverloadErr); ok {
+			util.LOG.Errorf("failed to forward target %s, too many in-flight requests, err: %s", target, overloadErr)
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("Too Many Requests In Flight"))
+			return true
 		} else { // TODO should assert if err is timeoutErr or not ?
 			util.LOG.Errorf("failed to forward target %s, after timeout, err: %s", target, routeErr)
 			w.WriteHeader(http.StatusServiceUnavailable)
@@ -95,14 +120,103 @@ func (p *ReverseProxy) Handle(w http.ResponseWriter, r *http.Request, params htt
 	util.LOG.Infof("proxying to target service, forwarding %s from %s to %s, "+
 		"connectorID=%s, requestComponentName=%s", target, r.RemoteAddr, crankedSocket.RemoteAddr(),
 		crankedSocket.ConnectorInstanceID(), componentName)
+	var (
+		respWriter  http.ResponseWriter = w
+		primaryResp *shadow_mirror.ResponseCapture
+		primaryDone chan<- *shadow_mirror.ResponseSummary
+	)
+	if p.shouldMirror(target) {
+		if primaryDone = p.mirrorRequest(r, target, componentName); primaryDone != nil {
+			primaryResp = shadow_mirror.NewResponseCapture(w)
+			respWriter = primaryResp
+		}
+	}
 	handleDone := &sync.WaitGroup{} // its Done method must be called only after the writing to respWriter is finished.
 	handleDone.Add(1)
-	p.sendRequestOverWebsocket(r, w, crankedSocket, handleDone)
+	p.sendRequestOverWebsocket(r, respWriter, crankedSocket, handleDone)
 	handleDone.Wait()
+	if primaryDone != nil {
+		primaryDone <- primaryResp.Summary()
+	}
 	return true
 
 }
 
+func (p *ReverseProxy) shouldMirror(target string) bool {
+	return p.shadowMirror != nil && !darklaunch_manager.IsGrayTestingOn() && p.websocketFarm.HasDarkSockets(target)
+}
+
+// mirrorRequest sends a copy of the client request to a dark socket, the mirrored response is compared with the primary one
+// once the primary response is sent to the returned chan. nil is returned if the request can not be mirrored
+func (p *ReverseProxy) mirrorRequest(cliReq *http.Request, target, componentName string) chan<- *shadow_mirror.ResponseSummary {
+	report := p.shadowMirror.Report()
+	if !p.shadowMirror.Mirrors(cliReq.Method) {
+		report.OnSkipped()
+		return nil
+	}
+	body, ok := p.bufferReqBody(cliReq)
+	if !ok {
+		util.LOG.Debugf("request body of %s is too big or unreadable, not mirroring it", target)
+		report.OnSkipped()
+		return nil
+	}
+	shadowReq := cliReq.Clone(context.Background())
+	shadowReq.Body = http.NoBody
+	if body != nil {
+		shadowReq.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	// buffered, so the primary request never waits for the mirrored one
+	primaryDone := make(chan *shadow_mirror.ResponseSummary, 1)
+	go func() {
+		// the dark socket is acquired here so the primary request is not held up while waiting for one
+		socket, err := p.websocketFarm.AcquireDarkSocket(target, componentName, p.shadowMirror.AcquireTimeout())
+		if err != nil {
+			util.LOG.Debugf("not mirroring %s, err: %s", target, err.Error())
+			report.OnSkipped()
+			return
+		}
+		report.OnMirrored()
+		shadowResp := shadow_mirror.NewResponseCapture(nil)
+		handleDone := &sync.WaitGroup{}
+		handleDone.Add(1)
+		p.sendRequestOverWebsocket(shadowReq, shadowResp, socket, handleDone)
+		finished := make(chan struct{})
+		go func() {
+			handleDone.Wait()
+			close(finished)
+		}()
+		select {
+		case <-finished:
+		case <-time.After(p.shadowMirror.MirrorTimeout()):
+			util.LOG.Warningf("mirrored request %s to dark socket %s timed out", target, socket.RouterSocketID)
+			socket.CloseSocketSession()
+			return
+		}
+		primary := <-primaryDone
+		p.shadowMirror.Compare(shadowReq.Method, shadowReq.URL.Path, socket.Route, primary, shadowResp.Summary())
+	}()
+	return primaryDone
+}
+
+// bufferReqBody reads the request body into memory so that it can be sent twice, the original body is kept readable.
+// false is returned if the body is bigger than the shadowMirror allows
+func (p *ReverseProxy) bufferReqBody(req *http.Request) ([]byte, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true
+	}
+	maxBodyBytes := p.shadowMirror.MaxBodyBytes()
+	if req.ContentLength > maxBodyBytes {
+		return nil, false
+	}
+	buf, err := io.ReadAll(io.LimitReader(req.Body, maxBodyBytes+1))
+	req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(buf), req.Body))
+	if err != nil || int64(len(buf)) > maxBodyBytes {
+		return nil, false
+	}
+	return buf, true
+}
+
 func (p *ReverseProxy) componentNameFromHeader(r *http.Request) (name string) {
 	if strings.TrimSpace(p.reqComponentHeader) != "" {
 		name = r.Header.Get(strings.TrimSpace(p.reqComponentHeader))
@@ -195,31 +309,11 @@ func (p *ReverseProxy) setTargetReqHeaders(req *http.Request, headersBuilder *pt
 			headersBuilder.AppendHeader(headerName, value)
 		}
 	}
-	addProxyForwardingHeaders(headersBuilder, req)
+	addProxyForwardingHeaders(headersBuilder, req, p.trustedProxies)
 	return hasContentLength || hasTransferEncodingHeader
 
 }
 
-func addProxyForwardingHeaders(headers *ptc.HeadersBuilder, req *http.Request) {
-	xfor := req.RemoteAddr
-	proto := req.URL.Scheme
-	host := req.Host
-	by := ip
-	headers.AppendHeader("Forwarded", fmt.Sprintf("for=%s;proto=%s;host=%s;by=%s", xfor, proto, host, by))
-	if req.Header.Get("X-Forwarded-For") == "" {
-		headers.AppendHeader("X-Forwarded-For", xfor)
-	}
-	if req.Header.Get("X-Forwarded-Proto") == "" {
-		headers.AppendHeader("X-Forwarded-Proto", proto)
-	}
-	if req.Header.Get("X-Forwarded-Host") == "" {
-		headers.AppendHeader("X-Forwarded-Host", host)
-	}
-	if req.Header.Get("X-Forwarded-Server") == "" {
-		headers.AppendHeader("X-Forwarded-Server", by)
-	}
-}
-
 func (p *ReverseProxy) shouldSendHeaderFromClientToTarget(headerName string, connHeaders []string) bool {
 	if util.UUI31223NotSendingHostHeader && "Host" == headerName {
 		return false
@@ -227,6 +321,9 @@ func (p *ReverseProxy) shouldSendHeaderFromClientToTarget(headerName string, con
 	if _, ok := p.HopByHopHeadersFields[headerName]; ok {
 		return false
 	}
+	if _, ok := forwardingHeaders[headerName]; ok {
+		return false
+	}
 	for _, v := range connHeaders {
 		if v == headerName {
 			return false
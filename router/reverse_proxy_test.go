@@ -0,0 +1,134 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	ptc "github.com/torchcc/crank4go/protocol"
+	"github.com/torchcc/crank4go/router/darklaunch_manager"
+	"github.com/torchcc/crank4go/router/router_socket"
+	"github.com/torchcc/crank4go/router/shadow_mirror"
+	"github.com/torchcc/crank4go/util"
+)
+
+// fakeConnector registers a socket of the instance to the farm and answers the request it gets with the status and body
+func fakeConnector(t *testing.T, farm *router_socket.WebsocketFarm, instance string, status int, body string, delay time.Duration) {
+	connMonitor := util.NewConnectionMonitor(nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&ws.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		socket := router_socket.NewRouterSocket("svc", connMonitor, farm, instance, true, "127.0.0.1", nil)
+		socket.SetOnReadyToAct(func() { farm.AddWebsocket("svc", socket) })
+		socket.OnWebsocketConnect(conn)
+	}))
+	t.Cleanup(server.Close)
+	conn, _, err := ws.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to connect %s, err: %s", instance, err.Error())
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		for {
+			msgType, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msgType != ws.TextMessage || !(strings.HasSuffix(string(msg), ptc.RequestHasNoBodyMarker) || strings.HasSuffix(string(msg), ptc.RequestBodyEndedMarker)) {
+				continue
+			}
+			time.Sleep(delay)
+			_ = conn.WriteMessage(ws.TextMessage, []byte("HTTP/1.1 "+strconv.Itoa(status)+" OK\nGET /svc/hello\n"))
+			_ = conn.WriteMessage(ws.BinaryMessage, []byte(body))
+			_ = conn.WriteMessage(ws.CloseMessage, ws.FormatCloseMessage(ws.CloseNormalClosure, ""))
+		}
+	}()
+}
+
+type noopInstanceListener struct{}
+
+func (l *noopInstanceListener) AfterDarkInstanceAdded(string)   {}
+func (l *noopInstanceListener) AfterDarkInstanceRevoked(string) {}
+
+// darkLaunchedFarm a farm with a live socket answering 200 right away and a dark one answering 500 after the delay
+func darkLaunchedFarm(t *testing.T, darkDelay time.Duration) *router_socket.WebsocketFarm {
+	manager := darklaunch_manager.NewDarkLaunchManager().SetInstanceListener(&noopInstanceListener{})
+	_ = manager.AddInstance("dark")
+	farm := router_socket.NewWebsocketFarm(util.NewConnectionMonitor(nil), manager)
+	fakeConnector(t, farm, "live", http.StatusOK, "live response", 0)
+	fakeConnector(t, farm, "dark", http.StatusInternalServerError, "dark response", darkDelay)
+	deadline := time.Now().Add(2 * time.Second)
+	for !farm.HasDarkSockets("/svc/hello") || len(farm.AllSockets()["svc"]) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the sockets did not register")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return farm
+}
+
+func TestReverseProxyMirrorsToDarkSockets(t *testing.T) {
+	farm := darkLaunchedFarm(t, 300*time.Millisecond)
+	deadline := time.Now().Add(2 * time.Second)
+	mirror := shadow_mirror.NewShadowMirror(0)
+	proxy := NewReverseProxy2(farm, "", nil, mirror)
+
+	w := httptest.NewRecorder()
+	start := time.Now()
+	proxy.Handle(w, httptest.NewRequest(http.MethodGet, "/svc/hello", nil), nil)
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("the client waited %v, the slow mirrored request must not hold up the primary one", elapsed)
+	}
+	if w.Code != http.StatusOK || w.Body.String() != "live response" {
+		t.Errorf("client got %d %q, want the primary response only", w.Code, w.Body.String())
+	}
+
+	for time.Now().Before(deadline) && len(mirror.Report().Mismatches()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	mismatches := mirror.Report().Mismatches()
+	if len(mismatches) != 1 || mismatches[0].Primary.Status != http.StatusOK || mismatches[0].Shadow.Status != http.StatusInternalServerError {
+		t.Fatalf("mismatches = %+v, want the dark 500 compared with the live 200", mismatches)
+	}
+	if report := mirror.Report().ToMap(); report["mirrored"] != int64(1) {
+		t.Errorf("report = %v, want one mirrored request", report)
+	}
+}
+
+func TestReverseProxyMirrorsSafeMethods(t *testing.T) {
+	tests := []struct {
+		name         string
+		mirror       *shadow_mirror.ShadowMirror
+		wantMirrored int64
+		wantSkipped  int64
+	}{
+		{name: "by default", mirror: shadow_mirror.NewShadowMirror(0), wantSkipped: 1},
+		{name: "allowed", mirror: shadow_mirror.NewShadowMirror(0).SetMirroredMethods(http.MethodGet, http.MethodPost), wantMirrored: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := NewReverseProxy2(darkLaunchedFarm(t, 0), "", nil, tt.mirror)
+			w := httptest.NewRecorder()
+			proxy.Handle(w, httptest.NewRequest(http.MethodPost, "/svc/hello", strings.NewReader("order")), nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("client got %d, want the primary response", w.Code)
+			}
+			// the mirrored request is counted once its goroutine got a dark socket
+			deadline := time.Now().Add(2 * time.Second)
+			report := tt.mirror.Report().ToMap()
+			for report["mirrored"] != tt.wantMirrored && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+				report = tt.mirror.Report().ToMap()
+			}
+			if report["mirrored"] != tt.wantMirrored || report["skipped"] != tt.wantSkipped {
+				t.Errorf("report = %v, want %d mirrored and %d skipped", report, tt.wantMirrored, tt.wantSkipped)
+			}
+		})
+	}
+}
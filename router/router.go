@@ -12,12 +12,14 @@ import (
 
 	ws "github.com/gorilla/websocket"
 	"github.com/julienschmidt/httprouter"
+	"github.com/torchcc/crank4go/credential"
 	ptc "github.com/torchcc/crank4go/protocol"
 	"github.com/torchcc/crank4go/router/api"
 	"github.com/torchcc/crank4go/router/corsheader_processor"
 	"github.com/torchcc/crank4go/router/darklaunch_manager"
 	"github.com/torchcc/crank4go/router/handler"
 	"github.com/torchcc/crank4go/router/router_socket"
+	"github.com/torchcc/crank4go/router/webhook"
 	"github.com/torchcc/crank4go/util"
 )
 
@@ -48,6 +50,7 @@ type Router struct {
 	pingInterval        time.Duration
 	routerAvailability  *RouterAvailability
 	corsHeaderProcessor *corsheader_processor.CorsHeaderProcessor
+	webhookDispatcher   *webhook.Dispatcher
 }
 
 func (r *Router) WebsocketFarm() *router_socket.WebsocketFarm {
@@ -83,7 +86,7 @@ func validateCrankerProtocolVersion(req *http.Request) error {
 
 func validateIpAddr(validator Validator, req *http.Request) error {
 	if !validator.IsValid(req.RemoteAddr) {
-		msg := fmt.Sprintf("failed to establish websocket connection to cranker connector for invalid ip: %s, routerName is: %s", ip, req.Header.Get("Route"))
+		msg := fmt.Sprintf("failed to establish websocket connection to cranker connector for invalid ip: %s, routerName is: %s", req.RemoteAddr, req.Header.Get("Route"))
 		util.LOG.Warning(msg)
 		return &util.CrankerErr{
 			Msg:  msg,
@@ -111,6 +114,42 @@ func NewRouter(routerConfig *RouterConfig) *Router {
 		corsHeaderProcessor: corsheader_processor.NewCorsHeaderProcessor(routerConfig.CheckOrigin()),
 	}
 
+	if routerConfig.ConnectorCertPolicy() != nil {
+		if r.websocketTLSConfig == nil {
+			util.LOG.Errorf("connector cert policy is configured without websocketTLSConfig, all registrations will be rejected")
+		} else {
+			// client certificates are verified if given, connectorRegisterToRouter rejects connectors without one
+			r.websocketTLSConfig = r.websocketTLSConfig.Clone()
+			r.websocketTLSConfig.ClientCAs = routerConfig.ConnectorClientCAs()
+			r.websocketTLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	if monitor := routerConfig.DarkLaunchErrorRateMonitor(); monitor != nil {
+		r.darkLaunchManager.SetErrorRateMonitor(monitor)
+	}
+	if limits := routerConfig.ConcurrencyLimits(); limits != nil {
+		r.websocketFarm.SetConcurrencyLimits(limits)
+	}
+	if breakers := routerConfig.CircuitBreakers(); breakers != nil {
+		r.websocketFarm.SetCircuitBreakers(breakers)
+	}
+	if outliers := routerConfig.OutlierDetector(); outliers != nil {
+		r.websocketFarm.SetOutlierDetector(outliers)
+	}
+	if slowStart := routerConfig.SlowStart(); slowStart != nil {
+		r.websocketFarm.SetSlowStart(slowStart)
+	}
+	timeouts := routerConfig.RouteTimeouts()
+	if timeouts == nil && r.idleTimeout > 0 {
+		timeouts = router_socket.NewRouteTimeouts(router_socket.ResponseTimeouts{})
+	}
+	if timeouts != nil {
+		if defaults := timeouts.Defaults(); defaults.Idle == 0 && r.idleTimeout > 0 {
+			defaults.Idle = r.idleTimeout
+			timeouts.SetDefaults(defaults)
+		}
+		r.websocketFarm.SetRouteTimeouts(timeouts)
+	}
 	r.routerAvailability = NewRouterAvailability2(r.connMonitor, r.websocketFarm, r.darkLaunchManager, routerConfig.IsShutDownHookAdded())
 	theSecureS := ""
 	if r.webserverTLSConfig != nil {
@@ -126,14 +165,19 @@ func NewRouter(routerConfig *RouterConfig) *Router {
 	return r
 }
 
-func (r *Router) connectorRegisterToRouter(respWriter http.ResponseWriter, req *http.Request) *router_socket.RouterSocket {
+// connectorRegisterToRouter onDisconnected is called once the registered websocket is disconnected
+func (r *Router) connectorRegisterToRouter(respWriter http.ResponseWriter, req *http.Request, onDisconnected func()) *router_socket.RouterSocket {
 	route := getRoute(req)
-	connectorInstanceID := req.URL.Query().Get("connectorInstanceID")
-	if connectorInstanceID == "" {
-		connectorInstanceID = "unknown-" + req.RemoteAddr
+	if err := r.checkCertOwnsRoute(req, route); err != nil {
+		e := err.(*util.CrankerErr)
+		api.RespTextPlainWithStatus(respWriter, e.Error(), e.Code)
+		return nil
 	}
+	connectorInstanceID := connectorInstanceIDOf(req)
 	util.LOG.Info("the register request connectorInstanceID is %s", connectorInstanceID)
 	routerSocket := router_socket.NewRouterSocket2(route, r.connMonitor, r.websocketFarm, connectorInstanceID, true, req.RemoteAddr, r.corsHeaderProcessor, r.routerConfig.RouterSocketPlugins())
+	routerSocket.SetVersionLabel(req.URL.Query().Get("versionLabel"))
+	routerSocket.SetOnDisconnected(onDisconnected)
 	util.LOG.Infof("got routerSocket %s", routerSocket.String())
 	routerSocket.SetOnReadyToAct(func() {
 		r.websocketFarm.AddWebsocket(route, routerSocket)
@@ -155,8 +199,20 @@ func (r *Router) connectorRegisterToRouter(respWriter http.ResponseWriter, req *
 	}
 }
 
+func connectorInstanceIDOf(req *http.Request) string {
+	if connectorInstanceID := req.URL.Query().Get("connectorInstanceID"); connectorInstanceID != "" {
+		return connectorInstanceID
+	}
+	return "unknown-" + req.RemoteAddr
+}
+
 func (r *Router) connectorDeregisterFromRouter(respWriter http.ResponseWriter, req *http.Request) *router_socket.RouterSocket {
 	route := getRoute(req)
+	if err := r.checkCertOwnsRoute(req, route); err != nil {
+		e := err.(*util.CrankerErr)
+		api.RespTextPlainWithStatus(respWriter, e.Error(), e.Code)
+		return nil
+	}
 	connectorInstanceID := req.URL.Query().Get("connectorInstanceID")
 	if connectorInstanceID == "" {
 		connectorInstanceID = "no connector instance id exist"
@@ -200,83 +256,211 @@ func (r *Router) CreateRegisterHandler() *httprouter.Router {
 	httpRouter.GET("/deregister", deregisterWsXHandler.ServeXHTTP)
 	httpRouter.GET("/deregister/", deregisterWsXHandler.ServeXHTTP)
 
-	// TODO should add authentication filter to restraint access
+	adminReqFilters := r.adminReqFilters()
+	auditSink := r.routerConfig.AuditSink()
+	darkLaunchState := func() interface{} { return r.darkLaunchManager.Snapshot() }
 	darkLaunchServiceResource := api.NewDarkLaunchServiceResource(r.darkLaunchManager)
 	darkLaunchServiceResource.
-		AddReqFilters(handler.XHandlerFunc(handler.PreLoggingFilter)).
-		AddRespFilters(handler.XHandlerFunc(handler.PostLoggingFilter))
+		AddReqFilters(adminReqFilters...).
+		AddRespFilters(handler.XHandlerFunc(handler.PostLoggingFilter)).
+		SetAuditor(auditSink, darkLaunchState)
 	darkLaunchServiceResource.RegisterResourceToHttpRouter(httpRouter, "/api")
 
 	darkLaunchIpResource := api.NewDarkLaunchIpResource(r.darkLaunchManager)
 	darkLaunchIpResource.
-		AddReqFilters(handler.XHandlerFunc(handler.PreLoggingFilter)).
-		AddRespFilters(handler.XHandlerFunc(handler.PostLoggingFilter))
+		AddReqFilters(adminReqFilters...).
+		AddRespFilters(handler.XHandlerFunc(handler.PostLoggingFilter)).
+		SetAuditor(auditSink, darkLaunchState)
 	darkLaunchIpResource.RegisterResourceToHttpRouter(httpRouter, "/api")
 
+	darkLaunchInstanceResource := api.NewDarkLaunchInstanceResource(r.darkLaunchManager)
+	darkLaunchInstanceResource.
+		AddReqFilters(adminReqFilters...).
+		AddRespFilters(handler.XHandlerFunc(handler.PostLoggingFilter)).
+		SetAuditor(auditSink, darkLaunchState)
+	darkLaunchInstanceResource.RegisterResourceToHttpRouter(httpRouter, "/api")
+
+	darkLaunchVersionResource := api.NewDarkLaunchVersionResource(r.darkLaunchManager)
+	darkLaunchVersionResource.
+		AddReqFilters(adminReqFilters...).
+		AddRespFilters(handler.XHandlerFunc(handler.PostLoggingFilter)).
+		SetAuditor(auditSink, darkLaunchState)
+	darkLaunchVersionResource.RegisterResourceToHttpRouter(httpRouter, "/api")
+
 	launchGrayToggleResource := api.NewDarkLaunchGrayToggleResource(r.darkLaunchManager)
 	launchGrayToggleResource.
-		AddReqFilters(handler.XHandlerFunc(handler.PreLoggingFilter)).
-		AddRespFilters(handler.XHandlerFunc(handler.PostLoggingFilter))
+		AddReqFilters(adminReqFilters...).
+		AddRespFilters(handler.XHandlerFunc(handler.PostLoggingFilter)).
+		SetAuditor(auditSink, darkLaunchState)
 	launchGrayToggleResource.RegisterResourceToHttpRouter(httpRouter, "/api")
 
 	registrationsResource := api.NewRegistrationsResource(r.websocketFarm)
 	registrationsResource.
-		AddReqFilters(handler.XHandlerFunc(handler.PreLoggingFilter)).
+		AddReqFilters(adminReqFilters...).
 		AddRespFilters(handler.XHandlerFunc(handler.PostLoggingFilter))
 	registrationsResource.RegisterResourceToHttpRouter(httpRouter, "/api")
 
+	connectorsResource := api.NewConnectorsResource(r.websocketFarm)
+	connectorsResource.
+		AddReqFilters(adminReqFilters...).
+		AddRespFilters(handler.XHandlerFunc(handler.PostLoggingFilter)).
+		SetAuditor(auditSink, func() interface{} { return r.websocketFarm.Instances() })
+	connectorsResource.RegisterResourceToHttpRouter(httpRouter, "/api")
+
+	inflightResource := api.NewInflightResource(r.websocketFarm)
+	inflightResource.
+		AddReqFilters(adminReqFilters...).
+		AddRespFilters(handler.XHandlerFunc(handler.PostLoggingFilter)).
+		SetAuditor(auditSink, nil)
+	inflightResource.RegisterResourceToHttpRouter(httpRouter, "/api")
+
+	concurrencyResource := api.NewConcurrencyResource(r.websocketFarm)
+	concurrencyResource.
+		AddReqFilters(adminReqFilters...).
+		AddRespFilters(handler.XHandlerFunc(handler.PostLoggingFilter)).
+		SetAuditor(auditSink, func() interface{} { return r.websocketFarm.ConcurrencyLimits().Status() })
+	concurrencyResource.RegisterResourceToHttpRouter(httpRouter, "/api")
+
+	eventsResource := api.NewEventsResource(r.websocketFarm)
+	eventsResource.
+		AddReqFilters(adminReqFilters...).
+		AddRespFilters(handler.XHandlerFunc(handler.PostLoggingFilter))
+	eventsResource.RegisterResourceToHttpRouter(httpRouter, "/api")
+
+	adminResources := []api.Resource{darkLaunchServiceResource, darkLaunchIpResource, darkLaunchInstanceResource,
+		darkLaunchVersionResource, launchGrayToggleResource, registrationsResource, connectorsResource, inflightResource,
+		concurrencyResource, eventsResource}
+	if shadowMirror := r.routerConfig.ShadowMirror(); shadowMirror != nil {
+		shadowMirrorResource := api.NewShadowMirrorResource(shadowMirror)
+		shadowMirrorResource.
+			AddReqFilters(adminReqFilters...).
+			AddRespFilters(handler.XHandlerFunc(handler.PostLoggingFilter)).
+			SetAuditor(auditSink, func() interface{} { return shadowMirror.Report().ToMap() })
+		shadowMirrorResource.RegisterResourceToHttpRouter(httpRouter, "/api")
+		adminResources = append(adminResources, shadowMirrorResource)
+	}
+
+	if auditSink != nil {
+		auditResource := api.NewAuditResource(auditSink)
+		auditResource.
+			AddReqFilters(adminReqFilters...).
+			AddRespFilters(handler.XHandlerFunc(handler.PostLoggingFilter))
+		auditResource.RegisterResourceToHttpRouter(httpRouter, "/api")
+		adminResources = append(adminResources, auditResource)
+	}
+
+	openApiResource := api.NewOpenApiResource("crank4go admin API", "v1").Describe("/api", adminResources...)
+	openApiResource.
+		AddReqFilters(adminReqFilters...).
+		AddRespFilters(handler.XHandlerFunc(handler.PostLoggingFilter))
+	openApiResource.RegisterResourceToHttpRouter(httpRouter, "/api")
+
 	return httpRouter
 }
 
-// CreateHttpHandler can configure rate limit here
+// adminReqFilters the request filters of the admin API, requests are authenticated if admin authenticators are configured
+func (r *Router) adminReqFilters() []handler.XHandler {
+	filters := []handler.XHandler{handler.XHandlerFunc(handler.PreLoggingFilter)}
+	if authenticators := r.routerConfig.AdminAuthenticators(); len(authenticators) > 0 {
+		filters = append(filters, handler.NewAuthFilter(authenticators...))
+	} else {
+		util.LOG.Warningf("no admin authenticators configured, the admin API is open to anyone reaching the registration port")
+	}
+	return filters
+}
+
+// CreateHttpHandler requests over the rate limit are rejected right after they are logged
 func (r *Router) CreateHttpHandler() *handler.XHTTPHandler {
-	return handler.NewXHttpHandler(NewReverseProxy(r.websocketFarm, r.reqComponentHeader, r.routerConfig.ProxyInterceptors())).
-		AddReqHandlers(handler.XHandlerFunc(handler.PreLoggingFilter)).
+	reverseProxy := NewReverseProxy2(r.websocketFarm, r.reqComponentHeader, r.routerConfig.ProxyInterceptors(), r.routerConfig.ShadowMirror()).
+		SetTrustedProxies(r.routerConfig.TrustedProxies())
+	xHandler := handler.NewXHttpHandler(reverseProxy).
+		AddReqHandlers(handler.XHandlerFunc(handler.PreLoggingFilter))
+	if rateLimitFilter := r.routerConfig.RateLimitFilter(); rateLimitFilter != nil {
+		if len(rateLimitFilter.DataPublishHandlers()) == 0 && r.connMonitor != nil {
+			rateLimitFilter.SetDataPublishHandlers(r.connMonitor)
+		}
+		if rateLimitFilter.TrustedProxies() == nil {
+			rateLimitFilter.SetTrustedProxies(r.routerConfig.TrustedProxies())
+		}
+		xHandler.AddReqHandlers(rateLimitFilter)
+	}
+	return xHandler.
 		AddReqHandlers(r.routerConfig.HandlerList()...).
 		AddReqHandlers(handler.XHandlerFunc(handler.ReqValidatorFilter))
 }
 
 func (r *Router) Start() *Router {
+	r.darkLaunchManager.StartExpirySweeper(r.routerConfig.DarkLaunchSweepInterval())
+	if path := r.routerConfig.IpWhiteListFile(); path != "" {
+		ipValidator, ok := r.ipValidator.(*IpValidator)
+		if !ok {
+			panic(fmt.Sprintf("ip white list file %s is configured, but the ip validator is a %T instead of an *IpValidator", path, r.ipValidator))
+		}
+		if err := ipValidator.WatchIpWhiteListFile(path, r.routerConfig.IpWhiteListReloadInterval()); err != nil {
+			util.LOG.Warningf("crankerRouter failed to load the ip white list file %s, err: %s", path, err.Error())
+			panic(err)
+		}
+	}
+	if r.pingInterval > 0 {
+		r.routerAvailability.scheduleSendPingToConnector(r.pingInterval, r.routerConfig.MaxMissedPongs())
+	}
+	if targets := r.routerConfig.WebhookTargets(); len(targets) > 0 {
+		r.webhookDispatcher = webhook.NewDispatcher(r.RegisterURI.Host, targets...).Start()
+		r.websocketFarm.AddListener(r.webhookDispatcher)
+		util.LOG.Infof("posting websocket farm events to %s", r.webhookDispatcher)
+	}
 	serveMux := http.NewServeMux()
 	serveMux.Handle("/", r.CreateHttpHandler())
-	r.httpServer = &http.Server{
+	httpSettings := r.routerConfig.HttpServerSettings()
+	r.httpServer = httpSettings.applyTo(&http.Server{
 		Addr:           r.HttpURI.Host,
 		Handler:        serveMux,
 		TLSConfig:      r.webserverTLSConfig,
 		MaxHeaderBytes: 8192 * 4,
-	}
-	util.LOG.Infof("starting router httpServer on %v", r.HttpURI)
+	})
+	util.LOG.Infof("starting router httpServer on %v with %s", r.HttpURI, httpSettings)
 	go func() {
+		listener, err := httpSettings.listen(r.httpServer)
+		if err != nil {
+			util.LOG.Warningf("crankerRouter's httpServer failed to start, err: %s", err.Error())
+			panic(err)
+		}
 		if r.HttpURI.Scheme == "https" {
 			util.LOG.Infof("httpServer uses TLS")
-			if err := r.httpServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+			if err := r.httpServer.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
 				util.LOG.Warningf("crankerRouter's httpServer failed to start, err: %s", err.Error())
 				panic(err)
 			}
 		} else {
-			if err := r.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			if err := r.httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
 				util.LOG.Warningf("crankerRouter's httpServer failed to start, err: %s", err.Error())
 				panic(err)
 			}
 		}
 	}()
 
-	r.registrationServer = &http.Server{
+	registrationSettings := r.routerConfig.RegistrationServerSettings()
+	r.registrationServer = registrationSettings.applyTo(&http.Server{
 		Addr:           fmt.Sprintf("%s", r.RegisterURI.Host),
 		Handler:        r.CreateRegisterHandler(),
 		TLSConfig:      r.websocketTLSConfig,
 		MaxHeaderBytes: 8192 * 4,
-	}
-	util.LOG.Infof("starting router registrationServer on %v", r.RegisterURI)
+	})
+	util.LOG.Infof("starting router registrationServer on %v with %s", r.RegisterURI, registrationSettings)
 	go func() {
+		listener, err := registrationSettings.listen(r.registrationServer)
+		if err != nil {
+			util.LOG.Warningf("crankerRouter's registrationServer failed to start, err: %s", err.Error())
+			panic(err)
+		}
 		if r.RegisterURI.Scheme == "wss" {
 			util.LOG.Infof("registerServer uses TLS")
-			if err := r.registrationServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+			if err := r.registrationServer.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
 				util.LOG.Warningf("crankerRouter's registrationServer failed to start, err: %s", err.Error())
 				panic(err)
 			}
 		} else {
-			if err := r.registrationServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			if err := r.registrationServer.Serve(listener); err != nil && err != http.ErrServerClosed {
 				util.LOG.Warningf("crankerRouter's registrationServer failed to start, err: %s", err.Error())
 				panic(err)
 			}
@@ -286,6 +470,15 @@ func (r *Router) Start() *Router {
 }
 
 func (r *Router) Shutdown() {
+	r.darkLaunchManager.StopExpirySweeper()
+	r.routerAvailability.Shutdown()
+	if ipValidator, ok := r.ipValidator.(*IpValidator); ok {
+		ipValidator.StopWatching()
+	}
+	if r.webhookDispatcher != nil {
+		r.websocketFarm.RemoveListener(r.webhookDispatcher)
+		r.webhookDispatcher.Stop()
+	}
 	var wg sync.WaitGroup
 
 	go func() {
@@ -323,7 +516,47 @@ func (r *Router) registerWebsocketFactory(respWriter http.ResponseWriter, req *h
 		api.RespTextPlainWithStatus(respWriter, e.Error(), e.Code)
 		return nil
 	}
-	return r.connectorRegisterToRouter(respWriter, req)
+	if err := r.authenticateConnector(req); err != nil {
+		e := err.(*util.CrankerErr)
+		api.RespTextPlainWithStatus(respWriter, e.Error(), e.Code)
+		return nil
+	}
+	release, err := r.admitRegistration(req)
+	if err != nil {
+		e := err.(*util.CrankerErr)
+		api.RespTextPlainWithStatus(respWriter, e.Error(), e.Code)
+		return nil
+	}
+	routerSocket := r.connectorRegisterToRouter(respWriter, req, release)
+	if routerSocket == nil {
+		release()
+	}
+	return routerSocket
+}
+
+// admitRegistration evaluates the registration policy and counts the socket in the registry of the websocketFarm if it is accepted.
+// release must be called once the socket is disconnected
+func (r *Router) admitRegistration(req *http.Request) (release func(), err error) {
+	registration := &RegistrationRequest{
+		Route:               getRoute(req),
+		ConnectorInstanceID: connectorInstanceIDOf(req),
+		ComponentName:       req.URL.Query().Get("componentName"),
+		RemoteAddr:          req.RemoteAddr,
+	}
+	release, err = r.websocketFarm.Registry().Admit(registration.Route, registration.ConnectorInstanceID, func(stats router_socket.RegistrationStats) error {
+		if r.websocketFarm.IsDraining(registration.ConnectorInstanceID) {
+			return &util.CrankerErr{Msg: fmt.Sprintf("connector instance %s is draining", registration.ConnectorInstanceID), Code: http.StatusServiceUnavailable}
+		}
+		return r.routerConfig.RegistrationPolicy().Evaluate(registration, stats)
+	})
+	if err != nil {
+		util.LOG.Warningf("registration rejected by policy, route: %s, connectorInstanceID: %s, remoteAddr: %s, err: %s",
+			registration.Route, registration.ConnectorInstanceID, registration.RemoteAddr, err.Error())
+		if _, ok := err.(*util.CrankerErr); !ok {
+			err = &util.CrankerErr{Msg: err.Error(), Code: http.StatusForbidden}
+		}
+	}
+	return release, err
 }
 
 func (r *Router) deregisterWebsocketFactory(respWriter http.ResponseWriter, req *http.Request) *router_socket.RouterSocket {
@@ -332,9 +565,58 @@ func (r *Router) deregisterWebsocketFactory(respWriter http.ResponseWriter, req
 		api.RespTextPlainWithStatus(respWriter, e.Error(), e.Code)
 		return nil
 	}
+	if err := r.authenticateConnector(req); err != nil {
+		e := err.(*util.CrankerErr)
+		api.RespTextPlainWithStatus(respWriter, e.Error(), e.Code)
+		return nil
+	}
 	return r.connectorDeregisterFromRouter(respWriter, req)
 }
 
+// checkCertOwnsRoute checks the verified client certificate owns the route if a connector cert policy is configured
+func (r *Router) checkCertOwnsRoute(req *http.Request, route string) error {
+	policy := r.routerConfig.ConnectorCertPolicy()
+	if policy == nil {
+		return nil
+	}
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		util.LOG.Warningf("rejected connector without verified client certificate, remoteAddr: %s, route: %s", req.RemoteAddr, route)
+		return &util.CrankerErr{Msg: "a client certificate is required to register", Code: http.StatusUnauthorized}
+	}
+	identity := policy.Identify(req.TLS.VerifiedChains[0][0])
+	if !identity.CanRegister(route) {
+		util.LOG.Warningf("rejected connector certificate %s which does not own route %s, remoteAddr: %s", identity.String(), route, req.RemoteAddr)
+		return &util.CrankerErr{Msg: fmt.Sprintf("client certificate of %s does not allow route %s", identity.Subject, route), Code: http.StatusForbidden}
+	}
+	util.LOG.Debugf("connector certificate identified as %s, remoteAddr: %s, route: %s", identity.String(), req.RemoteAddr, route)
+	return nil
+}
+
+// authenticateConnector verifies the registration token if a verifier is configured, and checks the token owns the requested route
+func (r *Router) authenticateConnector(req *http.Request) error {
+	verifier := r.routerConfig.RegistrationVerifier()
+	if verifier == nil {
+		return nil
+	}
+	route := getRoute(req)
+	token := credential.BearerToken(req)
+	if token == "" {
+		util.LOG.Warningf("rejected connector without registration token, remoteAddr: %s, route: %s", req.RemoteAddr, route)
+		return &util.CrankerErr{Msg: credential.ErrMissingToken.Error(), Code: http.StatusUnauthorized}
+	}
+	identity, err := verifier.Verify(token)
+	if err != nil {
+		util.LOG.Warningf("rejected connector with invalid registration token, remoteAddr: %s, route: %s, err: %s", req.RemoteAddr, route, err.Error())
+		return &util.CrankerErr{Msg: err.Error(), Code: http.StatusUnauthorized}
+	}
+	if !identity.CanRegister(route) {
+		util.LOG.Warningf("rejected connector %s which does not own route %s, remoteAddr: %s", identity.String(), route, req.RemoteAddr)
+		return &util.CrankerErr{Msg: fmt.Sprintf("registration token of %s does not allow route %s", identity.Subject, route), Code: http.StatusForbidden}
+	}
+	util.LOG.Debugf("connector authenticated as %s, remoteAddr: %s, route: %s", identity.String(), req.RemoteAddr, route)
+	return nil
+}
+
 // program shutdown hooks
 var shutDownHooks []func()
 
@@ -65,6 +65,19 @@ func (a *RouterAvailability) Status() map[string]interface{} {
 	status["Services Register Map"] = serviceRegisterMapping
 	status["services"] = "/health/connectors"
 	status["darkMode"] = a.darkLaunchManager
+	if monitor := a.darkLaunchManager.ErrorRateMonitor(); monitor != nil {
+		status["darkLaunchErrorRates"] = monitor.ErrorRates()
+	}
+	status["concurrency"] = a.websocketFarm.ConcurrencyLimits().Status()
+	if breakers := a.websocketFarm.CircuitBreakers(); breakers != nil {
+		status["circuitBreakers"] = breakers.Statuses()
+	}
+	if outliers := a.websocketFarm.OutlierDetector(); outliers != nil {
+		status["latencyOutliers"] = outliers.Statuses()
+	}
+	if slowStart := a.websocketFarm.SlowStart(); slowStart != nil {
+		status["slowStart"] = slowStart.Statuses()
+	}
 	status["isAvailable"] = true
 	return status
 }
@@ -119,41 +132,49 @@ func (a *RouterAvailability) Services() map[string]interface{} {
 	return servicesConnState
 }
 
-func (a *RouterAvailability) scheduleSendPingToConnector() {
-
+// scheduleSendPingToConnector pings the idle sockets every interval, and evicts the ones whose connector
+// has not answered maxMissedPongs pings in a row. 0 never evicts them
+func (a *RouterAvailability) scheduleSendPingToConnector(interval time.Duration, maxMissedPongs int) {
+	util.LOG.Infof("pinging idle sockets every %v, evicting them after %d missed pongs", interval, maxMissedPongs)
 	go func(ctx context.Context) {
-	LOOP:
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
 		for {
 			a.websocketFarm.DarkSockets().Range(func(key, value interface{}) bool {
 				route := key.(string)
 				routerSocketQueue := value.(*router_socket.IterableChan)
-				a.sendPingToConnector(route, routerSocketQueue)
+				a.sendPingToConnector(route, routerSocketQueue, maxMissedPongs)
 				return true
 			})
 			a.websocketFarm.Sockets().Range(func(key, value interface{}) bool {
 				route := key.(string)
 				routerSocketQueue := value.(*router_socket.IterableChan)
-				a.sendPingToConnector(route, routerSocketQueue)
+				a.sendPingToConnector(route, routerSocketQueue, maxMissedPongs)
 				return true
 			})
-			a.sendPingToConnector("default", a.websocketFarm.Catchall())
-			a.sendPingToConnector("default", a.websocketFarm.DarkCatchall())
+			a.sendPingToConnector("default", a.websocketFarm.Catchall(), maxMissedPongs)
+			a.sendPingToConnector("default", a.websocketFarm.DarkCatchall(), maxMissedPongs)
 
 			select {
 			case <-ctx.Done():
-				break LOOP
-			default:
+				return
+			case <-ticker.C:
 			}
-			time.Sleep(5 * time.Second)
 		}
-
 	}(a.cancelCtx)
 }
 
-func (a *RouterAvailability) sendPingToConnector(route string, queue *router_socket.IterableChan) {
+func (a *RouterAvailability) sendPingToConnector(route string, queue *router_socket.IterableChan, maxMissedPongs int) {
 	queue.Range(func(value interface{}) bool {
 		routerSocket := value.(*router_socket.RouterSocket)
-		util.LOG.Debugf("inside router availability: going to send ping, routerName: %s, connectorInstanceID: %S", route, routerSocket.ConnectorInstanceID())
+		if maxMissedPongs > 0 && routerSocket.MissedPongs() >= maxMissedPongs {
+			if a.websocketFarm.EvictIdleSocket(routerSocket) {
+				util.LOG.Warningf("evicted idle socket %s of route %s, its connector %s missed %d pongs since %v",
+					routerSocket.RouterSocketID, route, routerSocket.ConnectorInstanceID(), routerSocket.MissedPongs(), routerSocket.LastPongTime())
+			}
+			return true
+		}
+		util.LOG.Debugf("inside router availability: going to send ping, routerName: %s, connectorInstanceID: %s", route, routerSocket.ConnectorInstanceID())
 		routerSocket.SendPingToConnector()
 		return true
 	})
@@ -189,17 +210,29 @@ func getServicesMap(remoteAddr map[string]struct{}, routerSocket *router_socket.
 }
 
 func addConnectorByIp(routerSocket *router_socket.RouterSocket, curRemoteAddr string, conns []interface{}) map[string]interface{} {
-	return map[string]interface{}{
+	connector := map[string]interface{}{
 		"connectorInstanceID": routerSocket.ConnectorInstanceID(),
 		"ip":                  curRemoteAddr,
 		"route":               routerSocket.Route,
 		"component":           routerSocket.ReqComponentName(),
+		"versionLabel":        routerSocket.VersionLabel(),
 		"connections":         conns,
 	}
+	if breaker := routerSocket.CircuitBreaker(); breaker != nil {
+		connector["circuitBreaker"] = breaker
+	}
+	return connector
 }
 
 func addRouterSocketIdByConnector(routerSocket *router_socket.RouterSocket, lastPingTime string) map[string]interface{} {
-	return map[string]interface{}{"socketID": routerSocket.RouterSocketID, "lastPingTime": lastPingTime}
+	conn := map[string]interface{}{"socketID": routerSocket.RouterSocketID, "lastPingTime": lastPingTime}
+	if lastPong := routerSocket.LastPongTime(); !lastPong.IsZero() {
+		conn["lastPongTime"] = lastPong.String()
+	}
+	if missed := routerSocket.MissedPongs(); missed > 0 {
+		conn["missedPongs"] = missed
+	}
+	return conn
 }
 
 func getRemoteAddrMapping(remoteAddr map[string]int, routerSocket *router_socket.RouterSocket) {
@@ -2,12 +2,18 @@ package router
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"time"
 
+	"github.com/torchcc/crank4go/credential"
+	"github.com/torchcc/crank4go/router/audit"
 	"github.com/torchcc/crank4go/router/darklaunch_manager"
 	"github.com/torchcc/crank4go/router/handler"
 	"github.com/torchcc/crank4go/router/interceptor"
 	"github.com/torchcc/crank4go/router/plugin"
+	"github.com/torchcc/crank4go/router/router_socket"
+	"github.com/torchcc/crank4go/router/shadow_mirror"
+	"github.com/torchcc/crank4go/router/webhook"
 	"github.com/torchcc/crank4go/util"
 )
 
@@ -32,16 +38,75 @@ type RouterConfig struct {
 	isShutDownHookAdded  bool
 	idleTimeout          time.Duration
 	pingScheduleInterval time.Duration
+	maxMissedPongs       int
+	// rolls the dark launch back when dark connectors keep failing
+	darkLaunchErrorRateMonitor *darklaunch_manager.ErrorRateMonitor
+	shadowMirror               *shadow_mirror.ShadowMirror
+	darkLaunchSweepInterval    time.Duration
+	registrationVerifier       credential.Verifier
+	connectorCertPolicy        *credential.CertPolicy
+	connectorClientCAs         *x509.CertPool
+	registrationPolicy         RegistrationPolicy
+	adminAuthenticators        []handler.Authenticator
+	auditSink                  audit.Sink
+	webhookTargets             []*webhook.Target
+	rateLimitFilter            *handler.RateLimitFilter
+	concurrencyLimits          *router_socket.ConcurrencyLimits
+	circuitBreakers            *router_socket.CircuitBreakers
+	outlierDetector            *router_socket.OutlierDetector
+	slowStart                  *router_socket.SlowStart
+	routeTimeouts              *router_socket.RouteTimeouts
+	httpServerSettings         ServerSettings
+	registrationServerSettings ServerSettings
+	trustedProxies             util.IpRanges
+	ipWhiteListFile            string
+	ipWhiteListReloadInterval  time.Duration
 }
 
 func (r *RouterConfig) PingScheduleInterval() time.Duration {
 	return r.pingScheduleInterval
 }
 
+/**
+ * @Description: how often the idle sockets are pinged, 5 seconds by default. 0 to ping them never
+ * @receiver r
+ * @param pingScheduleInterval
+ */
+func (r *RouterConfig) SetPingScheduleInterval(pingScheduleInterval time.Duration) *RouterConfig {
+	r.pingScheduleInterval = pingScheduleInterval
+	return r
+}
+
+func (r *RouterConfig) MaxMissedPongs() int {
+	return r.maxMissedPongs
+}
+
+/**
+ * @Description: an idle socket is closed before any request is routed to it once its connector has not answered this many
+ * pings in a row, e.g. because the tcp connection is half-open. 3 by default, 0 to keep such sockets
+ * @receiver r
+ * @param maxMissedPongs
+ */
+func (r *RouterConfig) SetMaxMissedPongs(maxMissedPongs int) *RouterConfig {
+	r.maxMissedPongs = maxMissedPongs
+	return r
+}
+
 func (r *RouterConfig) IdleTimeout() time.Duration {
 	return r.idleTimeout
 }
 
+/**
+ * @Description: the longest time the router waits between two chunks of a connector's response before answering 504,
+ * it is the default idle timeout of every route. 0 to wait forever
+ * @receiver r
+ * @param idleTimeout
+ */
+func (r *RouterConfig) SetIdleTimeout(idleTimeout time.Duration) *RouterConfig {
+	r.idleTimeout = idleTimeout
+	return r
+}
+
 func (r *RouterConfig) DarkLaunchManager() *darklaunch_manager.DarkLaunchManager {
 	return r.darkLaunchManager
 }
@@ -54,6 +119,38 @@ func (r *RouterConfig) IpValidator() Validator {
 	return r.ipValidator
 }
 
+/**
+ * @Description: validates the addresses connectors register from, an &IpValidator{} allowing any address by default
+ * @receiver r
+ * @param ipValidator e.g. an IpValidator with a white list set by UpdateIpWhiteList
+ */
+func (r *RouterConfig) SetIpValidator(ipValidator Validator) *RouterConfig {
+	r.ipValidator = ipValidator
+	return r
+}
+
+func (r *RouterConfig) IpWhiteListFile() string {
+	return r.ipWhiteListFile
+}
+
+func (r *RouterConfig) IpWhiteListReloadInterval() time.Duration {
+	return r.ipWhiteListReloadInterval
+}
+
+/**
+ * @Description: the file the white list of the IpValidator is loaded from when the router starts, with one ip or CIDR
+ * range per line. it is reloaded whenever it is modified until the router shuts down, a modification which is
+ * not valid keeps the current white list
+ * @receiver r
+ * @param path e.g. /etc/crank4go/connector-ips
+ * @param reloadInterval how often the file is checked for modifications, 5 seconds if it is 0
+ */
+func (r *RouterConfig) SetIpWhiteListFile(path string, reloadInterval time.Duration) *RouterConfig {
+	r.ipWhiteListFile = path
+	r.ipWhiteListReloadInterval = reloadInterval
+	return r
+}
+
 // NewRouterConfig  Mandatory values are set in constructor; optional values are set by setters.
 // @param websocketInterface e.g. 0.0.0.0
 // @param webserverInterface e.g. 0.0.0.0
@@ -81,8 +178,13 @@ func NewRouterConfig2(websocketInterface, webserverInterface string, registratio
 		routerSocketPlugins:       routerSocketPlugins,
 		isShutDownHookAdded:       true,
 		ipValidator:               &IpValidator{},
+		registrationPolicy:        NewDefaultRegistrationPolicy(),
+		pingScheduleInterval:      5 * time.Second,
+		maxMissedPongs:            3,
+		auditSink:                 audit.NewFileSink(audit.DefaultFilePath),
 	}
 
+	config.httpServerSettings, config.registrationServerSettings = DefaultHttpServerSettings(), DefaultRegistrationServerSettings()
 	if config.proxyInterceptors == nil {
 		config.proxyInterceptors = make([]interceptor.ProxyInterceptor, 0, 0)
 	}
@@ -143,6 +245,287 @@ func (r *RouterConfig) ConfigDarkLaunch(darkLaunchPublicKey, darkLaunchServiceNa
 	return r
 }
 
+func (r *RouterConfig) DarkLaunchErrorRateMonitor() *darklaunch_manager.ErrorRateMonitor {
+	return r.darkLaunchErrorRateMonitor
+}
+
+/**
+ * @Description: roll the dark launch back automatically when the error rate of dark connectors crosses the monitor's threshold.
+ * without it nothing watches whether the dark connectors are failing once gray testing is on
+ * @receiver r
+ * @param monitor e.g. darklaunch_manager.NewErrorRateMonitor(0.5, time.Minute, 20, darklaunch_manager.RollbackTurnGrayTestingOff)
+ */
+func (r *RouterConfig) SetDarkLaunchErrorRateMonitor(monitor *darklaunch_manager.ErrorRateMonitor) *RouterConfig {
+	r.darkLaunchErrorRateMonitor = monitor
+	return r
+}
+
+func (r *RouterConfig) DarkLaunchSweepInterval() time.Duration {
+	return r.darkLaunchSweepInterval
+}
+
+/**
+ * @Description: how often expired dark ips and services are revoked, 10 seconds by default
+ * @receiver r
+ * @param interval
+ */
+func (r *RouterConfig) SetDarkLaunchSweepInterval(interval time.Duration) *RouterConfig {
+	r.darkLaunchSweepInterval = interval
+	return r
+}
+
+func (r *RouterConfig) ShadowMirror() *shadow_mirror.ShadowMirror {
+	return r.shadowMirror
+}
+
+/**
+ * @Description: mirror a copy of live requests to dark connectors while gray testing is off. clients only see the normal response,
+ * the mirrored one is compared with it and mismatches are served at /api/shadow-mirror of the registration server.
+ * only GET, HEAD and OPTIONS requests are mirrored unless more methods are allowed by SetMirroredMethods
+ * @receiver r
+ * @param shadowMirror e.g. shadow_mirror.NewShadowMirror(64 * 1024)
+ */
+func (r *RouterConfig) SetShadowMirror(shadowMirror *shadow_mirror.ShadowMirror) *RouterConfig {
+	r.shadowMirror = shadowMirror
+	return r
+}
+
+func (r *RouterConfig) RegistrationVerifier() credential.Verifier {
+	return r.registrationVerifier
+}
+
+/**
+ * @Description: require connectors to present a signed token in the Authorization header on registration and deregistration.
+ * the routes claim of the token decides which routes the connector may register. without it any host reaching the registration port may register any route
+ * @receiver r
+ * @param verifier e.g. credential.NewHmacVerifier(secret) or credential.NewKeyFileVerifier("/etc/crank4go/keys/2024-01.pem")
+ */
+func (r *RouterConfig) SetRegistrationVerifier(verifier credential.Verifier) *RouterConfig {
+	r.registrationVerifier = verifier
+	return r
+}
+
+func (r *RouterConfig) ConnectorCertPolicy() *credential.CertPolicy {
+	return r.connectorCertPolicy
+}
+
+func (r *RouterConfig) ConnectorClientCAs() *x509.CertPool {
+	return r.connectorClientCAs
+}
+
+/**
+ * @Description: enable mutual TLS on the registration server, connectors must present a client certificate signed by clientCAs,
+ * and may only register the routes the policy grants to the certificate's common name or SANs. requires websocketTLSConfig.
+ * other clients of the registration server, e.g. of /api and /health, are not asked for a certificate
+ * @receiver r
+ * @param policy e.g. credential.NewCertPolicy().Allow("orders.connectors.internal", "orders")
+ * @param clientCAs the CAs which issue connector certificates
+ */
+func (r *RouterConfig) SetConnectorCertPolicy(policy *credential.CertPolicy, clientCAs *x509.CertPool) *RouterConfig {
+	r.connectorCertPolicy = policy
+	r.connectorClientCAs = clientCAs
+	return r
+}
+
+func (r *RouterConfig) RegistrationPolicy() RegistrationPolicy {
+	return r.registrationPolicy
+}
+
+/**
+ * @Description: decide which routes may be registered at all, it is evaluated for every socket after authentication.
+ * the default policy accepts everything until it is configured
+ * @receiver r
+ * @param policy e.g. NewDefaultRegistrationPolicy().SetReservedRoutes("api", "health").SetCatchAllBlocked(true).SetMaxSocketsPerInstance(64)
+ */
+func (r *RouterConfig) SetRegistrationPolicy(policy RegistrationPolicy) *RouterConfig {
+	if policy != nil {
+		r.registrationPolicy = policy
+	}
+	return r
+}
+
+func (r *RouterConfig) AdminAuthenticators() []handler.Authenticator {
+	return r.adminAuthenticators
+}
+
+/**
+ * @Description: require authentication for the admin API under /api of the registration server. reading endpoints require
+ * the reader role, the mutating ones the operator role. without authenticators the admin API is open to anyone reaching the port
+ * @receiver r
+ * @param authenticators tried in order, e.g. handler.NewBearerAuthenticator(verifier), basicAuthFile, handler.NewCertRoleAuthenticator()
+ */
+func (r *RouterConfig) SetAdminAuthenticators(authenticators ...handler.Authenticator) *RouterConfig {
+	r.adminAuthenticators = authenticators
+	return r
+}
+
+func (r *RouterConfig) AuditSink() audit.Sink {
+	return r.auditSink
+}
+
+/**
+ * @Description: record who made every mutating admin call, from where, the state before and after it and the result.
+ * the records can be queried at /api/audit of the registration server. they are appended to audit.DefaultFilePath by default
+ * @receiver r
+ * @param auditSink e.g. audit.NewFileSink("/var/log/crank4go/audit.jsonl"), nil to audit nothing
+ */
+func (r *RouterConfig) SetAuditSink(auditSink audit.Sink) *RouterConfig {
+	r.auditSink = auditSink
+	return r
+}
+
+func (r *RouterConfig) WebhookTargets() []*webhook.Target {
+	return r.webhookTargets
+}
+
+/**
+ * @Description: post the events of the websocket farm, e.g. a route losing its last connector or a new connector instance
+ * registering for a route, as HMAC-signed JSON to the targets, retrying with backoff
+ * @receiver r
+ * @param targets e.g. webhook.NewTarget(url).SetSecret(secret).SetEventTypes(router_socket.RouteEmptied, router_socket.InstanceAppeared)
+ */
+func (r *RouterConfig) SetWebhookTargets(targets ...*webhook.Target) *RouterConfig {
+	r.webhookTargets = targets
+	return r
+}
+
+func (r *RouterConfig) RateLimitFilter() *handler.RateLimitFilter {
+	return r.rateLimitFilter
+}
+
+/**
+ * @Description: limit the requests of the httpServer per route, per client ip and per api key with token buckets, requests
+ * over the limit are rejected with 429 before any other request handler. rejections are published to the data publish
+ * handlers of the ConnMonitor and the client ips are found behind the trusted proxies of the router, unless the filter
+ * has its own
+ * @receiver r
+ * @param rateLimitFilter e.g. handler.NewRateLimitFilter().SetRouteLimit(500, 1000).SetKeyLimit("X-Api-Key", 10, 20)
+ */
+func (r *RouterConfig) SetRateLimitFilter(rateLimitFilter *handler.RateLimitFilter) *RouterConfig {
+	r.rateLimitFilter = rateLimitFilter
+	return r
+}
+
+func (r *RouterConfig) ConcurrencyLimits() *router_socket.ConcurrencyLimits {
+	return r.concurrencyLimits
+}
+
+/**
+ * @Description: cap the simultaneous in-flight requests per route and per connector instance, requests over the route
+ * limit wait for the queue timeout and are then rejected with 503. the limits can be changed at /api/concurrency
+ * @receiver r
+ * @param concurrencyLimits e.g. router_socket.NewConcurrencyLimits().SetRouteLimit(200).SetInstanceLimit(50).SetQueueTimeout(500 * time.Millisecond)
+ */
+func (r *RouterConfig) SetConcurrencyLimits(concurrencyLimits *router_socket.ConcurrencyLimits) *RouterConfig {
+	r.concurrencyLimits = concurrencyLimits
+	return r
+}
+
+func (r *RouterConfig) CircuitBreakers() *router_socket.CircuitBreakers {
+	return r.circuitBreakers
+}
+
+/**
+ * @Description: stop handing out the sockets of a connector instance after it failed several requests in a row, e.g. because
+ * its target service is down. after the cooldown probe requests are let through, the instance is used again once they succeed.
+ * the breaker states are shown at /health/connectors
+ * @receiver r
+ * @param circuitBreakers e.g. router_socket.NewCircuitBreakers(5, 30*time.Second).SetHalfOpenProbes(1)
+ */
+func (r *RouterConfig) SetCircuitBreakers(circuitBreakers *router_socket.CircuitBreakers) *RouterConfig {
+	r.circuitBreakers = circuitBreakers
+	return r
+}
+
+func (r *RouterConfig) OutlierDetector() *router_socket.OutlierDetector {
+	return r.outlierDetector
+}
+
+/**
+ * @Description: eject the connector instances whose median latency is well above the median of their route for a while,
+ * the latencies are shown as latencyOutliers at /health
+ * @receiver r
+ * @param outlierDetector e.g. router_socket.NewOutlierDetector().SetMedianFactor(3).SetMaxEjectedFraction(0.2)
+ */
+func (r *RouterConfig) SetOutlierDetector(outlierDetector *router_socket.OutlierDetector) *RouterConfig {
+	r.outlierDetector = outlierDetector
+	return r
+}
+
+func (r *RouterConfig) SlowStart() *router_socket.SlowStart {
+	return r.slowStart
+}
+
+/**
+ * @Description: give a connector instance joining a route only a small share of its requests at first, ramping up to the
+ * full share within the window, so its caches can warm up
+ * @receiver r
+ * @param slowStart e.g. router_socket.NewSlowStart(time.Minute).SetMinFraction(0.1).SetWindowOf("heavy-service", 5*time.Minute)
+ */
+func (r *RouterConfig) SetSlowStart(slowStart *router_socket.SlowStart) *RouterConfig {
+	r.slowStart = slowStart
+	return r
+}
+
+func (r *RouterConfig) RouteTimeouts() *router_socket.RouteTimeouts {
+	return r.routeTimeouts
+}
+
+/**
+ * @Description: answer 504 and evict the socket when a connector is too slow to send the first byte of its response,
+ * pauses too long between two chunks, or takes too long in total
+ * @receiver r
+ * @param routeTimeouts e.g. router_socket.NewRouteTimeouts(router_socket.ResponseTimeouts{FirstByte: 30 * time.Second}).
+ *   SetTimeoutsOf("report-service", router_socket.ResponseTimeouts{Total: 5 * time.Minute})
+ */
+func (r *RouterConfig) SetRouteTimeouts(routeTimeouts *router_socket.RouteTimeouts) *RouterConfig {
+	r.routeTimeouts = routeTimeouts
+	return r
+}
+
+func (r *RouterConfig) HttpServerSettings() ServerSettings {
+	return r.httpServerSettings
+}
+
+/**
+ * @Description: the timeouts and the connection limit of the server facing the clients
+ * @receiver r
+ * @param settings e.g. DefaultHttpServerSettings() with a different MaxConns, a zero ServerSettings enforces nothing
+ */
+func (r *RouterConfig) SetHttpServerSettings(settings ServerSettings) *RouterConfig {
+	r.httpServerSettings = settings
+	return r
+}
+
+func (r *RouterConfig) RegistrationServerSettings() ServerSettings {
+	return r.registrationServerSettings
+}
+
+/**
+ * @Description: the timeouts and the connection limit of the server the connectors register to, which serves the admin api too
+ * @receiver r
+ * @param settings e.g. DefaultRegistrationServerSettings() with a different MaxConns, a zero ServerSettings enforces nothing
+ */
+func (r *RouterConfig) SetRegistrationServerSettings(settings ServerSettings) *RouterConfig {
+	r.registrationServerSettings = settings
+	return r
+}
+
+func (r *RouterConfig) TrustedProxies() util.IpRanges {
+	return r.trustedProxies
+}
+
+/**
+ * @Description: the load balancers and proxies in front of the router, the Forwarded and X-Forwarded-* headers they send
+ * are passed on to the connectors, those of any other client are replaced with what the router sees
+ * @receiver r
+ * @param trustedProxies e.g. util.ParseIpRanges([]string{"10.0.0.0/8", "fd00::/8"})
+ */
+func (r *RouterConfig) SetTrustedProxies(trustedProxies util.IpRanges) *RouterConfig {
+	r.trustedProxies = trustedProxies
+	return r
+}
+
 func (r *RouterConfig) ConnMonitor() *util.ConnectionMonitor {
 	return r.connMonitor
 }
@@ -0,0 +1,215 @@
+package router_socket
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/torchcc/crank4go/util"
+)
+
+type BreakerState int
+
+const (
+	// BreakerClosed the sockets of the connector instance are handed out
+	BreakerClosed BreakerState = iota
+	// BreakerOpen the sockets of the connector instance are skipped until the cooldown elapses
+	BreakerOpen
+	// BreakerHalfOpen a few probe requests are let through, the breaker closes on their success and opens again on their failure
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "halfOpen"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int(s))
+	}
+}
+
+func (s BreakerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// BreakerStatus the circuit breaker of a connector instance
+type BreakerStatus struct {
+	ConnectorInstanceID string       `json:"connectorInstanceID"`
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutiveFailures"`
+	OpenedAt            *time.Time   `json:"openedAt,omitempty"`
+	ProbeAt             *time.Time   `json:"probeAt,omitempty"` // when the open breaker lets probe requests through
+}
+
+// CircuitBreakers tracks the failures of each connector instance, a 502, 503, 504 or a broken websocket is a failure.
+// the breaker of an instance opens after failureThreshold consecutive failures and skips its sockets for the cooldown
+type CircuitBreakers struct {
+	sync.Mutex
+	failureThreshold int
+	cooldown         time.Duration
+	halfOpenProbes   int
+	breakers         map[string]*circuitBreaker
+	onChanged        func(connectorInstanceID string, state BreakerState, reason string)
+	now              func() time.Time
+}
+
+type circuitBreaker struct {
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probes   int // the probe requests in flight while half open
+}
+
+func NewCircuitBreakers(failureThreshold int, cooldown time.Duration) *CircuitBreakers {
+	if failureThreshold <= 0 {
+		failureThreshold = 1
+	}
+	return &CircuitBreakers{
+		failureThreshold: failureThreshold,
+		cooldown:         cooldown,
+		halfOpenProbes:   1,
+		breakers:         make(map[string]*circuitBreaker),
+		now:              time.Now,
+	}
+}
+
+// SetHalfOpenProbes how many probe requests a half open breaker lets through at once, 1 by default
+func (c *CircuitBreakers) SetHalfOpenProbes(halfOpenProbes int) *CircuitBreakers {
+	if halfOpenProbes > 0 {
+		c.halfOpenProbes = halfOpenProbes
+	}
+	return c
+}
+
+// allow tells if a socket of the instance may be handed out, probe is true if the request is a probe of a half open breaker.
+// if it may not, probeAt tells when the open breaker lets probe requests through, it is zero if the probes are in flight
+func (c *CircuitBreakers) allow(connectorInstanceID string) (ok, probe bool, probeAt time.Time) {
+	c.Lock()
+	b, found := c.breakers[connectorInstanceID]
+	if !found || b.state == BreakerClosed {
+		c.Unlock()
+		return true, false, probeAt
+	}
+	halfOpened := false
+	if b.state == BreakerOpen {
+		if probeAt = b.openedAt.Add(c.cooldown); c.now().Before(probeAt) {
+			c.Unlock()
+			return false, false, probeAt
+		}
+		b.state, b.probes, halfOpened = BreakerHalfOpen, 0, true
+		probeAt = time.Time{}
+	}
+	if b.probes < c.halfOpenProbes {
+		b.probes++
+		ok, probe = true, true
+	}
+	c.Unlock()
+	if halfOpened {
+		c.changed(connectorInstanceID, BreakerHalfOpen, "cooldown elapsed")
+	}
+	return ok, probe, probeAt
+}
+
+// cancelProbe is called if a probe socket disconnected before its request ended
+func (c *CircuitBreakers) cancelProbe(connectorInstanceID string) {
+	c.Lock()
+	defer c.Unlock()
+	if b, ok := c.breakers[connectorInstanceID]; ok && b.state == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// record is called once a request served by the instance ended
+func (c *CircuitBreakers) record(connectorInstanceID string, status int, transportFailure, probe bool) {
+	failed := transportFailure || status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+	c.Lock()
+	b, ok := c.breakers[connectorInstanceID]
+	if !ok {
+		if !failed {
+			c.Unlock()
+			return
+		}
+		b = &circuitBreaker{}
+		c.breakers[connectorInstanceID] = b
+	}
+	var changedTo BreakerState
+	var reason string
+	switch b.state {
+	case BreakerClosed:
+		if !failed {
+			delete(c.breakers, connectorInstanceID)
+		} else if b.failures++; b.failures >= c.failureThreshold {
+			b.state, b.openedAt = BreakerOpen, c.now()
+			changedTo, reason = BreakerOpen, fmt.Sprintf("%d consecutive failures, last status %d", b.failures, status)
+		}
+	case BreakerHalfOpen:
+		// only the probes tell if the instance recovered, the requests started before the breaker opened are ignored
+		if !probe {
+			break
+		}
+		b.probes--
+		if failed {
+			b.failures++
+			b.state, b.openedAt = BreakerOpen, c.now()
+			changedTo, reason = BreakerOpen, fmt.Sprintf("probe failed with status %d", status)
+		} else {
+			delete(c.breakers, connectorInstanceID)
+			changedTo, reason = BreakerClosed, "probe succeeded"
+		}
+	}
+	c.Unlock()
+	if reason != "" {
+		c.changed(connectorInstanceID, changedTo, reason)
+	}
+}
+
+func (c *CircuitBreakers) changed(connectorInstanceID string, state BreakerState, reason string) {
+	if state == BreakerOpen {
+		util.LOG.Warningf("circuit breaker of connector instance %s is open for %v, reason: %s", connectorInstanceID, c.cooldown, reason)
+	} else {
+		util.LOG.Infof("circuit breaker of connector instance %s is %s, reason: %s", connectorInstanceID, state, reason)
+	}
+	if c.onChanged != nil {
+		c.onChanged(connectorInstanceID, state, reason)
+	}
+}
+
+// StatusOf the breaker of the instance, a closed breaker without failures if the instance never failed
+func (c *CircuitBreakers) StatusOf(connectorInstanceID string) *BreakerStatus {
+	c.Lock()
+	defer c.Unlock()
+	return c.statusOf(connectorInstanceID, c.breakers[connectorInstanceID])
+}
+
+// Statuses the breakers of the instances which failed lately, in format of map[connectorInstanceID]*BreakerStatus
+func (c *CircuitBreakers) Statuses() map[string]*BreakerStatus {
+	c.Lock()
+	defer c.Unlock()
+	statuses := make(map[string]*BreakerStatus, len(c.breakers))
+	for connectorInstanceID, b := range c.breakers {
+		statuses[connectorInstanceID] = c.statusOf(connectorInstanceID, b)
+	}
+	return statuses
+}
+
+func (c *CircuitBreakers) statusOf(connectorInstanceID string, b *circuitBreaker) *BreakerStatus {
+	status := &BreakerStatus{ConnectorInstanceID: connectorInstanceID, State: BreakerClosed}
+	if b == nil {
+		return status
+	}
+	status.State, status.ConsecutiveFailures = b.state, b.failures
+	if b.state != BreakerClosed {
+		openedAt, probeAt := b.openedAt, b.openedAt.Add(c.cooldown)
+		status.OpenedAt, status.ProbeAt = &openedAt, &probeAt
+	}
+	return status
+}
+
+func (c *CircuitBreakers) String() string {
+	return fmt.Sprintf("CircuitBreakers{failureThreshold=%d, cooldown=%v, halfOpenProbes=%d}", c.failureThreshold, c.cooldown, c.halfOpenProbes)
+}
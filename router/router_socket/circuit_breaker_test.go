@@ -0,0 +1,94 @@
+package router_socket
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/torchcc/crank4go/router/darklaunch_manager"
+	"github.com/torchcc/crank4go/util"
+)
+
+func TestCircuitBreakers(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	breakers := NewCircuitBreakers(2, 10*time.Second)
+	breakers.now = func() time.Time { return now }
+	changes := make([]BreakerState, 0, 4)
+	breakers.onChanged = func(_ string, state BreakerState, _ string) { changes = append(changes, state) }
+
+	tests := []struct {
+		name      string
+		advance   time.Duration
+		record    int // the status of the request ended before the check, 0 for none
+		probe     bool
+		wantOk    bool
+		wantProbe bool
+		wantState BreakerState
+	}{
+		{"a success leaves no trace", 0, http.StatusOK, false, true, false, BreakerClosed},
+		{"one failure", 0, http.StatusBadGateway, false, true, false, BreakerClosed},
+		{"second failure opens", 0, http.StatusGatewayTimeout, false, false, false, BreakerOpen},
+		{"client errors are no failures", time.Second, http.StatusNotFound, false, false, false, BreakerOpen},
+		{"cooldown elapsed lets a probe through", 10 * time.Second, 0, false, true, true, BreakerHalfOpen},
+		{"one probe at a time", 0, 0, false, false, false, BreakerHalfOpen},
+		{"late result of an old request is ignored", 0, http.StatusOK, false, false, false, BreakerHalfOpen},
+		{"failed probe opens again", 0, http.StatusBadGateway, true, false, false, BreakerOpen},
+		{"second cooldown", 10 * time.Second, 0, false, true, true, BreakerHalfOpen},
+		{"successful probe closes", 0, http.StatusOK, true, true, false, BreakerClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+			if tt.record != 0 {
+				breakers.record("a", tt.record, false, tt.probe)
+			}
+			ok, probe, _ := breakers.allow("a")
+			if ok != tt.wantOk || probe != tt.wantProbe {
+				t.Errorf("allow() = %v, %v, want %v, %v", ok, probe, tt.wantOk, tt.wantProbe)
+			}
+			if got := breakers.StatusOf("a").State; got != tt.wantState {
+				t.Errorf("state = %s, want %s", got, tt.wantState)
+			}
+		})
+	}
+	want := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(changes) != len(want) {
+		t.Fatalf("changes = %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("changes = %v, want %v", changes, want)
+		}
+	}
+}
+
+func TestFarmSkipsSocketsOfOpenBreakers(t *testing.T) {
+	farm := NewWebsocketFarm(util.NewConnectionMonitor(nil), darklaunch_manager.NewDarkLaunchManager())
+	farm.SetSocketAcquireTime(100 * time.Millisecond)
+	farm.SetCircuitBreakers(NewCircuitBreakers(1, time.Hour))
+	events := &recordingFarmListener{}
+	farm.AddListener(events)
+	for _, connectorInstanceID := range []string{"bad", "bad", "good"} {
+		farm.AddWebsocket("svc", NewRouterSocket("svc", nil, farm, connectorInstanceID, true, "127.0.0.1", nil))
+	}
+	queue, _ := farm.sockets.Load("svc")
+	waitFor(t, func() bool { return queue.(*IterableChan).LenAlive() == 3 })
+
+	farm.onRequestEnded(NewRouterSocket("svc", nil, farm, "bad", true, "127.0.0.1", nil), http.StatusBadGateway, false)
+	socket, err := farm.AcquireSocket("/svc/a", "test")
+	if err != nil || socket.ConnectorInstanceID() != "good" {
+		t.Fatalf("AcquireSocket() = %v, %v, want the socket of the good instance", socket, err)
+	}
+	if _, err = farm.AcquireSocket("/svc/b", "test"); err == nil {
+		t.Errorf("AcquireSocket() handed out a socket of the instance with the open breaker")
+	}
+	if got := socket.CircuitBreaker(); got.State != BreakerClosed {
+		t.Errorf("CircuitBreaker() of the good instance = %+v", got)
+	}
+	if statuses := farm.CircuitBreakers().Statuses(); len(statuses) != 1 || statuses["bad"].State != BreakerOpen {
+		t.Errorf("Statuses() = %v, want the breaker of the bad instance open", statuses)
+	}
+	if got := events.types(); len(got) == 0 || got[len(got)-1] != CircuitOpened {
+		t.Errorf("events = %v, want %s", got, CircuitOpened)
+	}
+}
@@ -0,0 +1,256 @@
+package router_socket
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/torchcc/crank4go/util"
+)
+
+const defaultConcurrencyQueueTimeout = time.Second
+
+// ConcurrencyLimits caps the simultaneous in-flight requests per route and per connector instance, a limit of 0 means no limit.
+// a request over the route limit waits for the queue timeout before it is rejected, a socket of an instance over its limit
+// is not handed out until the instance finishes a request
+type ConcurrencyLimits struct {
+	sync.Mutex
+	routeLimit       int
+	routeLimits      map[string]int
+	instanceLimit    int
+	instanceLimits   map[string]int
+	queueTimeout     time.Duration
+	routeInflight    map[string]int
+	instanceInflight map[string]int
+	rejected         int64
+	released         chan struct{} // closed and replaced whenever a permit is released
+}
+
+// ConcurrencyStatus the limits and the requests counted against them
+type ConcurrencyStatus struct {
+	RouteLimit       int            `json:"routeLimit"`
+	RouteLimits      map[string]int `json:"routeLimits"`
+	InstanceLimit    int            `json:"instanceLimit"`
+	InstanceLimits   map[string]int `json:"instanceLimits"`
+	QueueTimeoutMs   int64          `json:"queueTimeoutMs"`
+	RouteInflight    map[string]int `json:"routeInflight"`
+	InstanceInflight map[string]int `json:"instanceInflight"`
+	Rejected         int64          `json:"rejected"`
+}
+
+// concurrencyPermit what a socket holds while serving a request, it is released once
+type concurrencyPermit struct {
+	route    string
+	instance string
+	probe    bool // the request probes the half open circuit breaker of the instance
+	released int32
+}
+
+func NewConcurrencyLimits() *ConcurrencyLimits {
+	return &ConcurrencyLimits{
+		routeLimits:      make(map[string]int),
+		instanceLimits:   make(map[string]int),
+		queueTimeout:     defaultConcurrencyQueueTimeout,
+		routeInflight:    make(map[string]int),
+		instanceInflight: make(map[string]int),
+		released:         make(chan struct{}),
+	}
+}
+
+// SetRouteLimit the limit of every route without a limit of its own
+func (c *ConcurrencyLimits) SetRouteLimit(limit int) *ConcurrencyLimits {
+	c.Lock()
+	c.routeLimit = limit
+	c.notifyLocked()
+	c.Unlock()
+	util.LOG.Infof("concurrency limit per route is %d", limit)
+	return c
+}
+
+// SetRouteLimitOf overrides the route limit for the route, "*" is the route of the catchall sockets
+func (c *ConcurrencyLimits) SetRouteLimitOf(route string, limit int) *ConcurrencyLimits {
+	c.Lock()
+	c.routeLimits[route] = limit
+	c.notifyLocked()
+	c.Unlock()
+	util.LOG.Infof("concurrency limit of route %s is %d", route, limit)
+	return c
+}
+
+// RemoveRouteLimitOf the route is limited by the route limit again
+func (c *ConcurrencyLimits) RemoveRouteLimitOf(route string) *ConcurrencyLimits {
+	c.Lock()
+	delete(c.routeLimits, route)
+	c.notifyLocked()
+	c.Unlock()
+	return c
+}
+
+// SetInstanceLimit the limit of every connector instance without a limit of its own
+func (c *ConcurrencyLimits) SetInstanceLimit(limit int) *ConcurrencyLimits {
+	c.Lock()
+	c.instanceLimit = limit
+	c.notifyLocked()
+	c.Unlock()
+	util.LOG.Infof("concurrency limit per connector instance is %d", limit)
+	return c
+}
+
+func (c *ConcurrencyLimits) SetInstanceLimitOf(connectorInstanceID string, limit int) *ConcurrencyLimits {
+	c.Lock()
+	c.instanceLimits[connectorInstanceID] = limit
+	c.notifyLocked()
+	c.Unlock()
+	util.LOG.Infof("concurrency limit of connector instance %s is %d", connectorInstanceID, limit)
+	return c
+}
+
+func (c *ConcurrencyLimits) RemoveInstanceLimitOf(connectorInstanceID string) *ConcurrencyLimits {
+	c.Lock()
+	delete(c.instanceLimits, connectorInstanceID)
+	c.notifyLocked()
+	c.Unlock()
+	return c
+}
+
+// SetQueueTimeout how long a request over the route limit waits for a permit, 0 to reject it at once
+func (c *ConcurrencyLimits) SetQueueTimeout(queueTimeout time.Duration) *ConcurrencyLimits {
+	c.Lock()
+	c.queueTimeout = queueTimeout
+	c.Unlock()
+	return c
+}
+
+func (c *ConcurrencyLimits) Status() *ConcurrencyStatus {
+	c.Lock()
+	defer c.Unlock()
+	return &ConcurrencyStatus{
+		RouteLimit:       c.routeLimit,
+		RouteLimits:      copyCounts(c.routeLimits),
+		InstanceLimit:    c.instanceLimit,
+		InstanceLimits:   copyCounts(c.instanceLimits),
+		QueueTimeoutMs:   c.queueTimeout.Milliseconds(),
+		RouteInflight:    copyCounts(c.routeInflight),
+		InstanceInflight: copyCounts(c.instanceInflight),
+		Rejected:         atomic.LoadInt64(&c.rejected),
+	}
+}
+
+func (c *ConcurrencyLimits) routeLimitOf(route string) int {
+	if limit, ok := c.routeLimits[route]; ok {
+		return limit
+	}
+	return c.routeLimit
+}
+
+func (c *ConcurrencyLimits) instanceLimitOf(connectorInstanceID string) int {
+	if limit, ok := c.instanceLimits[connectorInstanceID]; ok {
+		return limit
+	}
+	return c.instanceLimit
+}
+
+// acquireRoute waits for a permit of the route until the queue timeout, nil is returned if none is released meanwhile
+func (c *ConcurrencyLimits) acquireRoute(route string) *concurrencyPermit {
+	c.Lock()
+	deadline := time.Now().Add(c.queueTimeout)
+	for {
+		if limit := c.routeLimitOf(route); limit <= 0 || c.routeInflight[route] < limit {
+			c.routeInflight[route]++
+			c.Unlock()
+			return &concurrencyPermit{route: route}
+		}
+		released := c.released
+		c.Unlock()
+		if !waitUntil(released, deadline) {
+			atomic.AddInt64(&c.rejected, 1)
+			return nil
+		}
+		c.Lock()
+	}
+}
+
+// acquireInstance adds the connector instance of the socket to the permit, false is returned if the instance is at its limit
+func (c *ConcurrencyLimits) acquireInstance(permit *concurrencyPermit, connectorInstanceID string) bool {
+	c.Lock()
+	defer c.Unlock()
+	if limit := c.instanceLimitOf(connectorInstanceID); limit > 0 && c.instanceInflight[connectorInstanceID] >= limit {
+		return false
+	}
+	c.instanceInflight[connectorInstanceID]++
+	permit.instance = connectorInstanceID
+	return true
+}
+
+// releaseInstance takes the connector instance back from the permit, the socket of the instance is not handed out after all
+func (c *ConcurrencyLimits) releaseInstance(permit *concurrencyPermit) {
+	c.Lock()
+	decrementCount(c.instanceInflight, permit.instance)
+	permit.instance = ""
+	c.notifyLocked()
+	c.Unlock()
+}
+
+func (c *ConcurrencyLimits) release(permit *concurrencyPermit) {
+	if permit == nil || !atomic.CompareAndSwapInt32(&permit.released, 0, 1) {
+		return
+	}
+	c.Lock()
+	decrementCount(c.routeInflight, permit.route)
+	if permit.instance != "" {
+		decrementCount(c.instanceInflight, permit.instance)
+	}
+	c.notifyLocked()
+	c.Unlock()
+}
+
+// releasedChan is closed once a permit is released or a limit changes
+func (c *ConcurrencyLimits) releasedChan() chan struct{} {
+	c.Lock()
+	defer c.Unlock()
+	return c.released
+}
+
+func (c *ConcurrencyLimits) notifyLocked() {
+	close(c.released)
+	c.released = make(chan struct{})
+}
+
+func (c *ConcurrencyLimits) String() string {
+	status := c.Status()
+	return fmt.Sprintf("ConcurrencyLimits{routeLimit=%d, routeLimits=%v, instanceLimit=%d, instanceLimits=%v, queueTimeoutMs=%d}",
+		status.RouteLimit, status.RouteLimits, status.InstanceLimit, status.InstanceLimits, status.QueueTimeoutMs)
+}
+
+// waitUntil false is returned if the deadline passed before ch is closed
+func waitUntil(ch chan struct{}, deadline time.Time) bool {
+	wait := time.Until(deadline)
+	if wait <= 0 {
+		return false
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func decrementCount(counts map[string]int, key string) {
+	if counts[key] <= 1 {
+		delete(counts, key)
+	} else {
+		counts[key]--
+	}
+}
+
+func copyCounts(counts map[string]int) map[string]int {
+	copied := make(map[string]int, len(counts))
+	for k, v := range counts {
+		copied[k] = v
+	}
+	return copied
+}
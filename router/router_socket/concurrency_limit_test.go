@@ -0,0 +1,62 @@
+package router_socket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/torchcc/crank4go/router/darklaunch_manager"
+	"github.com/torchcc/crank4go/util"
+)
+
+func TestConcurrencyLimits(t *testing.T) {
+	farm := NewWebsocketFarm(util.NewConnectionMonitor(nil), darklaunch_manager.NewDarkLaunchManager())
+	farm.SetSocketAcquireTime(100 * time.Millisecond)
+	farm.SetConcurrencyLimits(NewConcurrencyLimits().SetRouteLimit(2).SetInstanceLimit(1).SetQueueTimeout(50 * time.Millisecond))
+	for _, connectorInstanceID := range []string{"a", "a", "b", "b"} {
+		farm.AddWebsocket("svc", NewRouterSocket("svc", nil, farm, connectorInstanceID, true, "127.0.0.1", nil))
+	}
+	queue, _ := farm.sockets.Load("svc")
+	waitFor(t, func() bool { return queue.(*IterableChan).LenAlive() == 4 })
+
+	first, err := farm.AcquireSocket("/svc/1", "test")
+	if err != nil {
+		t.Fatalf("AcquireSocket() error = %v", err)
+	}
+	second, err := farm.AcquireSocket("/svc/2", "test")
+	if err != nil || second.ConnectorInstanceID() == first.ConnectorInstanceID() {
+		t.Fatalf("AcquireSocket() = %v, %v, want a socket of the other instance", second, err)
+	}
+	if _, err = farm.AcquireSocket("/svc/3", "test"); err == nil {
+		t.Fatalf("AcquireSocket() over the route limit succeeded")
+	} else if _, ok := err.(util.OverloadErr); !ok {
+		t.Fatalf("AcquireSocket() error = %v, want an OverloadErr", err)
+	}
+
+	// the queued request gets the permit released by the first one, and a socket of the same instance
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		farm.releasePermit(first)
+	}()
+	third, err := farm.AcquireSocket("/svc/3", "test")
+	if err != nil || third.ConnectorInstanceID() != first.ConnectorInstanceID() {
+		t.Fatalf("AcquireSocket() = %v, %v, want a socket of instance %s", third, err, first.ConnectorInstanceID())
+	}
+
+	farm.ConcurrencyLimits().SetRouteLimitOf("svc", 0)
+	if _, err = farm.AcquireSocket("/svc/4", "test"); err == nil {
+		t.Errorf("AcquireSocket() succeeded although both instances are at their limit")
+	} else if _, ok := err.(util.TimeoutErr); !ok {
+		t.Errorf("AcquireSocket() error = %v, want a TimeoutErr", err)
+	}
+
+	status := farm.ConcurrencyLimits().Status()
+	if status.RouteInflight["svc"] != 2 || status.InstanceInflight["a"] != 1 || status.InstanceInflight["b"] != 1 || status.Rejected != 1 {
+		t.Errorf("Status() = %+v", status)
+	}
+	farm.releasePermit(second)
+	farm.releasePermit(second)
+	farm.releasePermit(third)
+	if status = farm.ConcurrencyLimits().Status(); len(status.RouteInflight) != 0 || len(status.InstanceInflight) != 0 {
+		t.Errorf("Status() after release = %+v, want nothing in flight", status)
+	}
+}
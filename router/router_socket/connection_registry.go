@@ -0,0 +1,104 @@
+package router_socket
+
+import (
+	"sync"
+)
+
+// RegistrationStats the connections currently registered, it is what the registration policy makes decisions on
+type RegistrationStats interface {
+	// SocketsOfInstance the number of connected sockets of the connector instance, both idle and busy
+	SocketsOfInstance(connectorInstanceID string) int
+	// InstancesOfRoute the distinct connector instances which have sockets connected for the route
+	InstancesOfRoute(route string) []string
+}
+
+// ConnectionRegistry tracks the connected sockets of each connector instance and route, from the upgrade till the disconnection.
+// unlike the queues of the websocketFarm, it also counts the sockets which are serving requests
+type ConnectionRegistry struct {
+	lock            sync.Mutex
+	instanceSockets map[string]int            // connectorInstanceID -> sockets
+	routeInstances  map[string]map[string]int // route -> connectorInstanceID -> sockets
+}
+
+func NewConnectionRegistry() *ConnectionRegistry {
+	return &ConnectionRegistry{
+		instanceSockets: make(map[string]int),
+		routeInstances:  make(map[string]map[string]int),
+	}
+}
+
+// Admit registers a socket of the connector instance for the route if admit returns nil, admit is called with the
+// registry locked, so concurrent registrations can not exceed the limits it checks. the returned release must be
+// called once the socket is disconnected
+func (r *ConnectionRegistry) Admit(route, connectorInstanceID string, admit func(stats RegistrationStats) error) (release func(), err error) {
+	route = registryRoute(route)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if admit != nil {
+		if err = admit(r); err != nil {
+			return nil, err
+		}
+	}
+	r.instanceSockets[connectorInstanceID]++
+	instances, ok := r.routeInstances[route]
+	if !ok {
+		instances = make(map[string]int)
+		r.routeInstances[route] = instances
+	}
+	instances[connectorInstanceID]++
+
+	var once sync.Once
+	return func() { once.Do(func() { r.release(route, connectorInstanceID) }) }, nil
+}
+
+func (r *ConnectionRegistry) release(route, connectorInstanceID string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.instanceSockets[connectorInstanceID]--; r.instanceSockets[connectorInstanceID] <= 0 {
+		delete(r.instanceSockets, connectorInstanceID)
+	}
+	if instances, ok := r.routeInstances[route]; ok {
+		if instances[connectorInstanceID]--; instances[connectorInstanceID] <= 0 {
+			delete(instances, connectorInstanceID)
+		}
+		if len(instances) == 0 {
+			delete(r.routeInstances, route)
+		}
+	}
+}
+
+// SocketsOfInstance must be called with the lock held, i.e. from the admit func, or through Snapshot
+func (r *ConnectionRegistry) SocketsOfInstance(connectorInstanceID string) int {
+	return r.instanceSockets[connectorInstanceID]
+}
+
+// InstancesOfRoute must be called with the lock held, i.e. from the admit func, or through Snapshot
+func (r *ConnectionRegistry) InstancesOfRoute(route string) []string {
+	instances := make([]string, 0, 8)
+	for instance := range r.routeInstances[registryRoute(route)] {
+		instances = append(instances, instance)
+	}
+	return instances
+}
+
+// Snapshot returns the connected sockets of each connector instance per route, in format of map[route]map[connectorInstanceID]sockets
+func (r *ConnectionRegistry) Snapshot() map[string]map[string]int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	snapshot := make(map[string]map[string]int)
+	for route, instances := range r.routeInstances {
+		snapshot[route] = make(map[string]int)
+		for instance, sockets := range instances {
+			snapshot[route][instance] = sockets
+		}
+	}
+	return snapshot
+}
+
+// the catch-all route is registered as "*", the same as in the websocketFarm
+func registryRoute(route string) string {
+	if route == "" {
+		return "*"
+	}
+	return route
+}
@@ -0,0 +1,177 @@
+package router_socket
+
+import (
+	"sync"
+	"time"
+)
+
+type FarmEventType string
+
+const (
+	// SocketAdded a socket of a connector is connected
+	SocketAdded FarmEventType = "socket_added"
+	// SocketRemoved a socket of a connector is disconnected
+	SocketRemoved FarmEventType = "socket_removed"
+	// RouteAppeared the first socket of a route is connected
+	RouteAppeared FarmEventType = "route_appeared"
+	// RouteEmptied the last socket of a route is disconnected
+	RouteEmptied FarmEventType = "route_emptied"
+	// InstanceAppeared the first socket of a connector instance for a route is connected
+	InstanceAppeared FarmEventType = "instance_appeared"
+	// InstanceGone the last socket of a connector instance for a route is disconnected
+	InstanceGone FarmEventType = "instance_gone"
+	// DarkMove an idle socket is moved to the dark queues, or back to the normal ones, after the dark launch entries changed
+	DarkMove FarmEventType = "dark_move"
+	// GrayToggle gray testing is turned on or off
+	GrayToggle FarmEventType = "gray_toggle"
+	// DarkLaunchAdded an ip, service, instance or version is put in dark mode
+	DarkLaunchAdded FarmEventType = "dark_launch_added"
+	// DarkLaunchRevoked an ip, service, instance or version is revoked from dark mode
+	DarkLaunchRevoked FarmEventType = "dark_launch_revoked"
+	// CircuitOpened the circuit breaker of a connector instance opened, its sockets are skipped for the cooldown
+	CircuitOpened FarmEventType = "circuit_opened"
+	// CircuitHalfOpened the cooldown of an open circuit breaker elapsed, probe requests are let through
+	CircuitHalfOpened FarmEventType = "circuit_half_opened"
+	// CircuitClosed the probe requests of a connector instance succeeded, its sockets are handed out again
+	CircuitClosed FarmEventType = "circuit_closed"
+	// OutlierEjected a connector instance much slower than the others of its route is ejected for a while
+	OutlierEjected FarmEventType = "outlier_ejected"
+)
+
+// FarmEvent a change of the registrations or of the routing of the websocketFarm, fields which do not apply to the type are empty
+type FarmEvent struct {
+	Type                FarmEventType `json:"type"`
+	Time                time.Time     `json:"time"`
+	Route               string        `json:"route,omitempty"`
+	RouterSocketID      string        `json:"routerSocketID,omitempty"`
+	ConnectorInstanceID string        `json:"connectorInstanceID,omitempty"`
+	Dark                bool          `json:"dark"`             // the socket is dark, or gray testing is on for GrayToggle
+	Reason              string        `json:"reason,omitempty"` // why gray testing was toggled, a circuit breaker changed or an instance was ejected
+	Kind                string        `json:"kind,omitempty"`   // ip, service, instance or version for the dark launch events
+	Value               string        `json:"value,omitempty"`  // the dark launch entry
+	Sockets             int           `json:"sockets"`          // the connected sockets of the route after the event
+}
+
+// FarmListener is notified of the events of the websocketFarm synchronously, it must not block
+type FarmListener interface {
+	AfterFarmEvent(event *FarmEvent)
+}
+
+// farmEvents publishes the events of a websocketFarm, it counts the connected sockets of each route to tell when a route appears or empties
+type farmEvents struct {
+	listeners    *sync.Map // in format of map[FarmListener]struct{}
+	lock         sync.Mutex
+	routeSockets map[string]map[string]int // route -> connectorInstanceID -> connected sockets
+}
+
+func newFarmEvents() *farmEvents {
+	return &farmEvents{listeners: &sync.Map{}, routeSockets: make(map[string]map[string]int)}
+}
+
+func (e *farmEvents) publish(event *FarmEvent) {
+	event.Time = time.Now().UTC()
+	e.listeners.Range(func(listener, _ interface{}) bool {
+		listener.(FarmListener).AfterFarmEvent(event)
+		return true
+	})
+}
+
+func (e *farmEvents) socketConnected(socket *RouterSocket, isDark bool) {
+	route, instance := registryRoute(socket.Route), socket.ConnectorInstanceID()
+	e.lock.Lock()
+	instances, ok := e.routeSockets[route]
+	if !ok {
+		instances = make(map[string]int)
+		e.routeSockets[route] = instances
+	}
+	instances[instance]++
+	isNewInstance, sockets := instances[instance] == 1, socketsOf(instances)
+	e.lock.Unlock()
+	if sockets == 1 {
+		e.publish(&FarmEvent{Type: RouteAppeared, Route: route, Sockets: sockets})
+	}
+	if isNewInstance {
+		e.publish(&FarmEvent{Type: InstanceAppeared, Route: route, ConnectorInstanceID: instance, Dark: isDark, Sockets: sockets})
+	}
+	e.publish(socketEvent(SocketAdded, socket, isDark, sockets))
+}
+
+func (e *farmEvents) socketDisconnected(socket *RouterSocket, isDark bool) {
+	route, instance := registryRoute(socket.Route), socket.ConnectorInstanceID()
+	e.lock.Lock()
+	instances := e.routeSockets[route]
+	instances[instance]--
+	isInstanceGone := instances[instance] <= 0
+	if isInstanceGone {
+		delete(instances, instance)
+	}
+	sockets := socketsOf(instances)
+	if len(instances) == 0 {
+		delete(e.routeSockets, route)
+	}
+	e.lock.Unlock()
+	e.publish(socketEvent(SocketRemoved, socket, isDark, sockets))
+	if isInstanceGone {
+		e.publish(&FarmEvent{Type: InstanceGone, Route: route, ConnectorInstanceID: instance, Dark: isDark, Sockets: sockets})
+	}
+	if sockets <= 0 {
+		e.publish(&FarmEvent{Type: RouteEmptied, Route: route})
+	}
+}
+
+func socketsOf(instances map[string]int) int {
+	sockets := 0
+	for _, n := range instances {
+		sockets += n
+	}
+	return sockets
+}
+
+func (e *farmEvents) socketMoved(socket *RouterSocket, isDark bool) {
+	e.lock.Lock()
+	sockets := socketsOf(e.routeSockets[registryRoute(socket.Route)])
+	e.lock.Unlock()
+	e.publish(socketEvent(DarkMove, socket, isDark, sockets))
+}
+
+func (e *farmEvents) darkLaunchChanged(eventType FarmEventType, kind, value string) {
+	e.publish(&FarmEvent{Type: eventType, Kind: kind, Value: value})
+}
+
+func (e *farmEvents) AfterGrayTestingToggled(isOn bool, req string) {
+	e.publish(&FarmEvent{Type: GrayToggle, Dark: isOn, Reason: req})
+}
+
+func socketEvent(eventType FarmEventType, socket *RouterSocket, isDark bool, sockets int) *FarmEvent {
+	return &FarmEvent{
+		Type:                eventType,
+		Route:               registryRoute(socket.Route),
+		RouterSocketID:      socket.RouterSocketID,
+		ConnectorInstanceID: socket.ConnectorInstanceID(),
+		Dark:                isDark,
+		Sockets:             sockets,
+	}
+}
+
+// AddListener listens to the events of the websocketFarm
+func (f *WebsocketFarm) AddListener(listener FarmListener) {
+	f.events.listeners.Store(listener, struct{}{})
+}
+
+func (f *WebsocketFarm) RemoveListener(listener FarmListener) {
+	f.events.listeners.Delete(listener)
+}
+
+func (e *farmEvents) circuitChanged(connectorInstanceID string, state BreakerState, reason string) {
+	eventType := CircuitClosed
+	if state == BreakerOpen {
+		eventType = CircuitOpened
+	} else if state == BreakerHalfOpen {
+		eventType = CircuitHalfOpened
+	}
+	e.publish(&FarmEvent{Type: eventType, ConnectorInstanceID: connectorInstanceID, Reason: reason})
+}
+
+func (e *farmEvents) outlierEjected(route, connectorInstanceID string, reason string) {
+	e.publish(&FarmEvent{Type: OutlierEjected, Route: route, ConnectorInstanceID: connectorInstanceID, Reason: reason})
+}
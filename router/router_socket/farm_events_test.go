@@ -0,0 +1,72 @@
+package router_socket
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/torchcc/crank4go/router/darklaunch_manager"
+	"github.com/torchcc/crank4go/util"
+)
+
+type recordingFarmListener struct {
+	lock   sync.Mutex
+	events []*FarmEvent
+}
+
+func (l *recordingFarmListener) AfterFarmEvent(event *FarmEvent) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *recordingFarmListener) types() []FarmEventType {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	types := make([]FarmEventType, 0, len(l.events))
+	for _, event := range l.events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestFarmEvents(t *testing.T) {
+	farm := NewWebsocketFarm(util.NewConnectionMonitor(nil), darklaunch_manager.NewDarkLaunchManager())
+	defer farm.Stop()
+	listener := &recordingFarmListener{}
+	farm.AddListener(listener)
+
+	first := NewRouterSocket("svc", nil, farm, "a", true, "127.0.0.1", nil)
+	second := NewRouterSocket("svc", nil, farm, "a", true, "127.0.0.1", nil)
+	farm.trackSocket(first)
+	farm.trackSocket(second)
+	farm.untrackSocket(first)
+	farm.untrackSocket(first)
+	farm.untrackSocket(second)
+	darklaunch_manager.TurnGrayTestingOn("test")
+	darklaunch_manager.TurnGrayTestingOn("test again")
+	darklaunch_manager.TurnGrayTestingOff("test")
+
+	want := []FarmEventType{RouteAppeared, InstanceAppeared, SocketAdded, SocketAdded, SocketRemoved, SocketRemoved,
+		InstanceGone, RouteEmptied, GrayToggle, GrayToggle}
+	got := listener.types()
+	if len(got) != len(want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %d is %s, want %s", i, got[i], want[i])
+		}
+	}
+	if event := listener.events[3]; event.Route != "svc" || event.Sockets != 2 || event.RouterSocketID != second.RouterSocketID {
+		t.Errorf("got %+v, want the second socket of svc added", event)
+	}
+	if !listener.events[8].Dark || listener.events[9].Dark {
+		t.Errorf("gray testing should be turned on and then off")
+	}
+
+	farm.RemoveListener(listener)
+	farm.trackSocket(first)
+	if len(listener.types()) != len(want) {
+		t.Errorf("a removed listener is still notified")
+	}
+}
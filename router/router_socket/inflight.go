@@ -0,0 +1,83 @@
+package router_socket
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/torchcc/crank4go/util"
+)
+
+// InflightRequest a proxied request being served by a socket
+type InflightRequest struct {
+	ID                  string    `json:"id"`
+	Method              string    `json:"method"`
+	Path                string    `json:"path"`
+	Route               string    `json:"route"`
+	ClientAddr          string    `json:"clientAddr"`
+	RouterSocketID      string    `json:"routerSocketID"`
+	ConnectorInstanceID string    `json:"connectorInstanceID"`
+	Dark                bool      `json:"dark"` // true if it is served by a dark socket, e.g. a mirrored request
+	StartTime           time.Time `json:"startTime"`
+	BytesSent           int64     `json:"bytesSent"`     // to the connector
+	BytesReceived       int64     `json:"bytesReceived"` // from the connector
+}
+
+// inflightEntry the socket serving a request, and the request as it was when the socket was given it
+type inflightEntry struct {
+	socket  *RouterSocket
+	request InflightRequest
+}
+
+// inflightStarted is called once a socket is given a request, inflightEnded once the request ended or the socket is disconnected.
+// the request is taken a snapshot of here, the admin api must not read the fields of the socket set by SetResponse
+func (f *WebsocketFarm) inflightStarted(socket *RouterSocket, request InflightRequest) {
+	f.inflight.Store(request.ID, &inflightEntry{socket: socket, request: request})
+}
+
+func (f *WebsocketFarm) inflightEnded(socket *RouterSocket) {
+	if socket.reqID != "" {
+		f.inflight.Delete(socket.reqID)
+	}
+}
+
+// Inflight the proxied requests being served, the longest running first
+func (f *WebsocketFarm) Inflight() []*InflightRequest {
+	requests := make([]*InflightRequest, 0, 16)
+	f.inflight.Range(func(_, value interface{}) bool {
+		entry := value.(*inflightEntry)
+		request := entry.request
+		request.BytesSent, request.BytesReceived = atomic.LoadInt64(&entry.socket.bytesSent), atomic.LoadInt64(&entry.socket.bytesReceived)
+		requests = append(requests, &request)
+		return true
+	})
+	sort.Slice(requests, func(i, j int) bool { return requests[i].StartTime.Before(requests[j].StartTime) })
+	return requests
+}
+
+// CancelInflight aborts the request by closing its socket, the client gets a 502. false is returned if the request is not in flight
+func (f *WebsocketFarm) CancelInflight(id string) bool {
+	value, ok := f.inflight.LoadAndDelete(id)
+	if !ok {
+		return false
+	}
+	socket := value.(*inflightEntry).socket
+	util.LOG.Warningf("cancelling in-flight request %s, socket: %s", id, socket.String())
+	socket.evict()
+	return true
+}
+
+// inflightRequest is called by SetResponse, the byte counts are read when the requests are listed
+func (s *RouterSocket) inflightRequest() InflightRequest {
+	return InflightRequest{
+		ID:                  s.reqID,
+		Method:              s.req.Method,
+		Path:                s.req.URL.Path,
+		Route:               s.Route,
+		ClientAddr:          s.req.RemoteAddr,
+		RouterSocketID:      s.RouterSocketID,
+		ConnectorInstanceID: s.connectorInstanceID,
+		Dark:                s.isDark,
+		StartTime:           s.reqStartTime,
+	}
+}
@@ -0,0 +1,44 @@
+package router_socket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/torchcc/crank4go/router/darklaunch_manager"
+	"github.com/torchcc/crank4go/util"
+)
+
+func TestInflight(t *testing.T) {
+	connMonitor := util.NewConnectionMonitor(nil)
+	farm := NewWebsocketFarm(connMonitor, darklaunch_manager.NewDarkLaunchManager())
+	serve := func(path string) *RouterSocket {
+		socket := NewRouterSocket("svc", connMonitor, farm, "a", true, "127.0.0.1", nil)
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		socket.SetResponse(httptest.NewRecorder(), req, &sync.WaitGroup{})
+		return socket
+	}
+	first, second := serve("/svc/first"), serve("/svc/second")
+
+	requests := farm.Inflight()
+	if len(requests) != 2 || requests[0].ID != first.reqID || requests[0].Path != "/svc/first" || requests[0].Method != http.MethodPost {
+		t.Fatalf("Inflight() = %+v, want both requests, the first one first", requests)
+	}
+
+	// the listed request is a snapshot taken by SetResponse, only the byte counts are read from the socket
+	first.req.URL.Path = "/svc/changed"
+	atomic.AddInt64(&first.bytesReceived, 42)
+	if requests = farm.Inflight(); requests[0].BytesReceived != 42 || requests[0].Path != "/svc/first" {
+		t.Errorf("Inflight() = %+v, want the snapshot with the bytes received so far", requests[0])
+	}
+
+	second.onRequestEnded(http.StatusOK, false)
+	if requests = farm.Inflight(); len(requests) != 1 || requests[0].RouterSocketID != first.RouterSocketID {
+		t.Errorf("Inflight() = %+v, want only the first request after the second ended", requests)
+	}
+	if !farm.CancelInflight(first.reqID) || farm.CancelInflight(first.reqID) || len(farm.Inflight()) != 0 {
+		t.Errorf("the first request should be cancelled only once")
+	}
+}
@@ -0,0 +1,163 @@
+package router_socket
+
+import (
+	"sync"
+	"time"
+
+	"github.com/torchcc/crank4go/util"
+)
+
+// InstanceSockets the sockets of a connector instance, or the sockets affected by draining or evicting it
+type InstanceSockets struct {
+	ConnectorInstanceID string `json:"connectorInstanceID"`
+	Draining            bool   `json:"draining"`
+	IdleSockets         int    `json:"idleSockets"`
+	InFlightSockets     int    `json:"inFlightSockets"` // the sockets serving requests
+}
+
+// trackSocket is called once the websocket of a registered socket is connected, untrackSocket once it is disconnected
+func (f *WebsocketFarm) trackSocket(socket *RouterSocket) {
+	f.connected.Store(socket, struct{}{})
+	f.events.socketConnected(socket, f.isDarkSocket(socket))
+}
+
+func (f *WebsocketFarm) untrackSocket(socket *RouterSocket) {
+	if _, loaded := f.connected.LoadAndDelete(socket); loaded {
+		f.events.socketDisconnected(socket, f.isDarkSocket(socket))
+	}
+}
+
+func (f *WebsocketFarm) IsDraining(connectorInstanceID string) bool {
+	_, ok := f.draining.Load(connectorInstanceID)
+	return ok
+}
+
+// Drain stops handing out the sockets of the connector instance and closes its idle ones, the in-flight requests are
+// left to finish. new registrations of the instance are rejected until Resume is called.
+// the returned InstanceSockets tells the idle sockets closed and the in-flight sockets left
+func (f *WebsocketFarm) Drain(connectorInstanceID string) *InstanceSockets {
+	f.draining.Store(connectorInstanceID, struct{}{})
+	idle := f.removeIdleSockets(connectorInstanceID)
+	for _, socket := range idle {
+		socket.CloseSocketSession()
+	}
+	inFlight := f.inFlightSockets(connectorInstanceID, idle)
+	util.LOG.Infof("connector instance %s is draining, %d idle sockets closed, %d in-flight sockets left", connectorInstanceID, len(idle), len(inFlight))
+	return &InstanceSockets{ConnectorInstanceID: connectorInstanceID, Draining: true, IdleSockets: len(idle), InFlightSockets: len(inFlight)}
+}
+
+// Evict drains the connector instance and closes all its sockets at once, the in-flight requests fail with 502.
+// the returned InstanceSockets tells the idle and in-flight sockets closed
+func (f *WebsocketFarm) Evict(connectorInstanceID string) *InstanceSockets {
+	f.draining.Store(connectorInstanceID, struct{}{})
+	idle := f.removeIdleSockets(connectorInstanceID)
+	inFlight := f.inFlightSockets(connectorInstanceID, idle)
+	for _, socket := range append(idle, inFlight...) {
+		socket.evict()
+	}
+	util.LOG.Infof("connector instance %s is evicted, %d idle and %d in-flight sockets closed", connectorInstanceID, len(idle), len(inFlight))
+	return &InstanceSockets{ConnectorInstanceID: connectorInstanceID, Draining: true, IdleSockets: len(idle), InFlightSockets: len(inFlight)}
+}
+
+// Resume accepts the registrations of a drained connector instance again, it returns false if the instance was not draining
+func (f *WebsocketFarm) Resume(connectorInstanceID string) bool {
+	_, ok := f.draining.LoadAndDelete(connectorInstanceID)
+	if ok {
+		util.LOG.Infof("connector instance %s is resumed", connectorInstanceID)
+	}
+	return ok
+}
+
+// Instances the sockets of every connected or draining connector instance, in format of map[connectorInstanceID]*InstanceSockets
+func (f *WebsocketFarm) Instances() map[string]*InstanceSockets {
+	instances := make(map[string]*InstanceSockets)
+	instanceOf := func(connectorInstanceID string) *InstanceSockets {
+		instance, ok := instances[connectorInstanceID]
+		if !ok {
+			instance = &InstanceSockets{ConnectorInstanceID: connectorInstanceID, Draining: f.IsDraining(connectorInstanceID)}
+			instances[connectorInstanceID] = instance
+		}
+		return instance
+	}
+	idle := make(map[*RouterSocket]struct{})
+	f.rangeQueues(func(queue *IterableChan) {
+		for _, socket := range queue.AliveSocketSlice() {
+			idle[socket] = struct{}{}
+		}
+	})
+	f.connected.Range(func(key, _ interface{}) bool {
+		socket := key.(*RouterSocket)
+		if _, ok := idle[socket]; ok {
+			instanceOf(socket.ConnectorInstanceID()).IdleSockets++
+		} else {
+			instanceOf(socket.ConnectorInstanceID()).InFlightSockets++
+		}
+		return true
+	})
+	f.draining.Range(func(key, _ interface{}) bool {
+		instanceOf(key.(string))
+		return true
+	})
+	return instances
+}
+
+// removeIdleSockets removes the idle sockets of the connector instance from all the queues
+func (f *WebsocketFarm) removeIdleSockets(connectorInstanceID string) []*RouterSocket {
+	removed := make([]*RouterSocket, 0, 8)
+	f.rangeQueues(func(queue *IterableChan) {
+		for _, socket := range queue.AliveSocketSlice() {
+			if socket.ConnectorInstanceID() == connectorInstanceID && queue.Remove(socket) {
+				socket.markRemoved()
+				removed = append(removed, socket)
+			}
+		}
+	})
+	return removed
+}
+
+// inFlightSockets the connected sockets of the connector instance which are not idle, i.e. serving requests
+func (f *WebsocketFarm) inFlightSockets(connectorInstanceID string, idle []*RouterSocket) []*RouterSocket {
+	idleSet := make(map[*RouterSocket]struct{}, len(idle))
+	for _, socket := range idle {
+		idleSet[socket] = struct{}{}
+	}
+	inFlight := make([]*RouterSocket, 0, 8)
+	f.connected.Range(func(key, _ interface{}) bool {
+		socket := key.(*RouterSocket)
+		if _, ok := idleSet[socket]; !ok && socket.ConnectorInstanceID() == connectorInstanceID {
+			inFlight = append(inFlight, socket)
+		}
+		return true
+	})
+	return inFlight
+}
+
+func (f *WebsocketFarm) rangeQueues(action func(queue *IterableChan)) {
+	for _, queues := range []*sync.Map{f.sockets, f.darkSockets} {
+		queues.Range(func(_, queue interface{}) bool {
+			action(queue.(*IterableChan))
+			return true
+		})
+	}
+	action(f.catchall)
+	action(f.darkCatchall)
+}
+
+// pollAvailable polls a socket from the queue, the sockets of draining connector instances polled meanwhile are closed
+func (f *WebsocketFarm) pollAvailable(queue *IterableChan, timeout time.Duration) *RouterSocket {
+	deadline := time.Now().Add(timeout)
+	for {
+		socket := queue.PollTimeout(time.Until(deadline))
+		if socket != nil && socket.removed() {
+			// it was put back by pollWithinLimits right before its connector disconnected
+			util.LOG.Debugf("dropping the removed socket %s", socket.RouterSocketID)
+			continue
+		}
+		if socket == nil || !f.IsDraining(socket.ConnectorInstanceID()) {
+			return socket
+		}
+		util.LOG.Infof("connector instance %s is draining, closing its socket %s instead of handing it out", socket.ConnectorInstanceID(), socket.RouterSocketID)
+		socket.markRemoved()
+		socket.CloseSocketSession()
+	}
+}
@@ -0,0 +1,92 @@
+package router_socket
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/torchcc/crank4go/router/darklaunch_manager"
+	"github.com/torchcc/crank4go/util"
+)
+
+func TestDrainAndEvict(t *testing.T) {
+	farm := NewWebsocketFarm(util.NewConnectionMonitor(nil), darklaunch_manager.NewDarkLaunchManager())
+	farm.SetSocketAcquireTime(100 * time.Millisecond)
+	newSocket := func(connectorInstanceID string, idle bool) *RouterSocket {
+		socket := NewRouterSocket("svc", nil, farm, connectorInstanceID, true, "127.0.0.1", nil)
+		farm.trackSocket(socket)
+		if idle {
+			farm.AddWebsocket("svc", socket)
+		}
+		return socket
+	}
+	newSocket("a", true)
+	newSocket("a", true)
+	newSocket("a", false)
+	b := newSocket("b", true)
+	queue, _ := farm.sockets.Load("svc")
+	waitFor(t, func() bool { return queue.(*IterableChan).LenAlive() == 3 })
+
+	if got := farm.Drain("a"); got.IdleSockets != 2 || got.InFlightSockets != 1 || !got.Draining {
+		t.Errorf("Drain(a) = %+v, want 2 idle sockets closed and 1 in-flight socket left", got)
+	}
+	if socket, err := farm.AcquireSocket("/svc/path", "test"); err != nil || socket != b {
+		t.Errorf("AcquireSocket = %v, %v, want the socket of instance b", socket, err)
+	}
+
+	newSocket("a", true)
+	time.Sleep(20 * time.Millisecond)
+	if queue.(*IterableChan).LenAlive() != 0 {
+		t.Errorf("a socket of the draining instance was added")
+	}
+	if instances := farm.Instances(); instances["a"] == nil || !instances["a"].Draining || instances["b"].InFlightSockets != 1 {
+		t.Errorf("Instances() = %v", instances)
+	}
+
+	if got := farm.Evict("b"); got.IdleSockets != 0 || got.InFlightSockets != 1 {
+		t.Errorf("Evict(b) = %+v, want 1 in-flight socket closed", got)
+	}
+	if !farm.Resume("a") || farm.Resume("a") || farm.IsDraining("a") {
+		t.Errorf("instance a should be resumed only once")
+	}
+}
+
+func TestRemovedSocketsAreNotHandedOut(t *testing.T) {
+	farm := NewWebsocketFarm(util.NewConnectionMonitor(nil), darklaunch_manager.NewDarkLaunchManager())
+	farm.SetSocketAcquireTime(100 * time.Millisecond)
+	farm.SetCircuitBreakers(NewCircuitBreakers(1, time.Hour))
+	farm.onRequestEnded(NewRouterSocket("svc", nil, farm, "bad", true, "127.0.0.1", nil), http.StatusBadGateway, false)
+	socket := NewRouterSocket("svc", nil, farm, "bad", true, "127.0.0.1", nil)
+	farm.AddWebsocket("svc", socket)
+	value, _ := farm.sockets.Load("svc")
+	queue := value.(*IterableChan)
+	waitFor(t, func() bool { return queue.LenAlive() == 1 })
+
+	// the open breaker makes the farm put the socket back, the connector disconnects while it is out of the queue
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		socket.markRemoved()
+		farm.RemoveWebsocket("svc", socket)
+	}()
+	if _, err := farm.AcquireSocket("/svc/a", "test"); err == nil {
+		t.Fatalf("AcquireSocket() handed out the socket of the open breaker")
+	}
+	// a socket put back right before it was removed is dropped once polled
+	queue.Offer(socket)
+	waitFor(t, func() bool { return queue.LenAlive() == 1 })
+	farm.SetCircuitBreakers(nil)
+	if got, err := farm.AcquireSocket("/svc/b", "test"); err == nil {
+		t.Errorf("AcquireSocket() = %s, want the removed socket dropped", got.RouterSocketID)
+	}
+	if queue.LenAlive() != 0 {
+		t.Errorf("%d sockets left in the queue, want the removed socket gone", queue.LenAlive())
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	for deadline := time.Now().Add(time.Second); !condition(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met in time")
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package router_socket
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/torchcc/crank4go/util"
+)
+
+const (
+	latencySamples      = 128
+	maxEjectionMultiple = 10
+)
+
+// OutlierDetector ejects the connector instances whose median latency is well above the median of their route for a while.
+// the latencies of the successful requests within the window are kept per route and instance, the instances are evaluated
+// every interval and at most maxEjectedFraction of the instances of a route are ejected at once
+type OutlierDetector struct {
+	sync.Mutex
+	medianFactor       float64
+	minExcess          time.Duration
+	minSamples         int
+	minInstances       int
+	window             time.Duration
+	interval           time.Duration
+	ejectionTime       time.Duration
+	maxEjectedFraction float64
+	routes             map[string]*routeLatencies
+	onEjected          func(route, connectorInstanceID string, reason string)
+	now                func() time.Time
+}
+
+// OutlierStatus the latency of a connector instance for a route
+type OutlierStatus struct {
+	MedianMs     int64      `json:"medianMs"`
+	Samples      int        `json:"samples"`
+	Ejected      bool       `json:"ejected"`
+	EjectedUntil *time.Time `json:"ejectedUntil,omitempty"`
+	Ejections    int        `json:"ejections"` // the consecutive ejections, each one lasts longer
+}
+
+type routeLatencies struct {
+	instances     map[string]*instanceLatency
+	lastEvaluated time.Time
+}
+
+type instanceLatency struct {
+	samples      [latencySamples]latencySample
+	next         int
+	ejectedUntil time.Time
+	ejections    int
+}
+
+type latencySample struct {
+	at       time.Time
+	duration time.Duration
+}
+
+// NewOutlierDetector ejects instances 3 times slower than their route, by at least 100ms, for 30s at first
+func NewOutlierDetector() *OutlierDetector {
+	return &OutlierDetector{
+		medianFactor:       3,
+		minExcess:          100 * time.Millisecond,
+		minSamples:         20,
+		minInstances:       3,
+		window:             time.Minute,
+		interval:           10 * time.Second,
+		ejectionTime:       30 * time.Second,
+		maxEjectedFraction: 0.2,
+		routes:             make(map[string]*routeLatencies),
+		now:                time.Now,
+	}
+}
+
+// SetMedianFactor an instance is an outlier if its median latency is over factor times the median of its route
+func (d *OutlierDetector) SetMedianFactor(factor float64) *OutlierDetector {
+	d.medianFactor = factor
+	return d
+}
+
+// SetMinExcess an instance is an outlier only if its median latency is above the median of its route by at least minExcess
+func (d *OutlierDetector) SetMinExcess(minExcess time.Duration) *OutlierDetector {
+	d.minExcess = minExcess
+	return d
+}
+
+// SetMinSamples the requests an instance must have served within the window before it is evaluated
+func (d *OutlierDetector) SetMinSamples(minSamples int) *OutlierDetector {
+	d.minSamples = minSamples
+	return d
+}
+
+// SetMinInstances the evaluated instances a route must have before any of them is ejected
+func (d *OutlierDetector) SetMinInstances(minInstances int) *OutlierDetector {
+	d.minInstances = minInstances
+	return d
+}
+
+// SetWindow how long the latency of a request is kept
+func (d *OutlierDetector) SetWindow(window time.Duration) *OutlierDetector {
+	d.window = window
+	return d
+}
+
+// SetInterval how often the instances of a route are evaluated
+func (d *OutlierDetector) SetInterval(interval time.Duration) *OutlierDetector {
+	d.interval = interval
+	return d
+}
+
+// SetEjectionTime how long an instance is ejected the first time, each further consecutive ejection lasts one ejectionTime longer
+func (d *OutlierDetector) SetEjectionTime(ejectionTime time.Duration) *OutlierDetector {
+	d.ejectionTime = ejectionTime
+	return d
+}
+
+// SetMaxEjectedFraction the fraction of the instances of a route which may be ejected at once, at least one is always allowed
+func (d *OutlierDetector) SetMaxEjectedFraction(fraction float64) *OutlierDetector {
+	d.maxEjectedFraction = fraction
+	return d
+}
+
+// record is called once a request served by the instance succeeded
+func (d *OutlierDetector) record(route, connectorInstanceID string, duration time.Duration) {
+	d.Lock()
+	defer d.Unlock()
+	now := d.now()
+	latencies, ok := d.routes[route]
+	if !ok {
+		latencies = &routeLatencies{instances: make(map[string]*instanceLatency), lastEvaluated: now}
+		d.routes[route] = latencies
+	}
+	instance, ok := latencies.instances[connectorInstanceID]
+	if !ok {
+		instance = &instanceLatency{}
+		latencies.instances[connectorInstanceID] = instance
+	}
+	instance.samples[instance.next] = latencySample{at: now, duration: duration}
+	instance.next = (instance.next + 1) % latencySamples
+	if now.Sub(latencies.lastEvaluated) >= d.interval {
+		latencies.lastEvaluated = now
+		d.evaluate(route, latencies, now)
+	}
+}
+
+// ejectedUntil zero if the instance is not ejected from the route
+func (d *OutlierDetector) ejectedUntil(route, connectorInstanceID string) time.Time {
+	d.Lock()
+	defer d.Unlock()
+	if latencies, ok := d.routes[route]; ok {
+		if instance, ok := latencies.instances[connectorInstanceID]; ok && d.now().Before(instance.ejectedUntil) {
+			return instance.ejectedUntil
+		}
+	}
+	return time.Time{}
+}
+
+func (d *OutlierDetector) evaluate(route string, latencies *routeLatencies, now time.Time) {
+	medians := make(map[string]time.Duration)
+	ejected := 0
+	for connectorInstanceID, instance := range latencies.instances {
+		if now.Before(instance.ejectedUntil) {
+			ejected++
+			continue
+		}
+		if median, samples := instance.median(now, d.window); samples >= d.minSamples {
+			medians[connectorInstanceID] = median
+		} else if samples == 0 && now.Sub(instance.ejectedUntil) > d.window {
+			// the instance served nothing within the window since it was back, it is probably gone
+			delete(latencies.instances, connectorInstanceID)
+		}
+	}
+	if len(medians)+ejected < d.minInstances || len(medians) == 0 {
+		return
+	}
+	allMedians := make([]time.Duration, 0, len(medians))
+	for _, median := range medians {
+		allMedians = append(allMedians, median)
+	}
+	routeMedian := medianOf(allMedians)
+	maxEjected := int(float64(len(medians)+ejected) * d.maxEjectedFraction)
+	if maxEjected < 1 {
+		maxEjected = 1
+	}
+	// the slowest instances are ejected first
+	candidates := make([]string, 0, len(medians))
+	for connectorInstanceID := range medians {
+		candidates = append(candidates, connectorInstanceID)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return medians[candidates[i]] > medians[candidates[j]] })
+	for _, connectorInstanceID := range candidates {
+		instance, median := latencies.instances[connectorInstanceID], medians[connectorInstanceID]
+		if float64(median) <= d.medianFactor*float64(routeMedian) || median-routeMedian < d.minExcess {
+			instance.ejections = 0
+			continue
+		}
+		if ejected >= maxEjected {
+			util.LOG.Infof("connector instance %s of route %s is a latency outlier but %d of its instances are ejected already", connectorInstanceID, route, ejected)
+			continue
+		}
+		ejected++
+		if instance.ejections < maxEjectionMultiple {
+			instance.ejections++
+		}
+		instance.ejectedUntil = now.Add(d.ejectionTime * time.Duration(instance.ejections))
+		// the instance is judged by fresh latencies once it is back
+		instance.samples, instance.next = [latencySamples]latencySample{}, 0
+		reason := fmt.Sprintf("median latency %v is over %.1f times the route median %v, ejected until %s", median, d.medianFactor, routeMedian, instance.ejectedUntil.Format(time.RFC3339))
+		util.LOG.Warningf("connector instance %s of route %s is ejected, %s", connectorInstanceID, route, reason)
+		if d.onEjected != nil {
+			d.onEjected(route, connectorInstanceID, reason)
+		}
+	}
+}
+
+// median the median latency within the window and the number of samples it is calculated from
+func (i *instanceLatency) median(now time.Time, window time.Duration) (time.Duration, int) {
+	durations := make([]time.Duration, 0, latencySamples)
+	for _, sample := range i.samples {
+		if !sample.at.IsZero() && now.Sub(sample.at) <= window {
+			durations = append(durations, sample.duration)
+		}
+	}
+	if len(durations) == 0 {
+		return 0, 0
+	}
+	return medianOf(durations), len(durations)
+}
+
+func medianOf(durations []time.Duration) time.Duration {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations[len(durations)/2]
+}
+
+// Statuses the latencies of the instances of every route, in format of map[route]map[connectorInstanceID]*OutlierStatus
+func (d *OutlierDetector) Statuses() map[string]map[string]*OutlierStatus {
+	d.Lock()
+	defer d.Unlock()
+	now := d.now()
+	statuses := make(map[string]map[string]*OutlierStatus, len(d.routes))
+	for route, latencies := range d.routes {
+		instances := make(map[string]*OutlierStatus, len(latencies.instances))
+		for connectorInstanceID, instance := range latencies.instances {
+			median, samples := instance.median(now, d.window)
+			status := &OutlierStatus{MedianMs: median.Milliseconds(), Samples: samples, Ejections: instance.ejections}
+			if now.Before(instance.ejectedUntil) {
+				ejectedUntil := instance.ejectedUntil
+				status.Ejected, status.EjectedUntil = true, &ejectedUntil
+			}
+			instances[connectorInstanceID] = status
+		}
+		statuses[route] = instances
+	}
+	return statuses
+}
+
+func (d *OutlierDetector) String() string {
+	return fmt.Sprintf("OutlierDetector{medianFactor=%.1f, minExcess=%v, minSamples=%d, minInstances=%d, window=%v, interval=%v, ejectionTime=%v, maxEjectedFraction=%.2f}",
+		d.medianFactor, d.minExcess, d.minSamples, d.minInstances, d.window, d.interval, d.ejectionTime, d.maxEjectedFraction)
+}
@@ -0,0 +1,66 @@
+package router_socket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutlierDetector(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	detector := NewOutlierDetector().SetMinSamples(5).SetInterval(time.Second).SetEjectionTime(10 * time.Second)
+	detector.now = func() time.Time { return now }
+	ejected := make([]string, 0, 2)
+	detector.onEjected = func(_, connectorInstanceID string, _ string) { ejected = append(ejected, connectorInstanceID) }
+
+	latencies := map[string]time.Duration{
+		"a": 20 * time.Millisecond, "b": 25 * time.Millisecond, "c": 30 * time.Millisecond,
+		"slow": 900 * time.Millisecond, "slower": 2 * time.Second, "d": 22 * time.Millisecond,
+	}
+	serve := func(instances ...string) {
+		for i := 0; i < 5; i++ {
+			for _, connectorInstanceID := range instances {
+				detector.record("svc", connectorInstanceID, latencies[connectorInstanceID])
+			}
+		}
+		now = now.Add(time.Second)
+		detector.record("svc", instances[0], latencies[instances[0]])
+	}
+
+	serve("a", "b", "c", "d", "slow", "slower")
+	// 6 instances with 0.2 of them ejectable: only the slowest one
+	if len(ejected) != 1 || ejected[0] != "slower" {
+		t.Fatalf("ejected = %v, want only the slowest instance", ejected)
+	}
+	if detector.ejectedUntil("svc", "slower").IsZero() || !detector.ejectedUntil("svc", "slow").IsZero() {
+		t.Errorf("ejectedUntil() of slower and slow = %v, %v", detector.ejectedUntil("svc", "slower"), detector.ejectedUntil("svc", "slow"))
+	}
+	if !detector.ejectedUntil("other", "slower").IsZero() {
+		t.Errorf("the instance is ejected from another route")
+	}
+
+	// the evaluation at the first request keeps slow in as slower is still ejected, slower is ejected again at the next one
+	now = now.Add(9 * time.Second)
+	serve("a", "b", "c", "d", "slow", "slower")
+	if len(ejected) != 2 || ejected[1] != "slower" {
+		t.Fatalf("ejected = %v, want slower ejected again", ejected)
+	}
+	status := detector.Statuses()["svc"]["slower"]
+	if !status.Ejected || status.Ejections != 2 || status.EjectedUntil.Sub(now) != 20*time.Second {
+		t.Errorf("status of slower = %+v, want the second ejection to last 20s", status)
+	}
+	if status = detector.Statuses()["svc"]["a"]; status.Ejected || status.MedianMs != 20 || status.Samples < 5 {
+		t.Errorf("status of a = %+v", status)
+	}
+
+	fast := NewOutlierDetector().SetMinSamples(5).SetInterval(time.Second)
+	fast.now = detector.now
+	for i := 0; i < 5; i++ {
+		fast.record("svc", "a", 20*time.Millisecond)
+		fast.record("svc", "b", 90*time.Millisecond)
+	}
+	now = now.Add(time.Second)
+	fast.record("svc", "a", 20*time.Millisecond)
+	if !fast.ejectedUntil("svc", "b").IsZero() {
+		t.Errorf("an instance was ejected although the route has fewer than the minimum instances")
+	}
+}
@@ -0,0 +1,168 @@
+package router_socket
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/torchcc/crank4go/util"
+)
+
+// ResponseTimeouts how long the router waits for the response of a connector. a zero timeout is not enforced, or takes the
+// default of RouteTimeouts when it is set for a route, a negative one is never enforced
+type ResponseTimeouts struct {
+	FirstByte time.Duration // from sending the request to receiving the response header
+	Idle      time.Duration // between two chunks of the response, or since the request was sent if FirstByte is not set
+	Total     time.Duration // from sending the request to receiving the whole response
+}
+
+func (t ResponseTimeouts) orDefaults(defaults ResponseTimeouts) ResponseTimeouts {
+	if t.FirstByte == 0 {
+		t.FirstByte = defaults.FirstByte
+	}
+	if t.Idle == 0 {
+		t.Idle = defaults.Idle
+	}
+	if t.Total == 0 {
+		t.Total = defaults.Total
+	}
+	return t
+}
+
+func (t ResponseTimeouts) enforced() bool {
+	return t.FirstByte > 0 || t.Idle > 0 || t.Total > 0
+}
+
+func (t ResponseTimeouts) String() string {
+	return fmt.Sprintf("ResponseTimeouts{firstByte=%v, idle=%v, total=%v}", t.FirstByte, t.Idle, t.Total)
+}
+
+// RouteTimeouts the response timeouts of every route, they are enforced if util.UUI51292EnableResponseTimeouts is on
+type RouteTimeouts struct {
+	lock     sync.RWMutex
+	defaults ResponseTimeouts
+	routes   map[string]ResponseTimeouts
+}
+
+func NewRouteTimeouts(defaults ResponseTimeouts) *RouteTimeouts {
+	return &RouteTimeouts{defaults: defaults, routes: make(map[string]ResponseTimeouts)}
+}
+
+// SetTimeoutsOf the timeouts of the route, its zero timeouts take the defaults. "*" is the route of the catchall sockets
+func (t *RouteTimeouts) SetTimeoutsOf(route string, timeouts ResponseTimeouts) *RouteTimeouts {
+	t.lock.Lock()
+	t.routes[route] = timeouts
+	t.lock.Unlock()
+	return t
+}
+
+func (t *RouteTimeouts) SetDefaults(defaults ResponseTimeouts) *RouteTimeouts {
+	t.lock.Lock()
+	t.defaults = defaults
+	t.lock.Unlock()
+	return t
+}
+
+func (t *RouteTimeouts) Defaults() ResponseTimeouts {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.defaults
+}
+
+// Of the timeouts enforced for the route
+func (t *RouteTimeouts) Of(route string) ResponseTimeouts {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if timeouts, ok := t.routes[route]; ok {
+		return timeouts.orDefaults(t.defaults)
+	}
+	return t.defaults
+}
+
+// SetRouteTimeouts the response timeouts are enforced on the sockets acquired afterwards, nil to enforce none
+func (f *WebsocketFarm) SetRouteTimeouts(timeouts *RouteTimeouts) {
+	f.timeouts = timeouts
+	if timeouts != nil {
+		util.LOG.Infof("default response timeouts are %s", timeouts.Defaults())
+	}
+}
+
+func (f *WebsocketFarm) RouteTimeouts() *RouteTimeouts {
+	return f.timeouts
+}
+
+func (f *WebsocketFarm) timeoutsOf(route string) ResponseTimeouts {
+	if f == nil || f.timeouts == nil || !util.UUI51292EnableResponseTimeouts {
+		return ResponseTimeouts{}
+	}
+	return f.timeouts.Of(registryRoute(route))
+}
+
+// watchResponse fails the request with a 504 once one of its timeouts expires, it returns once the response is finished
+func (s *RouterSocket) watchResponse(timeouts ResponseTimeouts, finished chan struct{}) {
+	for {
+		kind, deadline, ok := s.nextDeadline(timeouts)
+		if !ok {
+			// e.g. only the first byte was limited and it has arrived
+			<-finished
+			return
+		}
+		if wait := time.Until(deadline); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-finished:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			// the response may have moved on meanwhile
+			continue
+		}
+		s.onResponseTimeout(kind, timeouts)
+		return
+	}
+}
+
+// nextDeadline the earliest deadline of the response and the kind of its timeout, false if none of its timeouts applies any more
+func (s *RouterSocket) nextDeadline(timeouts ResponseTimeouts) (kind string, deadline time.Time, ok bool) {
+	consider := func(k string, d time.Time) {
+		if !ok || d.Before(deadline) {
+			kind, deadline, ok = k, d, true
+		}
+	}
+	lastResp := atomic.LoadInt64(&s.lastRespTime)
+	if timeouts.Total > 0 {
+		consider("total", s.reqStartTime.Add(timeouts.Total))
+	}
+	if lastResp == 0 && timeouts.FirstByte > 0 {
+		consider("firstByte", s.reqStartTime.Add(timeouts.FirstByte))
+	} else if timeouts.Idle > 0 {
+		last := s.reqStartTime
+		if lastResp != 0 {
+			last = time.Unix(0, lastResp)
+		}
+		consider("idle", last.Add(timeouts.Idle))
+	}
+	return kind, deadline, ok
+}
+
+// onResponseTimeout the client gets a 504 unless the response header was sent already, then the response is cut short.
+// the socket is evicted as the connector may still be sending the response
+func (s *RouterSocket) onResponseTimeout(kind string, timeouts ResponseTimeouts) {
+	util.LOG.Warningf("%s response timeout of %s expired, routerName=%s, routerSocketID=%s, connectorInstanceID=%s, path=%s",
+		kind, timeouts, s.Route, s.RouterSocketID, s.connectorInstanceID, s.req.URL.Path)
+	_ = s.writeResponse(func(w http.ResponseWriter) error {
+		if s.respStatus == 0 {
+			s.respStatus = http.StatusGatewayTimeout
+			w.WriteHeader(http.StatusGatewayTimeout)
+			_, err := w.Write([]byte(fmt.Sprintf("504 Gateway Timeout, %s timeout expired", kind)))
+			return err
+		}
+		return nil
+	})
+	s.onRequestEnded(http.StatusGatewayTimeout, false)
+	s.finishResponse()
+	s.evict()
+}
@@ -0,0 +1,120 @@
+package router_socket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/torchcc/crank4go/router/darklaunch_manager"
+	"github.com/torchcc/crank4go/util"
+)
+
+func TestRouteTimeouts(t *testing.T) {
+	timeouts := NewRouteTimeouts(ResponseTimeouts{FirstByte: time.Second, Idle: 2 * time.Second}).
+		SetTimeoutsOf("slow", ResponseTimeouts{FirstByte: time.Minute, Total: time.Hour}).
+		SetTimeoutsOf("streaming", ResponseTimeouts{Idle: -1})
+	tests := []struct {
+		route string
+		want  ResponseTimeouts
+	}{
+		{"svc", ResponseTimeouts{FirstByte: time.Second, Idle: 2 * time.Second}},
+		{"slow", ResponseTimeouts{FirstByte: time.Minute, Idle: 2 * time.Second, Total: time.Hour}},
+		{"streaming", ResponseTimeouts{FirstByte: time.Second, Idle: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.route, func(t *testing.T) {
+			if got := timeouts.Of(tt.route); got != tt.want {
+				t.Errorf("Of(%s) = %s, want %s", tt.route, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseTimeout(t *testing.T) {
+	farm := NewWebsocketFarm(util.NewConnectionMonitor(nil), darklaunch_manager.NewDarkLaunchManager())
+	farm.SetRouteTimeouts(NewRouteTimeouts(ResponseTimeouts{FirstByte: 50 * time.Millisecond, Idle: 100 * time.Millisecond}).
+		SetTimeoutsOf("streaming", ResponseTimeouts{Idle: -1}))
+	firstByteOnly := NewWebsocketFarm(util.NewConnectionMonitor(nil), darklaunch_manager.NewDarkLaunchManager())
+	firstByteOnly.SetRouteTimeouts(NewRouteTimeouts(ResponseTimeouts{FirstByte: 50 * time.Millisecond}))
+	serveOn := func(farm *WebsocketFarm, route string) (*RouterSocket, *httptest.ResponseRecorder, chan struct{}) {
+		socket := NewRouterSocket(route, util.NewConnectionMonitor(nil), farm, "a", true, "127.0.0.1", nil)
+		recorder, handleDone, done := httptest.NewRecorder(), &sync.WaitGroup{}, make(chan struct{})
+		handleDone.Add(1)
+		socket.SetResponse(recorder, httptest.NewRequest(http.MethodGet, "/svc/report", nil), handleDone)
+		go func() {
+			handleDone.Wait()
+			close(done)
+		}()
+		return socket, recorder, done
+	}
+	serve := func() (*RouterSocket, *httptest.ResponseRecorder, chan struct{}) {
+		return serveOn(farm, "svc")
+	}
+	// the chunks are apart longer than the idle timeout of the defaults, the whole response longer than the first byte timeout
+	stream := func(t *testing.T, socket *RouterSocket, done chan struct{}) {
+		socket.OnWebsocketText("HTTP/1.1 200 OK\nGET /svc/report\n")
+		for i := 0; i < 3; i++ {
+			time.Sleep(120 * time.Millisecond)
+			select {
+			case <-done:
+				t.Fatalf("the streamed response was cut off after %d chunks", i)
+			default:
+			}
+			socket.OnWebsocketBinary([]byte("chunk "))
+		}
+		_ = socket.OnWebsocketClose(1000, "")
+	}
+	awaitDone := func(t *testing.T, done chan struct{}) {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("the handler waiting for the response was not released")
+		}
+	}
+
+	t.Run("first byte", func(t *testing.T) {
+		_, recorder, done := serve()
+		awaitDone(t, done)
+		if recorder.Code != http.StatusGatewayTimeout {
+			t.Errorf("status = %d, want 504", recorder.Code)
+		}
+	})
+	t.Run("idle", func(t *testing.T) {
+		socket, recorder, done := serve()
+		socket.OnWebsocketText("HTTP/1.1 200 OK\nGET /svc/report\n")
+		socket.OnWebsocketBinary([]byte("first chunk"))
+		awaitDone(t, done)
+		socket.OnWebsocketBinary([]byte("late chunk"))
+		if recorder.Code != http.StatusOK || recorder.Body.String() != "first chunk" {
+			t.Errorf("response = %d %q, want the header and the first chunk only", recorder.Code, recorder.Body.String())
+		}
+	})
+	t.Run("finished in time", func(t *testing.T) {
+		socket, recorder, done := serve()
+		socket.OnWebsocketText("HTTP/1.1 201 Created\nGET /svc/report\n")
+		_ = socket.OnWebsocketClose(1000, "")
+		awaitDone(t, done)
+		time.Sleep(150 * time.Millisecond)
+		if recorder.Code != http.StatusCreated {
+			t.Errorf("status = %d, want 201", recorder.Code)
+		}
+	})
+	t.Run("first byte only", func(t *testing.T) {
+		socket, recorder, done := serveOn(firstByteOnly, "svc")
+		stream(t, socket, done)
+		awaitDone(t, done)
+		if recorder.Code != http.StatusOK || recorder.Body.String() != "chunk chunk chunk " {
+			t.Errorf("response = %d %q, want the whole response", recorder.Code, recorder.Body.String())
+		}
+	})
+	t.Run("streaming", func(t *testing.T) {
+		socket, recorder, done := serveOn(farm, "streaming")
+		stream(t, socket, done)
+		awaitDone(t, done)
+		if recorder.Code != http.StatusOK || recorder.Body.String() != "chunk chunk chunk " {
+			t.Errorf("response = %d %q, want the whole response", recorder.Code, recorder.Body.String())
+		}
+	})
+}
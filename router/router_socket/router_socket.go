@@ -1,6 +1,7 @@
 package router_socket
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -25,27 +26,46 @@ type RouterSocket struct {
 	Route                  string
 	RouterSocketID         string
 	connectorInstanceID    string
+	versionLabel           string // the version label the connector sent at registration
 	connMonitor            *util.ConnectionMonitor
 	websocketFarm          *WebsocketFarm
 	isRegister             bool // true if the socket is from a registration to server HTTP request, false if it's a deRegistration of a connector
 	corsHeaderProcessor    *corsheader_processor.CorsHeaderProcessor
 	session                *ws.Conn
+	sessionLock            sync.RWMutex // guards session, the pinger and the admin api use it besides the read loop
 	respWriter             http.ResponseWriter
 	req                    *http.Request
 	handleDone             *sync.WaitGroup
 	onReadyToAct           func()
+	onDisconnected         func()
 	remoteAddr             string
-	isRemoved              bool
+	isRemoved              int32 // set to 1 once the socket is removed from the farm, it is not handed out any more
 	hasResp                bool
-	lastPingTime           time.Time
+	isDark                 bool  // true if the socket was acquired from the dark queues
+	respStatus             int   // the status code sent back to the client
+	reqEnded               int32 // set to 1 once the end of the request has been reported
+	lastPingTime           int64 // unix nano of the last ping to the connector
+	lastPongTime           int64 // unix nano of the last pong from the connector
+	missedPongs            int32 // the pings in a row the connector has not answered
 	reqStartTime           time.Time
 	bytesReceived          int64
 	bytesSent              int64
 	ip                     string
 	reqComponentName       string
+	reqID                  string // identifies the request being served among the in-flight ones
 	routerSocketPlugins    []plugin.RouterSocketPlugin
+	respLock               sync.Mutex    // guards the writes to respWriter, and handleDone
+	respFinished           bool          // nothing is written to respWriter once the handler waiting for the response is released
+	lastRespTime           int64         // unix nano of the last message from the connector
+	finished               chan struct{} // closed once the response is finished, it stops the response timeouts
 }
 
+// errResponseFinished the response was finished, e.g. after a timeout, while the connector was still sending it
+var errResponseFinished = errors.New("the response is finished already")
+
+// errSessionClosed the websocket to the connector was closed while sending to it
+var errSessionClosed = errors.New("the websocket session is closed")
+
 func NewRouterSocket(route string, connMonitor *util.ConnectionMonitor, websocketFarm *WebsocketFarm,
 	connectorInstanceID string, isRegister bool, ip string, corsHeaderProcessor *corsheader_processor.CorsHeaderProcessor) *RouterSocket {
 	return NewRouterSocket2(route, connMonitor, websocketFarm, connectorInstanceID, isRegister, ip, corsHeaderProcessor, nil)
@@ -77,8 +97,8 @@ func NewRouterSocket2(route string, connMonitor *util.ConnectionMonitor, websock
 }
 
 func (s *RouterSocket) String() string {
-	return fmt.Sprintf("RouterSocket{route=%s, routerSocketID=%s, connectorInstanceID=%s, isRegister=%v, ip=%s, requestComponentName=%s}",
-		s.Route, s.RouterSocketID, s.connectorInstanceID, s.isRegister, s.ip, s.reqComponentName)
+	return fmt.Sprintf("RouterSocket{route=%s, routerSocketID=%s, connectorInstanceID=%s, versionLabel=%s, isRegister=%v, ip=%s, requestComponentName=%s}",
+		s.Route, s.RouterSocketID, s.connectorInstanceID, s.versionLabel, s.isRegister, s.ip, s.reqComponentName)
 }
 
 func (s *RouterSocket) IsCatchAll() bool {
@@ -90,14 +110,61 @@ func (s *RouterSocket) RemoteAddr() string {
 	return s.remoteAddr
 }
 
+// LastPingTime the zero time if no ping was sent to the connector yet
 func (s *RouterSocket) LastPingTime() time.Time {
-	return s.lastPingTime
+	if ping := atomic.LoadInt64(&s.lastPingTime); ping != 0 {
+		return time.Unix(0, ping)
+	}
+	return time.Time{}
+}
+
+func (s *RouterSocket) getSession() *ws.Conn {
+	s.sessionLock.RLock()
+	defer s.sessionLock.RUnlock()
+	return s.session
+}
+
+func (s *RouterSocket) setSession(session *ws.Conn) {
+	s.sessionLock.Lock()
+	s.session = session
+	s.sessionLock.Unlock()
+}
+
+// takeSession clears the session and returns it, so only one caller closes it
+func (s *RouterSocket) takeSession() *ws.Conn {
+	s.sessionLock.Lock()
+	defer s.sessionLock.Unlock()
+	session := s.session
+	s.session = nil
+	return session
+}
+
+// LastPongTime the zero time if the connector has not answered any ping yet
+func (s *RouterSocket) LastPongTime() time.Time {
+	if pong := atomic.LoadInt64(&s.lastPongTime); pong != 0 {
+		return time.Unix(0, pong)
+	}
+	return time.Time{}
+}
+
+// MissedPongs the number of pings in a row the connector has not answered
+func (s *RouterSocket) MissedPongs() int {
+	return int(atomic.LoadInt32(&s.missedPongs))
 }
 
 func (s *RouterSocket) ConnectorInstanceID() string {
 	return s.connectorInstanceID
 }
 
+func (s *RouterSocket) VersionLabel() string {
+	return s.versionLabel
+}
+
+// SetVersionLabel must be called before the socket is added to the websocketFarm
+func (s *RouterSocket) SetVersionLabel(versionLabel string) {
+	s.versionLabel = versionLabel
+}
+
 func (s *RouterSocket) ReqComponentName() string {
 	return s.reqComponentName
 }
@@ -106,6 +173,14 @@ func (s *RouterSocket) SetReqComponentName(reqComponentName string) {
 	s.reqComponentName = reqComponentName
 }
 
+// CircuitBreaker the circuit breaker of the connector instance of the socket, nil if circuit breaking is disabled
+func (s *RouterSocket) CircuitBreaker() *BreakerStatus {
+	if s.websocketFarm == nil || s.websocketFarm.breakers == nil {
+		return nil
+	}
+	return s.websocketFarm.breakers.StatusOf(s.connectorInstanceID)
+}
+
 func (s *RouterSocket) Ip() string {
 	return s.ip
 }
@@ -116,67 +191,142 @@ func (s *RouterSocket) SetResponse(respWriter http.ResponseWriter, req *http.Req
 	s.respWriter = respWriter
 	s.handleDone = handleDone
 	s.reqStartTime = time.Now()
+	s.reqID = uuid.New().String()
+	s.websocketFarm.inflightStarted(s, s.inflightRequest())
+	if timeouts := s.websocketFarm.timeoutsOf(s.Route); timeouts.enforced() {
+		s.finished = make(chan struct{})
+		go s.watchResponse(timeouts, s.finished)
+	}
+}
+
+// writeResponse writes to the client response unless it is finished
+func (s *RouterSocket) writeResponse(write func(w http.ResponseWriter) error) error {
+	s.respLock.Lock()
+	defer s.respLock.Unlock()
+	if s.respWriter == nil || s.respFinished {
+		return errResponseFinished
+	}
+	return write(s.respWriter)
+}
+
+// finishResponse releases the handler waiting for the response, the response must not be written afterwards
+func (s *RouterSocket) finishResponse() {
+	s.respLock.Lock()
+	defer s.respLock.Unlock()
+	if s.respWriter != nil && !s.respFinished {
+		s.respFinished = true
+		if s.finished != nil {
+			close(s.finished)
+		}
+	}
+	if s.handleDone != nil {
+		s.handleDone.Done()
+		s.handleDone = nil
+	}
+}
+
+// SetOnDisconnected the action is called once the websocket of a registered socket is disconnected, it must be set before OnWebsocketConnect
+func (s *RouterSocket) SetOnDisconnected(action func()) {
+	s.onDisconnected = action
 }
 
 func (s *RouterSocket) SetOnReadyToAct(action func()) {
 	s.onReadyToAct = action
 }
 
+// SendPingToConnector is called by the pinger goroutine, the socket may be closed by its read loop meanwhile
 func (s *RouterSocket) SendPingToConnector() {
-	if s.session != nil {
-		util.LOG.Debugf("sending ping message... routerName=%s, routerSocketID=%s, lastPingTime=%#v, connectorInstanceID=%s",
-			s.Route, s.RouterSocketID, s.lastPingTime, s.connectorInstanceID)
-		if err := s.session.WriteControl(ws.PingMessage, []byte("*ping*_%"), time.Now().Add(time.Second)); err != nil {
+	if session := s.getSession(); session != nil {
+		if lastPing := atomic.LoadInt64(&s.lastPingTime); lastPing != 0 && atomic.LoadInt64(&s.lastPongTime) < lastPing {
+			atomic.AddInt32(&s.missedPongs, 1)
+		}
+		util.LOG.Debugf("sending ping message... routerName=%s, routerSocketID=%s, lastPingTime=%v, connectorInstanceID=%s",
+			s.Route, s.RouterSocketID, s.LastPingTime(), s.connectorInstanceID)
+		if err := session.WriteControl(ws.PingMessage, []byte("*ping*_%"), time.Now().Add(time.Second)); err != nil {
 			util.LOG.Debugf("failed to send ping, killing bad websocket... routerSocketID: %s, err: %s", s.RouterSocketID, err.Error())
 			s.removeBadWebsocket()
 		}
-		s.lastPingTime = time.Now()
+		atomic.StoreInt64(&s.lastPingTime, time.Now().UnixNano())
 	}
 }
 
+// onPong is called by the goroutine reading the websocket
+func (s *RouterSocket) onPong(string) error {
+	atomic.StoreInt64(&s.lastPongTime, time.Now().UnixNano())
+	atomic.StoreInt32(&s.missedPongs, 0)
+	return nil
+}
+
+func (s *RouterSocket) IsDark() bool {
+	return s.isDark
+}
+
+// onRequestEnded reports the end of the proxied request only once, no matter the websocket closed normally or broke
+func (s *RouterSocket) onRequestEnded(status int, transportFailure bool) {
+	if s.respWriter == nil || !atomic.CompareAndSwapInt32(&s.reqEnded, 0, 1) {
+		return
+	}
+	s.connMonitor.OnConnectionEnded3(s.RouterSocketID, s.Route, s.reqComponentName, status,
+		time.Now().Sub(s.reqStartTime).Milliseconds(), s.bytesSent, s.bytesReceived)
+	s.websocketFarm.inflightEnded(s)
+	s.websocketFarm.onRequestEnded(s, status, transportFailure)
+}
+
 // OnWebsocketClose A Close Event was received. The underlying Connection will be considered closed at this point.
 func (s *RouterSocket) OnWebsocketClose(statusCode int, reason string) error {
 	util.LOG.Debugf("router side got closeMessage, statusCode=%d, reason=%s, routerName=%s, routerSocketID=%s",
 		statusCode, reason, s.Route, s.RouterSocketID)
-	s.session = nil
+	s.setSession(nil)
 	// status code: https://tools.ietf.org/html/rfc6455#section-7.4.1
 	if s.respWriter != nil {
-		s.connMonitor.OnConnectionEnded3(s.RouterSocketID, s.Route, s.reqComponentName, 200,
-			time.Now().Sub(s.reqStartTime).Milliseconds(), s.bytesSent, s.bytesReceived)
-		if statusCode == ws.CloseInternalServerErr {
-			s.respWriter.WriteHeader(http.StatusBadGateway)
-			util.LOG.Debugf("client response is %#v, routerName=%s, routerSocketID=%s", s.respWriter, s.Route, s.RouterSocketID)
-		} else if statusCode == ws.ClosePolicyViolation {
-			s.respWriter.WriteHeader(http.StatusBadRequest)
-			util.LOG.Debugf("client response is %#v, routerName=%s, routerSocketID=%s", s.respWriter, s.Route, s.RouterSocketID)
+		status := s.respStatus
+		if status == 0 {
+			status = http.StatusOK
 		}
+		if statusCode == ws.CloseInternalServerErr || statusCode == ws.ClosePolicyViolation {
+			status = http.StatusBadGateway
+			if statusCode == ws.ClosePolicyViolation {
+				status = http.StatusBadRequest
+			}
+			_ = s.writeResponse(func(w http.ResponseWriter) error {
+				w.WriteHeader(status)
+				util.LOG.Debugf("client response is %#v, routerName=%s, routerSocketID=%s", w, s.Route, s.RouterSocketID)
+				return nil
+			})
+		}
+		s.onRequestEnded(status, false)
 	}
-	if s.handleDone != nil {
-		s.handleDone.Done()
-		s.handleDone = nil
-	}
-	if s.isRegister && !s.isRemoved {
+	s.finishResponse()
+	if s.isRegister && s.markRemoved() {
 		util.LOG.Debugf("going to remove socket, statusCode=%d, reason=%s, routerName=%s, routerSocketID=%s",
 			statusCode, reason, s.Route, s.RouterSocketID)
 		s.websocketFarm.RemoveWebsocket(s.Route, s)
-		s.isRemoved = true
 	}
 	return nil
 }
 
 func (s *RouterSocket) OnWebsocketConnect(session *ws.Conn) {
-	s.session = session
+	s.setSession(session)
 	s.remoteAddr = session.RemoteAddr().String()
+	session.SetPongHandler(s.onPong)
 	if s.isRegister {
+		s.websocketFarm.trackSocket(s)
+		defer s.websocketFarm.untrackSocket(s)
 		s.onReadyToAct()
 	}
-	s.runForever(s.session)
+	s.runForever(session)
+	s.websocketFarm.inflightEnded(s)
+	s.websocketFarm.releasePermit(s)
+	if s.onDisconnected != nil {
+		s.onDisconnected()
+	}
 }
 
 func (s *RouterSocket) OnWebsocketText(msg string) {
 	util.LOG.Debugf("cranker router socket received response from service connector onWebsocketText=%s", msg)
 	if s.respWriter != nil {
 		atomic.AddInt64(&s.bytesReceived, int64(len(msg)))
+		atomic.StoreInt64(&s.lastRespTime, time.Now().UnixNano())
 		ptcResp := ptc.NewCrankerProtocolResponse(msg)
 		if ptc.IsDebugResp(ptcResp) {
 			util.LOG.Infof("onWebsocketText: cranker router receive response from service connector,"+
@@ -190,8 +340,12 @@ func (s *RouterSocket) OnWebsocketText(msg string) {
 				util.LOG.Errorf("failed to apply the plugin [%#v] on response %s, err: %s", p, ptcResp.ToProtocolMessage(), err.Error())
 			}
 		}
-		s.putHeadersTo(ptcResp)
-		s.respWriter.WriteHeader(ptcResp.Status())
+		_ = s.writeResponse(func(w http.ResponseWriter) error {
+			s.putHeadersTo(ptcResp)
+			s.respStatus = ptcResp.Status()
+			w.WriteHeader(ptcResp.Status())
+			return nil
+		})
 		// s.corsHeaderProcessor.Process(s.req, s.respWriter)
 	}
 }
@@ -199,17 +353,21 @@ func (s *RouterSocket) OnWebsocketText(msg string) {
 // OnWebsocketBinary please make sure the there is available data in buf before calling this method
 func (s *RouterSocket) OnWebsocketBinary(buf []byte) {
 	atomic.AddInt64(&s.bytesReceived, int64(len(buf)))
+	atomic.StoreInt64(&s.lastRespTime, time.Now().UnixNano())
 	util.LOG.Debugf("router with routerName: %s, routerSocketID: %s is sending %d bytes to connector",
 		s.Route, s.RouterSocketID, len(buf))
 
-	if _, err := s.respWriter.Write(buf); err != nil {
+	err := s.writeResponse(func(w http.ResponseWriter) error {
+		_, err := w.Write(buf)
+		return err
+	})
+	if err == errResponseFinished {
+		util.LOG.Debugf("dropping %d bytes of the finished response, routerSocketID: %s", len(buf), s.RouterSocketID)
+	} else if err != nil {
 		util.LOG.Errorf("router with routerName: %s, routerSocketID: %s cannot write to client response writer "+
 			"(maybe the user closed their browser) so the request is cancelling. err: %s", s.Route, s.RouterSocketID, err.Error())
-		if s.handleDone != nil {
-			util.LOG.Debugf("going to done, socketID: %s", s.RouterSocketID)
-			s.handleDone.Done() // just in case router side failed to get websocket closeMessage.
-			s.handleDone = nil
-		}
+		util.LOG.Debugf("going to done, socketID: %s", s.RouterSocketID)
+		s.finishResponse() // just in case router side failed to get websocket closeMessage.
 		s.CloseSocketSession()
 	}
 }
@@ -225,54 +383,73 @@ func (s *RouterSocket) OnWebsocketError(cause error) {
 
 func (s *RouterSocket) SendText(msg string) error {
 	atomic.AddInt64(&s.bytesSent, int64(len(msg)))
-	return s.session.WriteMessage(ws.TextMessage, []byte(msg))
+	session := s.getSession()
+	if session == nil {
+		return errSessionClosed
+	}
+	return session.WriteMessage(ws.TextMessage, []byte(msg))
 }
 
 func (s *RouterSocket) SendData(buf []byte) error {
 	atomic.AddInt64(&s.bytesSent, int64(len(buf)))
-	return s.session.WriteMessage(ws.BinaryMessage, buf)
+	session := s.getSession()
+	if session == nil {
+		return errSessionClosed
+	}
+	return session.WriteMessage(ws.BinaryMessage, buf)
 }
 
 // OnSendOrReceiveDataError this will be called when reverseProxy failed too send textMessage or binaryMessage to connector
 func (s *RouterSocket) OnSendOrReceiveDataError(err error) {
 	s.removeBadWebsocket()
 	errMsg := err.Error()
+	status := http.StatusBadGateway
 	if strings.Contains(errMsg, "timeout") {
 		util.LOG.Warning("hit timeout err when sending data from router to connector, err: %s", err)
-		if s.respWriter != nil {
-			s.respWriter.WriteHeader(http.StatusGatewayTimeout)
-			if _, e := s.respWriter.Write([]byte(fmt.Sprintf("504 Gateway Timeout, err: %s", err.Error()))); e != nil {
-				util.LOG.Error("failed to write response from router reverseProxy to client, err: %s", err.Error())
-			}
-		}
-	} else {
-		if s.respWriter != nil {
-			s.respWriter.WriteHeader(http.StatusBadGateway)
-			if _, e := s.respWriter.Write([]byte(fmt.Sprintf("502 Bad Gateway, err: %s", err.Error()))); e != nil {
-				util.LOG.Error("failed to write response from router reverseProxy to client, err: %s", err.Error())
-			}
-		}
+		status = http.StatusGatewayTimeout
 	}
-	if s.handleDone != nil {
-		util.LOG.Debugf("OnSendOrReceiveDataError: going to done, socketID: %s", s.RouterSocketID)
-		s.handleDone.Done()
-		s.handleDone = nil
+	if s.respWriter != nil {
+		if e := s.writeResponse(func(w http.ResponseWriter) error {
+			w.WriteHeader(status)
+			_, e := w.Write([]byte(fmt.Sprintf("%d %s, err: %s", status, http.StatusText(status), err.Error())))
+			return e
+		}); e != nil && e != errResponseFinished {
+			util.LOG.Error("failed to write response from router reverseProxy to client, err: %s", e.Error())
+		}
+		s.onRequestEnded(status, true)
 	}
+	util.LOG.Debugf("OnSendOrReceiveDataError: going to done, socketID: %s", s.RouterSocketID)
+	s.finishResponse()
 }
 
 func (s *RouterSocket) removeBadWebsocket() {
-	if !s.isRemoved {
+	if s.markRemoved() {
 		s.CloseSocketSession()
 		s.websocketFarm.RemoveWebsocket(s.Route, s)
-		s.isRemoved = true
 	}
 }
 
+// markRemoved marks the socket removed before it is taken out of the farm, false if it was marked already
+func (s *RouterSocket) markRemoved() bool {
+	return atomic.CompareAndSwapInt32(&s.isRemoved, 0, 1)
+}
+
+func (s *RouterSocket) removed() bool {
+	return atomic.LoadInt32(&s.isRemoved) == 1
+}
+
 func (s *RouterSocket) CloseSocketSession() {
 	util.LOG.Debugf("closing socketSession %s ...", s.String())
-	if s.session != nil {
-		_ = s.session.WriteControl(ws.CloseGoingAway, []byte("Going away"), time.Now().Add(time.Second))
-		s.session = nil
+	if session := s.takeSession(); session != nil {
+		_ = session.WriteControl(ws.CloseGoingAway, []byte("Going away"), time.Now().Add(time.Second))
+	}
+}
+
+// evict closes the websocket at once, the read loop then fails and a request being served gets a 502
+func (s *RouterSocket) evict() {
+	if session := s.getSession(); session != nil {
+		_ = session.WriteControl(ws.CloseGoingAway, []byte("Evicted"), time.Now().Add(time.Second))
+		_ = session.Close()
 	}
 }
 
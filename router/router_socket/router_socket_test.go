@@ -0,0 +1,93 @@
+package router_socket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/torchcc/crank4go/router/darklaunch_manager"
+	"github.com/torchcc/crank4go/util"
+)
+
+func TestMissedPongs(t *testing.T) {
+	farm := NewWebsocketFarm(util.NewConnectionMonitor(nil), darklaunch_manager.NewDarkLaunchManager())
+	sockets := make(chan *RouterSocket, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&ws.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		socket := NewRouterSocket("svc", util.NewConnectionMonitor(nil), farm, "a", true, "127.0.0.1", nil)
+		socket.SetOnReadyToAct(func() {
+			farm.AddWebsocket("svc", socket)
+			sockets <- socket
+		})
+		socket.OnWebsocketConnect(conn)
+	}))
+	defer server.Close()
+	connect := func(t *testing.T, answering bool) *RouterSocket {
+		conn, _, err := ws.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		if err != nil {
+			t.Fatalf("failed to connect, err: %s", err.Error())
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		if answering {
+			// the default ping handler of the reading connector answers with a pong
+			go func() {
+				for {
+					if _, _, err := conn.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}()
+		}
+		return <-sockets
+	}
+	pingTwice := func(socket *RouterSocket) {
+		for i := 0; i < 2; i++ {
+			socket.SendPingToConnector()
+			time.Sleep(50 * time.Millisecond)
+		}
+		socket.SendPingToConnector()
+	}
+
+	t.Run("answered", func(t *testing.T) {
+		socket := connect(t, true)
+		pingTwice(socket)
+		if socket.MissedPongs() != 0 || socket.LastPongTime().IsZero() {
+			t.Errorf("missed pongs = %d, last pong = %v, want the pongs recorded", socket.MissedPongs(), socket.LastPongTime())
+		}
+		if farm.EvictIdleSocket(socket); farm.EvictIdleSocket(socket) {
+			t.Errorf("an evicted socket must not be evicted again")
+		}
+	})
+	t.Run("unanswered", func(t *testing.T) {
+		socket := connect(t, false)
+		pingTwice(socket)
+		if socket.MissedPongs() != 2 || !socket.LastPongTime().IsZero() {
+			t.Errorf("missed pongs = %d, last pong = %v, want 2 missed", socket.MissedPongs(), socket.LastPongTime())
+		}
+		if !farm.EvictIdleSocket(socket) || len(farm.AllSockets()["svc"]) != 0 {
+			t.Errorf("the idle socket should be evicted")
+		}
+	})
+	t.Run("closed while pinged", func(t *testing.T) {
+		socket := connect(t, true)
+		pinged := make(chan struct{})
+		go func() {
+			defer close(pinged)
+			for i := 0; i < 100; i++ {
+				socket.SendPingToConnector()
+				_ = socket.LastPingTime()
+			}
+		}()
+		socket.CloseSocketSession()
+		<-pinged
+		if err := socket.SendText("late"); err != errSessionClosed {
+			t.Errorf("SendText() on a closed session = %v, want %v", err, errSessionClosed)
+		}
+	})
+}
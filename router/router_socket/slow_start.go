@@ -0,0 +1,144 @@
+package router_socket
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/torchcc/crank4go/util"
+)
+
+// SlowStart ramps the share of the requests a newly registered connector instance gets up from minFraction to the full share
+// within the window of its route. it only applies to instances joining a route which has other instances already, the sockets
+// of a warming instance are still handed out when no other socket is available
+type SlowStart struct {
+	sync.Mutex
+	window      time.Duration
+	windows     map[string]time.Duration
+	minFraction float64
+	started     map[string]map[string]time.Time // route -> connectorInstanceID -> when its first socket connected
+	now         func() time.Time
+	random      func() float64
+}
+
+func NewSlowStart(window time.Duration) *SlowStart {
+	return &SlowStart{
+		window:      window,
+		windows:     make(map[string]time.Duration),
+		minFraction: 0.1,
+		started:     make(map[string]map[string]time.Time),
+		now:         time.Now,
+		random:      rand.Float64,
+	}
+}
+
+// SetWindowOf overrides the window for the route, 0 to disable slow start for it. "*" is the route of the catchall sockets
+func (s *SlowStart) SetWindowOf(route string, window time.Duration) *SlowStart {
+	s.Lock()
+	s.windows[route] = window
+	s.Unlock()
+	return s
+}
+
+// SetMinFraction the share of its requests an instance gets when it just registered, 0.1 by default
+func (s *SlowStart) SetMinFraction(minFraction float64) *SlowStart {
+	s.minFraction = minFraction
+	return s
+}
+
+// AfterFarmEvent starts the window of an instance when its first socket for a route connects
+func (s *SlowStart) AfterFarmEvent(event *FarmEvent) {
+	switch event.Type {
+	case InstanceAppeared:
+		// the socket of the new instance is counted already, the route had other instances if there are more
+		if event.Sockets <= 1 || s.windowOf(event.Route) <= 0 {
+			return
+		}
+		s.Lock()
+		instances, ok := s.started[event.Route]
+		if !ok {
+			instances = make(map[string]time.Time)
+			s.started[event.Route] = instances
+		}
+		instances[event.ConnectorInstanceID] = s.now()
+		s.Unlock()
+		util.LOG.Infof("connector instance %s joined route %s, slow start for %v", event.ConnectorInstanceID, event.Route, s.windowOf(event.Route))
+	case InstanceGone:
+		s.Lock()
+		if instances, ok := s.started[event.Route]; ok {
+			delete(instances, event.ConnectorInstanceID)
+			if len(instances) == 0 {
+				delete(s.started, event.Route)
+			}
+		}
+		s.Unlock()
+	}
+}
+
+func (s *SlowStart) windowOf(route string) time.Duration {
+	s.Lock()
+	defer s.Unlock()
+	if window, ok := s.windows[route]; ok {
+		return window
+	}
+	return s.window
+}
+
+// fraction the share of its requests the instance gets now, 1 once its window elapsed
+func (s *SlowStart) fraction(route, connectorInstanceID string) float64 {
+	window := s.windowOf(route)
+	s.Lock()
+	defer s.Unlock()
+	instances, ok := s.started[route]
+	if !ok {
+		return 1
+	}
+	started, ok := instances[connectorInstanceID]
+	if !ok {
+		return 1
+	}
+	elapsed := s.now().Sub(started)
+	if window <= 0 || elapsed >= window {
+		delete(instances, connectorInstanceID)
+		if len(instances) == 0 {
+			delete(s.started, route)
+		}
+		return 1
+	}
+	return s.minFraction + (1-s.minFraction)*float64(elapsed)/float64(window)
+}
+
+// admit tells if a socket of the instance is picked this time, the chance is its current fraction
+func (s *SlowStart) admit(route, connectorInstanceID string) bool {
+	fraction := s.fraction(route, connectorInstanceID)
+	return fraction >= 1 || s.random() < fraction
+}
+
+// Statuses the current share of the warming instances, in format of map[route]map[connectorInstanceID]fraction
+func (s *SlowStart) Statuses() map[string]map[string]float64 {
+	s.Lock()
+	routes := make(map[string][]string, len(s.started))
+	for route, instances := range s.started {
+		for connectorInstanceID := range instances {
+			routes[route] = append(routes[route], connectorInstanceID)
+		}
+	}
+	s.Unlock()
+	statuses := make(map[string]map[string]float64, len(routes))
+	for route, connectorInstanceIDs := range routes {
+		for _, connectorInstanceID := range connectorInstanceIDs {
+			if fraction := s.fraction(route, connectorInstanceID); fraction < 1 {
+				if _, ok := statuses[route]; !ok {
+					statuses[route] = make(map[string]float64)
+				}
+				statuses[route][connectorInstanceID] = fraction
+			}
+		}
+	}
+	return statuses
+}
+
+func (s *SlowStart) String() string {
+	return fmt.Sprintf("SlowStart{window=%v, windows=%v, minFraction=%.2f}", s.window, s.windows, s.minFraction)
+}
@@ -0,0 +1,58 @@
+package router_socket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/torchcc/crank4go/router/darklaunch_manager"
+	"github.com/torchcc/crank4go/util"
+)
+
+func TestSlowStart(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	slowStart := NewSlowStart(10*time.Second).SetWindowOf("other", 0)
+	slowStart.now = func() time.Time { return now }
+	slowStart.random = func() float64 { return 0.5 }
+	farm := NewWebsocketFarm(util.NewConnectionMonitor(nil), darklaunch_manager.NewDarkLaunchManager())
+	defer farm.Stop()
+	farm.SetSocketAcquireTime(100 * time.Millisecond)
+	farm.SetSlowStart(slowStart)
+	newSocket := func(route, connectorInstanceID string) *RouterSocket {
+		socket := NewRouterSocket(route, nil, farm, connectorInstanceID, true, "127.0.0.1", nil)
+		farm.trackSocket(socket)
+		farm.AddWebsocket(route, socket)
+		return socket
+	}
+	newSocket("svc", "old")
+	newSocket("svc", "new")
+	newSocket("svc", "old")
+	newSocket("other", "old")
+	newSocket("other", "new")
+	queue, _ := farm.sockets.Load("svc")
+	waitFor(t, func() bool { return queue.(*IterableChan).LenAlive() == 3 })
+
+	if got := slowStart.Statuses(); len(got) != 1 || got["svc"]["new"] != 0.1 {
+		t.Fatalf("Statuses() = %v, want only the new instance of svc at 0.1", got)
+	}
+	for i, want := range []string{"old", "old", "new"} {
+		// the warming socket is passed over while there is another one
+		if socket, err := farm.AcquireSocket("/svc/a", "test"); err != nil || socket.ConnectorInstanceID() != want {
+			t.Fatalf("AcquireSocket() #%d = %v, %v, want a socket of instance %s", i, socket, err, want)
+		}
+	}
+
+	now = now.Add(5 * time.Second)
+	if got := slowStart.fraction("svc", "new"); got != 0.55 {
+		t.Errorf("fraction() half way = %v, want 0.55", got)
+	}
+	if !slowStart.admit("svc", "new") {
+		t.Errorf("admit() = false with fraction 0.55 and random 0.5")
+	}
+	now = now.Add(5 * time.Second)
+	if got := slowStart.fraction("svc", "new"); got != 1 {
+		t.Errorf("fraction() after the window = %v, want 1", got)
+	}
+	if got := slowStart.Statuses(); len(got) != 0 {
+		t.Errorf("Statuses() after the window = %v, want none", got)
+	}
+}
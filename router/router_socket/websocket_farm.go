@@ -2,6 +2,7 @@ package router_socket
 
 import (
 	"fmt"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
@@ -21,6 +22,17 @@ type WebsocketFarm struct {
 	timeSpent         int64
 	socketAcquireTime time.Duration
 	darkLaunchManager *darklaunch_manager.DarkLaunchManager
+	registry          *ConnectionRegistry
+	connected         *sync.Map // in format of map[*RouterSocket]struct{}, the registered sockets, both idle and busy
+	draining          *sync.Map // in format of map[string]struct{}, the connectorInstanceIDs whose sockets are not handed out
+	inflight          *sync.Map // in format of map[string]*RouterSocket, the sockets serving requests by request id
+	events            *farmEvents
+	concurrency       *ConcurrencyLimits
+	permits           *sync.Map // in format of map[*RouterSocket]*concurrencyPermit, the permits held by the acquired sockets
+	breakers          *CircuitBreakers
+	outliers          *OutlierDetector
+	slowStart         *SlowStart
+	timeouts          *RouteTimeouts
 }
 
 func NewWebsocketFarm(connMonitor *util.ConnectionMonitor, darkLaunchManager *darklaunch_manager.DarkLaunchManager) *WebsocketFarm {
@@ -33,17 +45,41 @@ func NewWebsocketFarm(connMonitor *util.ConnectionMonitor, darkLaunchManager *da
 		timeSpent:         0,
 		socketAcquireTime: time.Second * 15,
 		darkLaunchManager: darkLaunchManager,
+		registry:          NewConnectionRegistry(),
+		connected:         &sync.Map{},
+		draining:          &sync.Map{},
+		inflight:          &sync.Map{},
+		events:            newFarmEvents(),
+		concurrency:       NewConcurrencyLimits(),
+		permits:           &sync.Map{},
 	}
 	listener := &darkListener{
 		sockets:      f.sockets,
 		darkSockets:  f.darkSockets,
 		catchall:     f.catchall,
 		darkCatchAll: f.darkCatchall,
+		isDark:       f.isDarkSocket,
+		onMoved:      f.events.socketMoved,
+		onChanged:    f.events.darkLaunchChanged,
 	}
-	darkLaunchManager.SetIpListener(listener).SetServiceListener(listener)
+	darkLaunchManager.SetIpListener(listener).SetServiceListener(listener).
+		SetInstanceListener(listener).SetVersionListener(listener)
+	darklaunch_manager.AddGrayToggleListener(f.events)
 	return f
 }
 
+// Registry tracks the connected sockets of each connector instance, it is filled by the router on registration
+func (f *WebsocketFarm) Registry() *ConnectionRegistry {
+	return f.registry
+}
+
+// isDarkSocket judges if the socket belongs to the dark queues, by its ip, its route, its connector instance or its version label
+func (f *WebsocketFarm) isDarkSocket(socket *RouterSocket) bool {
+	m := f.darkLaunchManager
+	return m.IsDarkModeOn() && (m.ContainsIp(socket.Ip()) || m.ContainsService(socket.Route) ||
+		m.ContainsInstance(socket.ConnectorInstanceID()) || m.ContainsVersion(socket.VersionLabel()))
+}
+
 func (f *WebsocketFarm) DarkCatchall() *IterableChan {
 	return f.darkCatchall
 }
@@ -58,6 +94,7 @@ func (f *WebsocketFarm) SetSocketAcquireTime(socketAcquireTime time.Duration) {
 }
 
 func (f *WebsocketFarm) Stop() {
+	darklaunch_manager.RemoveGrayToggleListener(f.events)
 	for !f.catchall.IsEmpty() {
 		if socket := f.catchall.Poll(); socket == nil {
 			util.LOG.Info("failed to pop socket from catchall linkedBlockingQueue, the queue is empty")
@@ -82,36 +119,55 @@ func (f *WebsocketFarm) Stop() {
 
 func (f *WebsocketFarm) RemoveWebsocket(route string, socket *RouterSocket) {
 	util.LOG.Debugf("removing websocket {%s}, its connectorInstanceID is %s", route, socket.ConnectorInstanceID())
-	if f.darkLaunchManager.IsDarkModeOn() && (f.darkLaunchManager.ContainsIp(socket.Ip()) || f.darkLaunchManager.ContainsService(socket.Route)) {
-		util.LOG.Debugf("dark mode on and current socket %v contains ip %v, contains service %s", socket.String(),
-			f.darkLaunchManager.ContainsIp(socket.Ip()), f.darkLaunchManager.ContainsService(socket.Route))
+	if queue := f.queueOf(route, socket); queue != nil {
+		queue.Remove(socket)
+	}
+}
+
+// EvictIdleSocket removes the socket from its queue and closes its websocket,
+// false if it is not idle, e.g. it was handed out to a request meanwhile
+func (f *WebsocketFarm) EvictIdleSocket(socket *RouterSocket) bool {
+	queue := f.queueOf(socket.Route, socket)
+	if queue == nil || !queue.Remove(socket) {
+		return false
+	}
+	socket.markRemoved()
+	socket.evict()
+	return true
+}
+
+// queueOf the queue the idle socket is offered in, nil if there is none
+func (f *WebsocketFarm) queueOf(route string, socket *RouterSocket) *IterableChan {
+	if f.isDarkSocket(socket) {
 		if socket.IsCatchAll() {
-			f.darkCatchall.Remove(socket)
-		} else {
-			if queue, ok := f.darkSockets.Load(route); ok {
-				queue.(*IterableChan).Remove(socket)
-			}
+			return f.darkCatchall
 		}
-	} else {
-		util.LOG.Debugf("removing socket %s of route %s", socket.String(), route)
-		if socket.IsCatchAll() {
-			f.catchall.Remove(socket)
-		} else {
-			if queue, ok := f.sockets.Load(route); ok {
-				queue.(*IterableChan).Remove(socket)
-			}
+		if queue, ok := f.darkSockets.Load(route); ok {
+			return queue.(*IterableChan)
 		}
+		return nil
+	}
+	if socket.IsCatchAll() {
+		return f.catchall
 	}
+	if queue, ok := f.sockets.Load(route); ok {
+		return queue.(*IterableChan)
+	}
+	return nil
 }
 
 func (f *WebsocketFarm) AddWebsocket(route string, socket *RouterSocket) {
+	if f.IsDraining(socket.ConnectorInstanceID()) {
+		util.LOG.Infof("connector instance %s is draining, closing socket %s instead of adding it", socket.ConnectorInstanceID(), socket.RouterSocketID)
+		socket.CloseSocketSession()
+		return
+	}
 	if route == "" {
 		route = "*"
 	}
 	var queue *IterableChan
-	if f.darkLaunchManager.IsDarkModeOn() && (f.darkLaunchManager.ContainsIp(socket.Ip()) || f.darkLaunchManager.ContainsService(socket.Route)) {
-		util.LOG.Debugf("addWebsocket, dork mode is on and current socket is %s, contains ip is %v, "+
-			"contains service is %v", socket.String(), f.darkLaunchManager.ContainsIp(socket.Ip()), f.darkLaunchManager.ContainsService(socket.Route))
+	if f.isDarkSocket(socket) {
+		util.LOG.Debugf("addWebsocket, dark mode is on and current socket %s is dark", socket.String())
 		if route == "*" {
 			queue = f.darkCatchall
 		} else {
@@ -140,27 +196,180 @@ func (f *WebsocketFarm) AddWebsocket(route string, socket *RouterSocket) {
 	util.LOG.Debugf("websocket added: route=%s, connectorInstanceID=%s, routeSocketID=%s", route, socket.ConnectorInstanceID(), socket.RouterSocketID)
 }
 
+// AcquireSocket an util.OverloadErr is returned if the route is at its concurrency limit until the queue timeout
 func (f *WebsocketFarm) AcquireSocket(target string, componentName string) (socket *RouterSocket, err error) {
-	if socket = f.getRouterSocket(target); socket == nil {
+	permit := f.concurrency.acquireRoute(limitedRoute(target))
+	if permit == nil {
+		util.LOG.Warningf("too many in-flight requests for route %s, rejecting %s, requestComponentName: %s", limitedRoute(target), target, componentName)
+		return nil, util.OverloadErr{Msg: fmt.Sprintf("too many in-flight requests to proxy %s, requestComponentName: %s", target, componentName)}
+	}
+	if socket = f.getRouterSocket(target, permit); socket == nil {
+		f.concurrency.release(permit)
 		util.LOG.Warningf("failed to wait socket for %s, requestComponentName: %s, queue is empty", target, componentName)
 		return nil, util.TimeoutErr{Msg: fmt.Sprintf("failed to proxy %s, requestComponentName: %s", target, componentName)}
 	} else {
+		f.permits.Store(socket, permit)
 		util.LOG.Infof("socket acquired, target: %s, socket: %s, requestComponentName: %s", target, socket.RouterSocketID, componentName)
 		socket.SetReqComponentName(componentName)
 		return socket, nil
 	}
 }
 
-func (f *WebsocketFarm) getRouterSocket(target string) *RouterSocket {
+// ConcurrencyLimits the limits of the in-flight requests per route and per connector instance, they can be changed at runtime
+func (f *WebsocketFarm) ConcurrencyLimits() *ConcurrencyLimits {
+	return f.concurrency
+}
+
+// SetConcurrencyLimits replaces the limits, it must be called before the farm hands out sockets
+func (f *WebsocketFarm) SetConcurrencyLimits(limits *ConcurrencyLimits) {
+	f.concurrency = limits
+	util.LOG.Infof("concurrency limits are %s", limits)
+}
+
+// SetCircuitBreakers skips the sockets of the connector instances which keep failing, nil to disable it.
+// it must be called before the farm hands out sockets
+func (f *WebsocketFarm) SetCircuitBreakers(breakers *CircuitBreakers) {
+	if breakers != nil {
+		breakers.onChanged = f.events.circuitChanged
+		util.LOG.Infof("circuit breakers are %s", breakers)
+	}
+	f.breakers = breakers
+}
+
+// CircuitBreakers nil if circuit breaking is disabled
+func (f *WebsocketFarm) CircuitBreakers() *CircuitBreakers {
+	return f.breakers
+}
+
+// releasePermit is called once the socket disconnected, the permit is usually released by onRequestEnded before
+func (f *WebsocketFarm) releasePermit(socket *RouterSocket) {
+	if value, ok := f.permits.LoadAndDelete(socket); ok {
+		permit := value.(*concurrencyPermit)
+		if permit.probe && f.breakers != nil {
+			f.breakers.cancelProbe(socket.ConnectorInstanceID())
+		}
+		f.concurrency.release(permit)
+	}
+}
+
+// SetOutlierDetector ejects the connector instances much slower than their peers for a while, nil to disable it.
+// it must be called before the farm hands out sockets
+func (f *WebsocketFarm) SetOutlierDetector(outliers *OutlierDetector) {
+	if outliers != nil {
+		outliers.onEjected = f.events.outlierEjected
+		util.LOG.Infof("outlier detector is %s", outliers)
+	}
+	f.outliers = outliers
+}
+
+// OutlierDetector nil if latency outlier ejection is disabled
+func (f *WebsocketFarm) OutlierDetector() *OutlierDetector {
+	return f.outliers
+}
+
+// SetSlowStart ramps up the share of the requests of newly registered connector instances, nil to disable it
+func (f *WebsocketFarm) SetSlowStart(slowStart *SlowStart) {
+	if f.slowStart != nil {
+		f.RemoveListener(f.slowStart)
+	}
+	if slowStart != nil {
+		f.AddListener(slowStart)
+		util.LOG.Infof("slow start is %s", slowStart)
+	}
+	f.slowStart = slowStart
+}
+
+// SlowStart nil if slow start is disabled
+func (f *WebsocketFarm) SlowStart() *SlowStart {
+	return f.slowStart
+}
+
+// pollWithinLimits polls a socket which may be handed out, see admit, the other sockets are put back.
+// the sockets of warming instances are passed over first and taken if no other socket is admitted
+func (f *WebsocketFarm) pollWithinLimits(queue *IterableChan, timeout time.Duration, permit *concurrencyPermit) *RouterSocket {
+	deadline := time.Now().Add(timeout)
+	wake := deadline
+	skipped, warming := 0, false
+	slowStart := f.slowStart != nil
+	for {
+		released := f.concurrency.releasedChan()
+		socket := f.pollAvailable(queue, time.Until(deadline))
+		if socket == nil {
+			return nil
+		}
+		if slowStart && !f.slowStart.admit(registryRoute(socket.Route), socket.ConnectorInstanceID()) {
+			warming = true
+		} else if ok, retryAt := f.admit(socket, permit); ok {
+			return socket
+		} else if !retryAt.IsZero() && retryAt.Before(wake) {
+			wake = retryAt
+		}
+		// a socket whose connector disconnected while it was out of the queue is not put back, see pollAvailable
+		if !socket.removed() {
+			queue.Offer(socket)
+		}
+		// once every queued socket was skipped, wait for an instance to finish a request or for an instance to be let through again
+		if skipped++; skipped <= queue.LenAlive() {
+			continue
+		}
+		skipped = 0
+		if warming {
+			slowStart, warming = false, false
+			continue
+		}
+		waitUntil(released, wake)
+		if !time.Now().Before(deadline) {
+			return nil
+		}
+		wake = deadline
+	}
+}
+
+// admit tells if the socket may be handed out: its connector instance is not ejected as a latency outlier, is below its
+// concurrency limit and its circuit breaker lets it through. if not, retryAt tells when the instance may be admitted again,
+// it is zero if it is admitted again once a request ends
+func (f *WebsocketFarm) admit(socket *RouterSocket, permit *concurrencyPermit) (ok bool, retryAt time.Time) {
+	connectorInstanceID := socket.ConnectorInstanceID()
+	if f.outliers != nil {
+		if ejectedUntil := f.outliers.ejectedUntil(registryRoute(socket.Route), connectorInstanceID); !ejectedUntil.IsZero() {
+			return false, ejectedUntil
+		}
+	}
+	if !f.concurrency.acquireInstance(permit, connectorInstanceID) {
+		return false, retryAt
+	}
+	if f.breakers == nil {
+		return true, retryAt
+	}
+	ok, probe, probeAt := f.breakers.allow(connectorInstanceID)
+	if !ok {
+		f.concurrency.releaseInstance(permit)
+		return false, probeAt
+	}
+	permit.probe = probe
+	return true, retryAt
+}
+
+// limitedRoute the route the concurrency of the target is counted against
+func limitedRoute(target string) string {
+	if route := resolveRoute(target); route != "" {
+		return route
+	}
+	return "*"
+}
+
+func (f *WebsocketFarm) getRouterSocket(target string, permit *concurrencyPermit) *RouterSocket {
 	var (
 		sockets          *sync.Map     = f.sockets
 		catchAll         *IterableChan = f.catchall
 		allRouterSockets *IterableChan
+		isDark           bool
 	)
 	if f.darkLaunchManager.IsDarkModeOn() && darklaunch_manager.IsGrayTestingOn() {
 		util.LOG.Infof("gray testing on.")
 		sockets = f.darkSockets
 		catchAll = f.darkCatchall
+		isDark = true
 	}
 	route := resolveRoute(target)
 	util.LOG.Debugf("handling target %s and getting router socket for route %s", target, route)
@@ -171,7 +380,62 @@ func (f *WebsocketFarm) getRouterSocket(target string) *RouterSocket {
 		allRouterSockets = catchAll
 	}
 	f.connMonitor.ReportWebsocketPoolSize(allRouterSockets.LenAlive())
-	return allRouterSockets.PollTimeout(f.socketAcquireTime)
+	socket := f.pollWithinLimits(allRouterSockets, f.socketAcquireTime, permit)
+	if socket != nil {
+		socket.isDark = isDark
+	}
+	return socket
+}
+
+// onRequestEnded releases the concurrency permit of the socket and feeds the result of a proxied request to the circuit
+// breakers, to the outlier detector and to the dark launch error rate monitor
+func (f *WebsocketFarm) onRequestEnded(socket *RouterSocket, status int, transportFailure bool) {
+	var permit *concurrencyPermit
+	if value, ok := f.permits.LoadAndDelete(socket); ok {
+		permit = value.(*concurrencyPermit)
+	}
+	if f.breakers != nil {
+		f.breakers.record(socket.ConnectorInstanceID(), status, transportFailure, permit != nil && permit.probe)
+	}
+	if f.outliers != nil && !transportFailure && status < http.StatusInternalServerError {
+		f.outliers.record(registryRoute(socket.Route), socket.ConnectorInstanceID(), time.Since(socket.reqStartTime))
+	}
+	f.concurrency.release(permit)
+	if monitor := f.darkLaunchManager.ErrorRateMonitor(); monitor != nil {
+		monitor.Record(socket.Route, socket.isDark, status, transportFailure)
+	}
+}
+
+// darkQueueOf returns the dark queue serving the target, nil if the target has no dark sockets
+func (f *WebsocketFarm) darkQueueOf(target string) *IterableChan {
+	queue := f.darkCatchall
+	if queueInterface, ok := f.darkSockets.Load(resolveRoute(target)); ok {
+		queue = queueInterface.(*IterableChan)
+	}
+	if queue.IsAliveSocketSetEmpty() {
+		return nil
+	}
+	return queue
+}
+
+// HasDarkSockets indicates if there is any idle dark socket which can serve the target
+func (f *WebsocketFarm) HasDarkSockets(target string) bool {
+	return f.darkQueueOf(target) != nil
+}
+
+// AcquireDarkSocket acquires a socket from the dark queues no matter gray testing is on or not, it is used to mirror requests
+func (f *WebsocketFarm) AcquireDarkSocket(target string, componentName string, timeout time.Duration) (*RouterSocket, error) {
+	var socket *RouterSocket
+	if queue := f.darkQueueOf(target); queue != nil {
+		socket = f.pollAvailable(queue, timeout)
+	}
+	if socket == nil {
+		return nil, util.TimeoutErr{Msg: fmt.Sprintf("failed to acquire dark socket for %s, requestComponentName: %s", target, componentName)}
+	}
+	util.LOG.Debugf("dark socket acquired, target: %s, socket: %s, requestComponentName: %s", target, socket.RouterSocketID, componentName)
+	socket.isDark = true
+	socket.SetReqComponentName(componentName)
+	return socket, nil
 }
 
 func resolveRoute(target string) string {
@@ -290,167 +554,114 @@ func combineSyncMap(sockets *sync.Map, darkSockets *sync.Map) map[string][]*Rout
 	return m
 }
 
+// darkListener moves sockets between the normal and the dark queues once the dark launch entries change
 type darkListener struct {
 	sockets      *sync.Map // in format of map[string]*BlockingQueue   this Map is like java's ConcurrentHashMap
 	darkSockets  *sync.Map // in format of map[string]*BlockingQueue
 	catchall     *IterableChan
 	darkCatchAll *IterableChan
+	isDark       func(socket *RouterSocket) bool
+	onMoved      func(socket *RouterSocket, isDark bool)
+	onChanged    func(eventType FarmEventType, kind, value string)
 }
 
 func (l *darkListener) AfterDarkServiceAdded(addedService string) {
-	l.sockets.Range(func(_, queueInterface interface{}) bool {
-		queue := queueInterface.(*IterableChan)
-		toBeRemoved := make([]*RouterSocket, 0, 8)
-		queue.Range(func(socketInterface interface{}) bool {
-			socket := socketInterface.(*RouterSocket)
-			if socket.Ip() == addedService {
-				var darkQu *IterableChan
-				if darkQuInterface, ok := l.darkSockets.Load(socket.Route); !ok {
-					darkQu = NewIterableChan(blockingQueueCapacity)
-					l.darkSockets.Store(socket.Route, darkQu)
-				} else {
-					darkQu = darkQuInterface.(*IterableChan)
-				}
-				darkQu.Offer(socket)
-				toBeRemoved = append(toBeRemoved, socket)
-			}
-			return true
-		})
-		for _, socket := range toBeRemoved {
-			queue.Remove(socket)
-		}
-		return true
-	})
-
-	toBeRemoved := make([]*RouterSocket, 0, 8)
-	l.catchall.Range(func(socketInterface interface{}) bool {
-		socket := socketInterface.(*RouterSocket)
-		if socket.Route == addedService {
-			l.darkCatchAll.Offer(socket)
-			toBeRemoved = append(toBeRemoved, socket)
-		}
-		return true
-	})
-	for _, socket := range toBeRemoved {
-		l.catchall.Remove(socket)
-	}
+	l.changed(DarkLaunchAdded, "service", addedService)
+	l.moveToDark()
 }
 
 func (l *darkListener) AfterDarkServiceRevoked(revokedService string) {
+	l.changed(DarkLaunchRevoked, "service", revokedService)
 	darklaunch_manager.TurnGrayTestingOff("turn off gray testing after service revoked")
-	l.darkSockets.Range(func(_, queueInterface interface{}) bool {
-		darkQueue := queueInterface.(*IterableChan)
-		toBeRemoved := make([]*RouterSocket, 0, 8)
-		darkQueue.Range(func(socketInterface interface{}) bool {
-			socket := socketInterface.(*RouterSocket)
-			if socket.Route == revokedService {
-				var qu *IterableChan
-				if darkQuInterface, ok := l.darkSockets.Load(socket.Route); !ok {
-					qu = NewIterableChan(blockingQueueCapacity)
-					l.sockets.Store(socket.Route, qu)
-				} else {
-					qu = darkQuInterface.(*IterableChan)
-				}
-				qu.Offer(socket)
-				toBeRemoved = append(toBeRemoved, socket)
-			}
-			return true
-		})
-		for _, socket := range toBeRemoved {
-			darkQueue.Remove(socket)
-		}
-		return true
-	})
-
-	toBeRemoved := make([]*RouterSocket, 0, 8)
-	l.darkCatchAll.Range(func(socketInterface interface{}) bool {
-		socket := socketInterface.(*RouterSocket)
-		if socket.Route == revokedService {
-			l.catchall.Offer(socket)
-			toBeRemoved = append(toBeRemoved, socket)
-		}
-		return true
-	})
-	for _, socket := range toBeRemoved {
-		l.darkCatchAll.Remove(socket)
-	}
+	l.moveToNormal()
 }
 
 func (l *darkListener) AfterDarkIpAdded(addedIp string) {
+	l.changed(DarkLaunchAdded, "ip", addedIp)
+	l.moveToDark()
+}
+
+func (l *darkListener) AfterDarkIpRevoked(revokedIp string) {
+	l.changed(DarkLaunchRevoked, "ip", revokedIp)
+	darklaunch_manager.TurnGrayTestingOff("turn off gray testing after ip revoked")
+	l.moveToNormal()
+}
+
+func (l *darkListener) AfterDarkInstanceAdded(addedInstance string) {
+	l.changed(DarkLaunchAdded, "instance", addedInstance)
+	l.moveToDark()
+}
+
+func (l *darkListener) AfterDarkInstanceRevoked(revokedInstance string) {
+	l.changed(DarkLaunchRevoked, "instance", revokedInstance)
+	darklaunch_manager.TurnGrayTestingOff("turn off gray testing after connector instance revoked")
+	l.moveToNormal()
+}
+
+func (l *darkListener) AfterDarkVersionAdded(addedVersion string) {
+	l.changed(DarkLaunchAdded, "version", addedVersion)
+	l.moveToDark()
+}
+
+func (l *darkListener) AfterDarkVersionRevoked(revokedVersion string) {
+	l.changed(DarkLaunchRevoked, "version", revokedVersion)
+	darklaunch_manager.TurnGrayTestingOff("turn off gray testing after version revoked")
+	l.moveToNormal()
+}
+
+// moveToDark moves the idle sockets which became dark from the normal queues to the dark queues
+func (l *darkListener) moveToDark() {
 	l.sockets.Range(func(_, queueInterface interface{}) bool {
-		queue := queueInterface.(*IterableChan)
-		toBeRemoved := make([]*RouterSocket, 0, 8)
-		queue.Range(func(socketInterface interface{}) bool {
-			socket := socketInterface.(*RouterSocket)
-			if socket.Ip() == addedIp {
-				var darkQu *IterableChan
-				if darkQuInterface, ok := l.darkSockets.Load(socket.Route); !ok {
-					darkQu = NewIterableChan(blockingQueueCapacity)
-					l.darkSockets.Store(socket.Route, darkQu)
-				} else {
-					darkQu = darkQuInterface.(*IterableChan)
-				}
-				darkQu.Offer(socket)
-				toBeRemoved = append(toBeRemoved, socket)
-			}
-			return true
-		})
-		for _, socket := range toBeRemoved {
-			queue.Remove(socket)
-		}
+		moveSockets(queueInterface.(*IterableChan), l.darkSockets, nil, l.isDark, l.movedTo(true))
 		return true
 	})
+	moveSockets(l.catchall, nil, l.darkCatchAll, l.isDark, l.movedTo(true))
+}
 
-	toBeRemoved := make([]*RouterSocket, 0, 8)
-	l.catchall.Range(func(socketInterface interface{}) bool {
-		socket := socketInterface.(*RouterSocket)
-		if socket.Ip() == addedIp {
-			l.darkCatchAll.Offer(socket)
-			toBeRemoved = append(toBeRemoved, socket)
-		}
+// moveToNormal moves the idle sockets which are no longer dark from the dark queues back to the normal queues
+func (l *darkListener) moveToNormal() {
+	isNormal := func(socket *RouterSocket) bool { return !l.isDark(socket) }
+	l.darkSockets.Range(func(_, queueInterface interface{}) bool {
+		moveSockets(queueInterface.(*IterableChan), l.sockets, nil, isNormal, l.movedTo(false))
 		return true
 	})
-	for _, socket := range toBeRemoved {
-		l.catchall.Remove(socket)
+	moveSockets(l.darkCatchAll, nil, l.catchall, isNormal, l.movedTo(false))
+}
+
+func (l *darkListener) changed(eventType FarmEventType, kind, value string) {
+	if l.onChanged != nil {
+		l.onChanged(eventType, kind, value)
 	}
 }
 
-func (l *darkListener) AfterDarkIpRevoked(revokedIp string) {
-	darklaunch_manager.TurnGrayTestingOff("turn off gray testing after ip revoked")
-	l.darkSockets.Range(func(_, queueInterface interface{}) bool {
-		darkQueue := queueInterface.(*IterableChan)
-		toBeRemoved := make([]*RouterSocket, 0, 8)
-		darkQueue.Range(func(socketInterface interface{}) bool {
-			socket := socketInterface.(*RouterSocket)
-			if socket.Ip() == revokedIp {
-				var qu *IterableChan
-				if darkQuInterface, ok := l.darkSockets.Load(socket.Route); !ok {
-					qu = NewIterableChan(blockingQueueCapacity)
-					l.sockets.Store(socket.Route, qu)
-				} else {
-					qu = darkQuInterface.(*IterableChan)
-				}
-				qu.Offer(socket)
-				toBeRemoved = append(toBeRemoved, socket)
-			}
-			return true
-		})
-		for _, socket := range toBeRemoved {
-			darkQueue.Remove(socket)
+func (l *darkListener) movedTo(isDark bool) func(socket *RouterSocket) {
+	return func(socket *RouterSocket) {
+		if l.onMoved != nil {
+			l.onMoved(socket, isDark)
 		}
-		return true
-	})
+	}
+}
 
-	toBeRemoved := make([]*RouterSocket, 0, 8)
-	l.darkCatchAll.Range(func(socketInterface interface{}) bool {
-		socket := socketInterface.(*RouterSocket)
-		if socket.Ip() == revokedIp {
-			l.catchall.Offer(socket)
-			toBeRemoved = append(toBeRemoved, socket)
+// moveSockets moves the matched sockets of from to the queue of their route in toQueues, or to toQueue if toQueues is nil.
+// a socket which is polled concurrently is serving a request, so it is left alone
+func moveSockets(from *IterableChan, toQueues *sync.Map, toQueue *IterableChan, match func(socket *RouterSocket) bool, onMoved func(socket *RouterSocket)) {
+	toBeMoved := make([]*RouterSocket, 0, 8)
+	from.Range(func(socketInterface interface{}) bool {
+		if socket := socketInterface.(*RouterSocket); match(socket) {
+			toBeMoved = append(toBeMoved, socket)
 		}
 		return true
 	})
-	for _, socket := range toBeRemoved {
-		l.darkCatchAll.Remove(socket)
+	for _, socket := range toBeMoved {
+		if !from.Remove(socket) {
+			continue
+		}
+		queue := toQueue
+		if toQueues != nil {
+			queueInterface, _ := toQueues.LoadOrStore(socket.Route, NewIterableChan(blockingQueueCapacity))
+			queue = queueInterface.(*IterableChan)
+		}
+		queue.Offer(socket)
+		onMoved(socket)
 	}
 }
@@ -0,0 +1,41 @@
+package router
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/torchcc/crank4go/router/darklaunch_manager"
+	"github.com/torchcc/crank4go/router/handler"
+	"github.com/torchcc/crank4go/router/router_socket"
+	"github.com/torchcc/crank4go/util"
+)
+
+func TestCreateHttpHandlerDefaultsRateLimitFilter(t *testing.T) {
+	routerProxies, _ := util.ParseIpRanges([]string{"10.0.0.0/8"})
+	ownProxies, _ := util.ParseIpRanges([]string{"192.168.0.0/16"})
+	connMonitor := util.NewConnectionMonitor(nil)
+	tests := []struct {
+		name        string
+		filter      *handler.RateLimitFilter
+		wantProxies util.IpRanges
+	}{
+		{name: "none of its own", filter: handler.NewRateLimitFilter().SetIpLimit(1, 1, nil), wantProxies: routerProxies},
+		{name: "its own", filter: handler.NewRateLimitFilter().SetIpLimit(1, 1, ownProxies), wantProxies: ownProxies},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewRouterConfig2("127.0.0.1", "127.0.0.1", 0, 0, nil, nil, nil, nil).
+				SetTrustedProxies(routerProxies).
+				SetRateLimitFilter(tt.filter)
+			r := &Router{routerConfig: config, connMonitor: connMonitor,
+				websocketFarm: router_socket.NewWebsocketFarm(connMonitor, darklaunch_manager.NewDarkLaunchManager())}
+			r.CreateHttpHandler()
+			if got := tt.filter.TrustedProxies(); !reflect.DeepEqual(got, tt.wantProxies) {
+				t.Errorf("TrustedProxies() = %s, want %s", got, tt.wantProxies)
+			}
+			if handlers := tt.filter.DataPublishHandlers(); len(handlers) != 1 || handlers[0] != connMonitor {
+				t.Errorf("DataPublishHandlers() = %v, want the ConnMonitor", handlers)
+			}
+		})
+	}
+}
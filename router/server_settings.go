@@ -0,0 +1,66 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/torchcc/crank4go/util"
+)
+
+// ServerSettings harden a listener of the router against slow or piling up connections, a zero value is not enforced.
+// ReadTimeout covers the request body too, so it cuts long uploads, event streams and the requests of websocket upgrades
+// which were not upgraded yet; the websockets themselves are not affected as their deadlines are cleared on upgrade
+type ServerSettings struct {
+	ReadHeaderTimeout time.Duration // to read the request header, it stops slowloris-style connections
+	ReadTimeout       time.Duration // to read the whole request
+	WriteTimeout      time.Duration // to write the whole response
+	IdleTimeout       time.Duration // to wait for the next request of a keep-alive connection
+	MaxConns          int           // the connections open at the same time, more are not accepted until one is closed
+	// the load balancers speaking HAProxy's PROXY protocol to the listener, the client addresses they send are the RemoteAddr
+	// of the requests then, so they are logged, rate limited and forwarded. connections from other sources are rejected.
+	// nil to turn the PROXY protocol off
+	ProxyProtocolSources util.IpRanges
+}
+
+// DefaultHttpServerSettings the settings of the server facing the clients, the request bodies and the responses are
+// streamed from and to the connectors, so they are not limited in time
+func DefaultHttpServerSettings() ServerSettings {
+	return ServerSettings{ReadHeaderTimeout: 10 * time.Second, IdleTimeout: 2 * time.Minute, MaxConns: 10000}
+}
+
+// DefaultRegistrationServerSettings the settings of the server the connectors register to, the connections of the
+// connectors are long-lived websockets, and the admin api serves event streams
+func DefaultRegistrationServerSettings() ServerSettings {
+	return ServerSettings{ReadHeaderTimeout: 10 * time.Second, IdleTimeout: 2 * time.Minute, MaxConns: 10000}
+}
+
+func (s ServerSettings) String() string {
+	return fmt.Sprintf("ServerSettings{readHeaderTimeout=%v, readTimeout=%v, writeTimeout=%v, idleTimeout=%v, maxConns=%d, proxyProtocolSources=%s}",
+		s.ReadHeaderTimeout, s.ReadTimeout, s.WriteTimeout, s.IdleTimeout, s.MaxConns, s.ProxyProtocolSources)
+}
+
+func (s ServerSettings) applyTo(server *http.Server) *http.Server {
+	server.ReadHeaderTimeout = s.ReadHeaderTimeout
+	server.ReadTimeout = s.ReadTimeout
+	server.WriteTimeout = s.WriteTimeout
+	server.IdleTimeout = s.IdleTimeout
+	return server
+}
+
+// listen on the address of the server, accepting at most MaxConns connections at the same time
+func (s ServerSettings) listen(server *http.Server) (net.Listener, error) {
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.ProxyProtocolSources != nil {
+		headerTimeout := s.ReadHeaderTimeout
+		if headerTimeout <= 0 {
+			headerTimeout = 10 * time.Second
+		}
+		listener = util.ProxyProtoListener(listener, s.ProxyProtocolSources, headerTimeout)
+	}
+	return util.LimitListener(listener, s.MaxConns), nil
+}
@@ -0,0 +1,289 @@
+package shadow_mirror
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxBodyBytes   = 64 * 1024
+	defaultMaxMismatches  = 100
+	defaultAcquireTimeout = 100 * time.Millisecond
+	defaultMirrorTimeout  = 30 * time.Second
+)
+
+// ShadowMirror configures the mirroring of live requests to dark connectors.
+// the client only ever sees the response from the normal connectors, the mirrored response is thrown away
+// after its status, headers and body hash are compared with the primary response.
+type ShadowMirror struct {
+	maxBodyBytes   int64
+	acquireTimeout time.Duration
+	mirrorTimeout  time.Duration
+	ignoredHeaders map[string]struct{}
+	methods        map[string]struct{}
+	report         *MirrorReport
+}
+
+func NewShadowMirror(maxBodyBytes int64) *ShadowMirror {
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = defaultMaxBodyBytes
+	}
+	m := &ShadowMirror{
+		maxBodyBytes:   maxBodyBytes,
+		acquireTimeout: defaultAcquireTimeout,
+		mirrorTimeout:  defaultMirrorTimeout,
+		ignoredHeaders: make(map[string]struct{}),
+		report:         NewMirrorReport(defaultMaxMismatches),
+	}
+	// the RateLimit-* headers are set on the primary response by the router's rate limit filter, not by the connector
+	m.SetIgnoredHeaders("Date", "Via", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset")
+	m.SetMirroredMethods(http.MethodGet, http.MethodHead, http.MethodOptions)
+	return m
+}
+
+// MaxBodyBytes requests with a bigger body are not mirrored
+func (m *ShadowMirror) MaxBodyBytes() int64 {
+	return m.maxBodyBytes
+}
+
+func (m *ShadowMirror) AcquireTimeout() time.Duration {
+	return m.acquireTimeout
+}
+
+// SetAcquireTimeout how long to wait for an idle dark socket, the request is not mirrored if none is available in time
+func (m *ShadowMirror) SetAcquireTimeout(acquireTimeout time.Duration) *ShadowMirror {
+	m.acquireTimeout = acquireTimeout
+	return m
+}
+
+func (m *ShadowMirror) MirrorTimeout() time.Duration {
+	return m.mirrorTimeout
+}
+
+// SetMirrorTimeout how long to wait for the dark connector to finish the mirrored response
+func (m *ShadowMirror) SetMirrorTimeout(mirrorTimeout time.Duration) *ShadowMirror {
+	m.mirrorTimeout = mirrorTimeout
+	return m
+}
+
+// SetIgnoredHeaders headers which are expected to differ between two responses and are not compared, they replace
+// the defaults Date, Via and the RateLimit-* headers
+func (m *ShadowMirror) SetIgnoredHeaders(headers ...string) *ShadowMirror {
+	m.ignoredHeaders = make(map[string]struct{})
+	for _, h := range headers {
+		m.ignoredHeaders[http.CanonicalHeaderKey(h)] = struct{}{}
+	}
+	return m
+}
+
+// SetMirroredMethods the methods of the requests which are mirrored, GET, HEAD and OPTIONS by default.
+// dark connectors usually share the datastore of the live ones, so a mirrored write is done twice
+func (m *ShadowMirror) SetMirroredMethods(methods ...string) *ShadowMirror {
+	m.methods = make(map[string]struct{})
+	for _, method := range methods {
+		m.methods[strings.ToUpper(method)] = struct{}{}
+	}
+	return m
+}
+
+// Mirrors judges if requests of the method are mirrored
+func (m *ShadowMirror) Mirrors(method string) bool {
+	_, ok := m.methods[method]
+	return ok
+}
+
+func (m *ShadowMirror) Report() *MirrorReport {
+	return m.report
+}
+
+// Compare compares the primary and the mirrored response and records the result
+func (m *ShadowMirror) Compare(method, path, route string, primary, shadow *ResponseSummary) {
+	differences := make([]string, 0, 4)
+	if primary.Status != shadow.Status {
+		differences = append(differences, fmt.Sprintf("status: %d != %d", primary.Status, shadow.Status))
+	}
+	keys := make(map[string]struct{})
+	for k := range primary.Headers {
+		keys[k] = struct{}{}
+	}
+	for k := range shadow.Headers {
+		keys[k] = struct{}{}
+	}
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		if _, ignored := m.ignoredHeaders[http.CanonicalHeaderKey(k)]; !ignored {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		p, s := strings.Join(primary.Headers.Values(k), ","), strings.Join(shadow.Headers.Values(k), ",")
+		if p != s {
+			differences = append(differences, fmt.Sprintf("header %s: %q != %q", k, p, s))
+		}
+	}
+	if primary.BodyHash != shadow.BodyHash {
+		differences = append(differences, fmt.Sprintf("body hash: %s != %s", primary.BodyHash, shadow.BodyHash))
+	}
+	if len(differences) == 0 {
+		m.report.onMatched()
+		return
+	}
+	m.report.onMismatched(&Mismatch{
+		Time:        time.Now(),
+		Method:      method,
+		Path:        path,
+		Route:       route,
+		Primary:     primary,
+		Shadow:      shadow,
+		Differences: differences,
+	})
+}
+
+// ResponseCapture a http.ResponseWriter which records the status, the headers and the sha256 hash of the body.
+// with a nil delegate the response is thrown away, otherwise it is passed through to the delegate
+type ResponseCapture struct {
+	delegate http.ResponseWriter
+	header   http.Header
+	status   int
+	bytes    int64
+	hash     hash.Hash
+}
+
+func NewResponseCapture(delegate http.ResponseWriter) *ResponseCapture {
+	c := &ResponseCapture{delegate: delegate, hash: sha256.New()}
+	if delegate != nil {
+		c.header = delegate.Header()
+	} else {
+		c.header = make(http.Header)
+	}
+	return c
+}
+
+func (c *ResponseCapture) Header() http.Header {
+	return c.header
+}
+
+func (c *ResponseCapture) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+	if c.delegate != nil {
+		c.delegate.WriteHeader(status)
+	}
+}
+
+func (c *ResponseCapture) Write(buf []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.hash.Write(buf)
+	c.bytes += int64(len(buf))
+	if c.delegate != nil {
+		return c.delegate.Write(buf)
+	}
+	return len(buf), nil
+}
+
+func (c *ResponseCapture) Status() int {
+	return c.status
+}
+
+func (c *ResponseCapture) BodyHash() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+// Summary snapshots the captured response
+func (c *ResponseCapture) Summary() *ResponseSummary {
+	return &ResponseSummary{Status: c.status, Headers: c.header.Clone(), BodyBytes: c.bytes, BodyHash: c.BodyHash()}
+}
+
+type ResponseSummary struct {
+	Status    int         `json:"status"`
+	Headers   http.Header `json:"headers"`
+	BodyBytes int64       `json:"bodyBytes"`
+	BodyHash  string      `json:"bodyHash"`
+}
+
+type Mismatch struct {
+	Time        time.Time        `json:"time"`
+	Method      string           `json:"method"`
+	Path        string           `json:"path"`
+	Route       string           `json:"route"`
+	Primary     *ResponseSummary `json:"primary"`
+	Shadow      *ResponseSummary `json:"shadow"`
+	Differences []string         `json:"differences"`
+}
+
+// MirrorReport keeps the counters of mirrored requests and the latest mismatches
+type MirrorReport struct {
+	mirrored      int64
+	matched       int64
+	mismatched    int64
+	skipped       int64
+	maxMismatches int
+	lock          sync.Mutex
+	mismatches    []*Mismatch
+}
+
+func NewMirrorReport(maxMismatches int) *MirrorReport {
+	return &MirrorReport{maxMismatches: maxMismatches, mismatches: make([]*Mismatch, 0, maxMismatches)}
+}
+
+func (r *MirrorReport) OnMirrored() {
+	atomic.AddInt64(&r.mirrored, 1)
+}
+
+// OnSkipped is called when a request could not be mirrored, e.g. its body is too big or no dark socket is available
+func (r *MirrorReport) OnSkipped() {
+	atomic.AddInt64(&r.skipped, 1)
+}
+
+func (r *MirrorReport) onMatched() {
+	atomic.AddInt64(&r.matched, 1)
+}
+
+func (r *MirrorReport) onMismatched(mismatch *Mismatch) {
+	atomic.AddInt64(&r.mismatched, 1)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.mismatches) >= r.maxMismatches {
+		r.mismatches = r.mismatches[1:]
+	}
+	r.mismatches = append(r.mismatches, mismatch)
+}
+
+func (r *MirrorReport) Mismatches() []*Mismatch {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append(make([]*Mismatch, 0, len(r.mismatches)), r.mismatches...)
+}
+
+func (r *MirrorReport) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"mirrored":   atomic.LoadInt64(&r.mirrored),
+		"matched":    atomic.LoadInt64(&r.matched),
+		"mismatched": atomic.LoadInt64(&r.mismatched),
+		"skipped":    atomic.LoadInt64(&r.skipped),
+		"mismatches": r.Mismatches(),
+	}
+}
+
+// Reset clears the counters and the recorded mismatches
+func (r *MirrorReport) Reset() {
+	atomic.StoreInt64(&r.mirrored, 0)
+	atomic.StoreInt64(&r.matched, 0)
+	atomic.StoreInt64(&r.mismatched, 0)
+	atomic.StoreInt64(&r.skipped, 0)
+	r.lock.Lock()
+	r.mismatches = make([]*Mismatch, 0, r.maxMismatches)
+	r.lock.Unlock()
+}
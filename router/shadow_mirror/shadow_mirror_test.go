@@ -0,0 +1,151 @@
+package shadow_mirror
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func summaryOf(status int, headers http.Header, body string) *ResponseSummary {
+	capture := NewResponseCapture(nil)
+	for k, v := range headers {
+		capture.Header()[k] = v
+	}
+	capture.WriteHeader(status)
+	_, _ = capture.Write([]byte(body))
+	return capture.Summary()
+}
+
+func TestCompare(t *testing.T) {
+	primary := summaryOf(http.StatusOK, http.Header{"Content-Type": {"text/plain"}, "Date": {"Mon"}, "Ratelimit-Remaining": {"10"}}, "hello")
+	tests := []struct {
+		name            string
+		shadow          *ResponseSummary
+		wantDifferences []string
+	}{
+		{name: "same", shadow: summaryOf(http.StatusOK, http.Header{"Content-Type": {"text/plain"}, "Date": {"Tue"}}, "hello")},
+		{name: "rate limited", shadow: summaryOf(http.StatusOK, http.Header{"Content-Type": {"text/plain"}, "Ratelimit-Remaining": {"9"}}, "hello")},
+		{name: "status", shadow: summaryOf(http.StatusInternalServerError, http.Header{"Content-Type": {"text/plain"}}, "hello"),
+			wantDifferences: []string{"status: 200 != 500"}},
+		{name: "header", shadow: summaryOf(http.StatusOK, http.Header{"Content-Type": {"application/json"}, "X-Extra": {"1"}}, "hello"),
+			wantDifferences: []string{`header Content-Type: "text/plain" != "application/json"`, `header X-Extra: "" != "1"`}},
+		{name: "body", shadow: summaryOf(http.StatusOK, http.Header{"Content-Type": {"text/plain"}}, "hullo"),
+			wantDifferences: []string{"body hash: "}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mirror := NewShadowMirror(0)
+			mirror.Compare(http.MethodGet, "/svc/hello", "svc", primary, tt.shadow)
+			report := mirror.Report().ToMap()
+			mismatches := mirror.Report().Mismatches()
+			if len(tt.wantDifferences) == 0 {
+				if report["matched"] != int64(1) || len(mismatches) != 0 {
+					t.Fatalf("report = %v, want a match", report)
+				}
+				return
+			}
+			if report["mismatched"] != int64(1) || len(mismatches) != 1 {
+				t.Fatalf("report = %v, want a mismatch", report)
+			}
+			got := mismatches[0].Differences
+			if len(got) != len(tt.wantDifferences) {
+				t.Fatalf("differences = %q, want %q", got, tt.wantDifferences)
+			}
+			for i, want := range tt.wantDifferences {
+				if !strings.HasPrefix(got[i], want) {
+					t.Errorf("difference %d = %q, want %q", i, got[i], want)
+				}
+			}
+			if mismatches[0].Route != "svc" || mismatches[0].Path != "/svc/hello" {
+				t.Errorf("mismatch = %+v, want the route and path of the request", mismatches[0])
+			}
+		})
+	}
+}
+
+func TestResponseCapture(t *testing.T) {
+	tests := []struct {
+		name       string
+		delegate   *httptest.ResponseRecorder
+		status     int
+		body       string
+		wantStatus int
+	}{
+		{name: "discarded", status: http.StatusCreated, body: "created", wantStatus: http.StatusCreated},
+		{name: "passed through", delegate: httptest.NewRecorder(), status: http.StatusNotFound, body: "not found", wantStatus: http.StatusNotFound},
+		{name: "implicit status", delegate: httptest.NewRecorder(), body: "ok", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a nil recorder must not be passed as a non-nil http.ResponseWriter
+			var capture *ResponseCapture
+			if tt.delegate != nil {
+				capture = NewResponseCapture(tt.delegate)
+			} else {
+				capture = NewResponseCapture(nil)
+			}
+			capture.Header().Set("Content-Type", "text/plain")
+			if tt.status != 0 {
+				capture.WriteHeader(tt.status)
+			}
+			_, _ = capture.Write([]byte(tt.body[:2]))
+			_, _ = capture.Write([]byte(tt.body[2:]))
+
+			summary := capture.Summary()
+			if summary.Status != tt.wantStatus || summary.BodyBytes != int64(len(tt.body)) || summary.Headers.Get("Content-Type") != "text/plain" {
+				t.Errorf("summary = %+v, want status %d and %d body bytes", summary, tt.wantStatus, len(tt.body))
+			}
+			if summary.BodyHash != summaryOf(http.StatusOK, nil, tt.body).BodyHash {
+				t.Errorf("body hash %s does not match the hash of the whole body", summary.BodyHash)
+			}
+			if tt.delegate != nil && (tt.delegate.Code != tt.wantStatus || tt.delegate.Body.String() != tt.body ||
+				tt.delegate.Header().Get("Content-Type") != "text/plain") {
+				t.Errorf("delegate got %d %q, want the response passed through", tt.delegate.Code, tt.delegate.Body.String())
+			}
+		})
+	}
+}
+
+func TestMirrorReport(t *testing.T) {
+	report := NewMirrorReport(2)
+	for _, path := range []string{"/a", "/b", "/c"} {
+		report.OnMirrored()
+		report.onMismatched(&Mismatch{Path: path})
+	}
+	report.OnSkipped()
+	if mismatches := report.Mismatches(); len(mismatches) != 2 || mismatches[0].Path != "/b" || mismatches[1].Path != "/c" {
+		t.Errorf("mismatches = %v, want the latest 2", mismatches)
+	}
+	if m := report.ToMap(); m["mirrored"] != int64(3) || m["mismatched"] != int64(3) || m["skipped"] != int64(1) {
+		t.Errorf("report = %v", m)
+	}
+	report.Reset()
+	if m := report.ToMap(); m["mirrored"] != int64(0) || len(report.Mismatches()) != 0 {
+		t.Errorf("report = %v after reset, want it cleared", m)
+	}
+}
+
+func TestMirroredMethods(t *testing.T) {
+	tests := []struct {
+		name   string
+		mirror *ShadowMirror
+		method string
+		want   bool
+	}{
+		{"get", NewShadowMirror(0), http.MethodGet, true},
+		{"head", NewShadowMirror(0), http.MethodHead, true},
+		{"options", NewShadowMirror(0), http.MethodOptions, true},
+		{"post", NewShadowMirror(0), http.MethodPost, false},
+		{"delete", NewShadowMirror(0), http.MethodDelete, false},
+		{"allowed put", NewShadowMirror(0).SetMirroredMethods("get", "put"), http.MethodPut, true},
+		{"get not allowed any more", NewShadowMirror(0).SetMirroredMethods(http.MethodPut), http.MethodGet, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.mirror.Mirrors(tt.method); got != tt.want {
+				t.Errorf("Mirrors(%s) = %v, want %v", tt.method, got, tt.want)
+			}
+		})
+	}
+}
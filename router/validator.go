@@ -1,30 +1,127 @@
 package router
 
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/torchcc/crank4go/util"
+)
+
 type Validator interface {
 	IsValid(string) bool
 }
 
+// IpValidator an allowlist of single ips and CIDR ranges, IPv4 and IPv6, which connectors may register from
 type IpValidator struct {
-	ipWhiteList map[string]struct{}
+	lock          sync.RWMutex
+	ipWhiteList   util.IpRanges
+	cancelWatcher func()
 }
 
 // IsValid if ip set is nil, isValid will return true by default.
-// if ip set is not nil and the given ip is not in the set, it will return false
-func (v *IpValidator) IsValid(ip string) bool {
+// if ip set is not nil and the host part of the given remote address is not in any range of the set, it will return false
+func (v *IpValidator) IsValid(remoteAddr string) bool {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
 	if v.ipWhiteList == nil {
 		return true
 	}
-	if _, ok := v.ipWhiteList[ip]; ok {
-		return true
-	} else {
-		return false
-	}
+	return v.ipWhiteList.Contains(remoteAddr)
 }
 
+// UpdateIpWhiteList entries are single ips or CIDR ranges like 10.0.0.0/8 or fd00::/8, invalid entries are skipped
 func (v *IpValidator) UpdateIpWhiteList(newIps []string) {
-	m := make(map[string]struct{})
+	ranges := make(util.IpRanges, 0, len(newIps))
 	for _, ip := range newIps {
-		m[ip] = struct{}{}
+		if prefix, err := util.ParseIpRange(ip); err != nil {
+			util.LOG.Warningf("invalid ip white list entry %s is skipped, err: %s", ip, err.Error())
+		} else {
+			ranges = append(ranges, prefix)
+		}
+	}
+	v.lock.Lock()
+	v.ipWhiteList = ranges
+	v.lock.Unlock()
+}
+
+// LoadIpWhiteListFile loads the white list from a file with one ip or CIDR range per line, blank lines and lines starting with # are skipped.
+// the current white list is kept if the file is invalid
+func (v *IpValidator) LoadIpWhiteListFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := make([]string, 0, 16)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	ranges, err := util.ParseIpRanges(lines)
+	if err != nil {
+		return err
+	}
+	v.lock.Lock()
+	v.ipWhiteList = ranges
+	v.lock.Unlock()
+	util.LOG.Infof("ip white list loaded from %s, %d entries", path, len(ranges))
+	return nil
+}
+
+// WatchIpWhiteListFile loads the white list from the file, then reloads it every time the file is modified.
+// the file is polled with the given interval, 5 seconds by default. replace the file by renaming a new one over it,
+// a file which is rewritten in place may be loaded half written
+func (v *IpValidator) WatchIpWhiteListFile(path string, interval time.Duration) error {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err = v.LoadIpWhiteListFile(path); err != nil {
+		return err
+	}
+	v.StopWatching()
+	done := make(chan struct{})
+	v.lock.Lock()
+	v.cancelWatcher = func() { close(done) }
+	v.lock.Unlock()
+	go func() {
+		modTime, size := info.ModTime(), info.Size()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				info, err := os.Stat(path)
+				if err != nil {
+					util.LOG.Warningf("failed to stat ip white list file %s, the current white list is kept, err: %s", path, err.Error())
+					continue
+				}
+				if info.ModTime().Equal(modTime) && info.Size() == size {
+					continue
+				}
+				modTime, size = info.ModTime(), info.Size()
+				if err = v.LoadIpWhiteListFile(path); err != nil {
+					util.LOG.Warningf("failed to reload ip white list file %s, the current white list is kept, err: %s", path, err.Error())
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// StopWatching stops reloading the white list file
+func (v *IpValidator) StopWatching() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.cancelWatcher != nil {
+		v.cancelWatcher()
+		v.cancelWatcher = nil
 	}
-	v.ipWhiteList = m
 }
@@ -0,0 +1,135 @@
+package router
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIpValidator(t *testing.T) {
+	validator := &IpValidator{}
+	if !validator.IsValid("203.0.113.9:5432") {
+		t.Errorf("a validator without white list must allow any address")
+	}
+	validator.UpdateIpWhiteList([]string{"10.1.2.3", "192.168.0.0/16", "2001:db8::1", "fd00::/8", "not an ip", "10.0.0.0/33"})
+	tests := []struct {
+		remoteAddr string
+		want       bool
+	}{
+		{"10.1.2.3", true},
+		{"10.1.2.3:5432", true},
+		{"10.1.2.4:5432", false},
+		{"192.168.7.1:80", true},
+		{"::ffff:192.168.7.1", true},
+		{"[::ffff:10.1.2.3]:5432", true},
+		{"2001:db8::1", true},
+		{"[2001:db8::1]:443", true},
+		{"[2001:db8::2]:443", false},
+		{"[fd12:3456::1]:443", true},
+		{"fe80::1%eth0", false},
+		{"not an ip", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.remoteAddr, func(t *testing.T) {
+			if got := validator.IsValid(tt.remoteAddr); got != tt.want {
+				t.Errorf("IsValid(%q) = %v, want %v", tt.remoteAddr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIpValidatorLoadsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "connector-ips")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	validator := &IpValidator{}
+
+	write("# connectors\n10.0.0.0/8\n\n  fd00::/8  \n")
+	if err := validator.LoadIpWhiteListFile(path); err != nil {
+		t.Fatalf("LoadIpWhiteListFile() error = %v", err)
+	}
+	if !validator.IsValid("10.9.9.9:1234") || !validator.IsValid("[fd00::1]:1234") || validator.IsValid("192.168.0.1:1234") {
+		t.Errorf("the white list of the file was not loaded")
+	}
+
+	write("10.0.0.0/8\nnot an ip\n")
+	if err := validator.LoadIpWhiteListFile(path); err == nil {
+		t.Errorf("LoadIpWhiteListFile() of an invalid file, want an error")
+	}
+	if !validator.IsValid("[fd00::1]:1234") {
+		t.Errorf("an invalid file must keep the current white list")
+	}
+	if err := validator.LoadIpWhiteListFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("LoadIpWhiteListFile() of a missing file, want an error")
+	}
+}
+
+func TestIpValidatorWatchesFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "connector-ips")
+	// the file is replaced as a whole, a poll could load it half written otherwise
+	replace := func(content string) {
+		tmp := filepath.Join(dir, "connector-ips.tmp")
+		if err := os.WriteFile(tmp, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	replace("10.0.0.1\n")
+	validator := &IpValidator{}
+	if err := validator.WatchIpWhiteListFile(path, 10*time.Millisecond); err != nil {
+		t.Fatalf("WatchIpWhiteListFile() error = %v", err)
+	}
+	defer validator.StopWatching()
+	if !validator.IsValid("10.0.0.1:1234") || validator.IsValid("10.0.0.2:1234") {
+		t.Fatalf("the white list of the file was not loaded")
+	}
+	awaitValid := func(remoteAddr string, want bool) {
+		deadline := time.Now().Add(2 * time.Second)
+		for validator.IsValid(remoteAddr) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("IsValid(%q) is still %v", remoteAddr, !want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// the size changes too, so the modification is seen even where the file system keeps coarse modification times
+	replace("10.0.0.2\n10.0.0.3\n")
+	awaitValid("10.0.0.2:1234", true)
+	if validator.IsValid("10.0.0.1:1234") {
+		t.Errorf("the reloaded white list must replace the previous one")
+	}
+
+	replace("not an ip, the current white list is kept\n")
+	time.Sleep(50 * time.Millisecond)
+	if !validator.IsValid("10.0.0.2:1234") {
+		t.Errorf("an invalid modification must keep the current white list")
+	}
+
+	validator.StopWatching()
+	time.Sleep(20 * time.Millisecond)
+	replace("10.0.0.9\n")
+	time.Sleep(50 * time.Millisecond)
+	if validator.IsValid("10.0.0.9:1234") {
+		t.Errorf("the file was reloaded after StopWatching")
+	}
+}
+
+func TestIpWhiteListFileOfRouterConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "connector-ips")
+	config := NewRouterConfig2("127.0.0.1", "127.0.0.1", 0, 0, nil, nil, nil, nil).SetIpWhiteListFile(path, time.Second)
+	if config.IpWhiteListFile() != path || config.IpWhiteListReloadInterval() != time.Second {
+		t.Errorf("got %s every %v, want %s every 1s", config.IpWhiteListFile(), config.IpWhiteListReloadInterval(), path)
+	}
+	if _, ok := config.IpValidator().(*IpValidator); !ok {
+		t.Errorf("the default validator is a %T, want an *IpValidator the file can be loaded into", config.IpValidator())
+	}
+}
@@ -0,0 +1,273 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	MediaType "github.com/torchcc/crank4go/router/api/media_type"
+	"github.com/torchcc/crank4go/router/router_socket"
+	"github.com/torchcc/crank4go/util"
+)
+
+const (
+	HeaderEvent     = "X-Crank4go-Event"
+	HeaderDelivery  = "X-Crank4go-Delivery"
+	HeaderSignature = "X-Crank4go-Signature" // sha256=<hex of the HMAC-SHA256 of the timestamp, a dot and the body>
+	HeaderTimestamp = "X-Crank4go-Timestamp" // unix seconds of the delivery attempt
+
+	defaultQueueSize = 1024
+)
+
+// Payload the JSON body posted to webhook targets
+type Payload struct {
+	ID         string                   `json:"id"` // the delivery id, the same for all attempts of a delivery
+	Type       string                   `json:"type"`
+	Time       time.Time                `json:"time"`
+	RouterHost string                   `json:"routerHost,omitempty"`
+	Event      *router_socket.FarmEvent `json:"event"`
+}
+
+// Target a URL posted the events it subscribes to, only the events of its types and routes are posted if they are set
+type Target struct {
+	url            string
+	secret         []byte
+	types          map[router_socket.FarmEventType]struct{}
+	routes         map[string]struct{}
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	timeout        time.Duration
+}
+
+// NewTarget posts every event without signature, retrying 5 times with backoff from 1s to 1min
+func NewTarget(url string) *Target {
+	return &Target{
+		url:            url,
+		types:          make(map[router_socket.FarmEventType]struct{}),
+		routes:         make(map[string]struct{}),
+		maxRetries:     5,
+		initialBackoff: time.Second,
+		maxBackoff:     time.Minute,
+		timeout:        10 * time.Second,
+	}
+}
+
+// SetSecret signs the deliveries with HMAC-SHA256, see Sign, the signature is sent in the X-Crank4go-Signature header
+func (t *Target) SetSecret(secret []byte) *Target {
+	t.secret = secret
+	return t
+}
+
+// SetEventTypes posts only the events of the types, e.g. router_socket.RouteEmptied and router_socket.InstanceAppeared
+func (t *Target) SetEventTypes(types ...router_socket.FarmEventType) *Target {
+	t.types = make(map[router_socket.FarmEventType]struct{})
+	for _, eventType := range types {
+		t.types[eventType] = struct{}{}
+	}
+	return t
+}
+
+// SetRoutes posts only the events of the routes, "*" is the catch-all route. events without route, like gray_toggle, are always posted
+func (t *Target) SetRoutes(routes ...string) *Target {
+	t.routes = make(map[string]struct{})
+	for _, route := range routes {
+		t.routes[route] = struct{}{}
+	}
+	return t
+}
+
+// SetRetry a delivery is retried on transport errors, 429 and 5xx, waiting initialBackoff doubled on each retry up to maxBackoff
+func (t *Target) SetRetry(maxRetries int, initialBackoff, maxBackoff time.Duration) *Target {
+	t.maxRetries, t.initialBackoff, t.maxBackoff = maxRetries, initialBackoff, maxBackoff
+	return t
+}
+
+// SetTimeout the timeout of each attempt
+func (t *Target) SetTimeout(timeout time.Duration) *Target {
+	t.timeout = timeout
+	return t
+}
+
+func (t *Target) URL() string {
+	return t.url
+}
+
+func (t *Target) accepts(event *router_socket.FarmEvent) bool {
+	if _, ok := t.types[event.Type]; len(t.types) > 0 && !ok {
+		return false
+	}
+	if _, ok := t.routes[event.Route]; len(t.routes) > 0 && event.Route != "" && !ok {
+		return false
+	}
+	return true
+}
+
+func (t *Target) backoff(attempt int) time.Duration {
+	backoff := t.initialBackoff
+	for i := 1; i < attempt && backoff < t.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > t.maxBackoff {
+		backoff = t.maxBackoff
+	}
+	return backoff
+}
+
+// Sign the value of the X-Crank4go-Signature header, the HMAC covers timestamp + "." + body with the timestamp from the
+// X-Crank4go-Timestamp header. receivers compare it with hmac.Equal, and reject deliveries whose timestamp is too old
+// so that a captured delivery can not be replayed
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher posts the events of a websocketFarm to webhook targets, it is a router_socket.FarmListener.
+// each target has its own queue and worker so a slow target delays only its own deliveries, which are posted in order
+type Dispatcher struct {
+	routerHost string
+	client     *http.Client
+	workers    []*targetWorker
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+type targetWorker struct {
+	target *Target
+	queue  chan *Payload
+}
+
+func NewDispatcher(routerHost string, targets ...*Target) *Dispatcher {
+	d := &Dispatcher{routerHost: routerHost, client: &http.Client{}}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	for _, target := range targets {
+		d.workers = append(d.workers, &targetWorker{target: target, queue: make(chan *Payload, defaultQueueSize)})
+	}
+	return d
+}
+
+// SetHttpClient e.g. to trust the CA of the targets
+func (d *Dispatcher) SetHttpClient(client *http.Client) *Dispatcher {
+	d.client = client
+	return d
+}
+
+func (d *Dispatcher) Start() *Dispatcher {
+	for _, worker := range d.workers {
+		d.wg.Add(1)
+		go func(worker *targetWorker) {
+			defer d.wg.Done()
+			d.run(worker)
+		}(worker)
+	}
+	return d
+}
+
+// Stop drops the queued deliveries and waits for the ongoing ones to give up
+func (d *Dispatcher) Stop() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) AfterFarmEvent(event *router_socket.FarmEvent) {
+	var payload *Payload
+	for _, worker := range d.workers {
+		if !worker.target.accepts(event) {
+			continue
+		}
+		if payload == nil {
+			payload = &Payload{ID: uuid.New().String(), Type: string(event.Type), Time: event.Time, RouterHost: d.routerHost, Event: event}
+		}
+		select {
+		case worker.queue <- payload:
+		default:
+			util.LOG.Warningf("webhook queue of %s is full, dropping %s event %s", worker.target.url, payload.Type, payload.ID)
+		}
+	}
+}
+
+func (d *Dispatcher) run(worker *targetWorker) {
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case payload := <-worker.queue:
+			d.deliver(worker.target, payload)
+		}
+	}
+}
+
+// deliver posts the payload till it is accepted, rejected with a 4xx other than 429, or the retries are exhausted
+func (d *Dispatcher) deliver(target *Target, payload *Payload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		util.LOG.Errorf("failed to encode webhook payload %s, err: %s", payload.ID, err.Error())
+		return
+	}
+	for attempt := 0; attempt <= target.maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-d.ctx.Done():
+				return
+			case <-time.After(target.backoff(attempt)):
+			}
+		}
+		status, err := d.post(target, payload, body)
+		switch {
+		case err == nil && status < 300:
+			util.LOG.Debugf("webhook %s event %s delivered to %s", payload.Type, payload.ID, target.url)
+			return
+		case err == nil && status != http.StatusTooManyRequests && status < 500:
+			util.LOG.Warningf("webhook %s event %s rejected by %s with status %d", payload.Type, payload.ID, target.url, status)
+			return
+		case err != nil:
+			util.LOG.Warningf("failed to post webhook %s event %s to %s, attempt %d, err: %s", payload.Type, payload.ID, target.url, attempt+1, err.Error())
+		default:
+			util.LOG.Warningf("failed to post webhook %s event %s to %s, attempt %d, status: %d", payload.Type, payload.ID, target.url, attempt+1, status)
+		}
+	}
+	util.LOG.Errorf("giving up webhook %s event %s to %s after %d attempts", payload.Type, payload.ID, target.url, target.maxRetries+1)
+}
+
+func (d *Dispatcher) post(target *Target, payload *Payload, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(d.ctx, target.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", MediaType.ApplicationJson)
+	req.Header.Set(HeaderEvent, payload.Type)
+	req.Header.Set(HeaderDelivery, payload.ID)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if len(target.secret) > 0 {
+		req.Header.Set(HeaderSignature, Sign(target.secret, timestamp, body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) String() string {
+	urls := make([]string, 0, len(d.workers))
+	for _, worker := range d.workers {
+		urls = append(urls, worker.target.url)
+	}
+	return fmt.Sprintf("webhook.Dispatcher%v", urls)
+}
@@ -0,0 +1,123 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/torchcc/crank4go/router/router_socket"
+)
+
+type stub struct {
+	lock     sync.Mutex
+	statuses []int // the statuses to reply in order, 200 once they are used up
+	received []*http.Request
+	bodies   [][]byte
+}
+
+func (s *stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.received = append(s.received, r)
+	s.bodies = append(s.bodies, body)
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (s *stub) count() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.received)
+}
+
+func waitForRequests(t *testing.T, s *stub, n int) {
+	for deadline := time.Now().Add(2 * time.Second); s.count() < n; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d requests, want %d", s.count(), n)
+		}
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	secret := []byte("s3cret")
+	tests := []struct {
+		name      string
+		statuses  []int
+		wantPosts int
+	}{
+		{"delivered at once", nil, 1},
+		{"retried on 5xx and 429", []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, 3},
+		{"not retried on other 4xx", []int{http.StatusBadRequest}, 1},
+		{"given up after the retries", []int{500, 500, 500, 500}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &stub{statuses: tt.statuses}
+			server := httptest.NewServer(s)
+			defer server.Close()
+			target := NewTarget(server.URL).SetSecret(secret).
+				SetEventTypes(router_socket.RouteEmptied, router_socket.InstanceAppeared).
+				SetRoutes("order").
+				SetRetry(2, time.Millisecond, 5*time.Millisecond)
+			d := NewDispatcher("router:9070", target).Start()
+			defer d.Stop()
+
+			d.AfterFarmEvent(&router_socket.FarmEvent{Type: router_socket.SocketAdded, Route: "order"})
+			d.AfterFarmEvent(&router_socket.FarmEvent{Type: router_socket.RouteEmptied, Route: "user"})
+			d.AfterFarmEvent(&router_socket.FarmEvent{Type: router_socket.RouteEmptied, Route: "order"})
+			waitForRequests(t, s, tt.wantPosts)
+			time.Sleep(30 * time.Millisecond)
+			if s.count() != tt.wantPosts {
+				t.Fatalf("got %d posts, want %d", s.count(), tt.wantPosts)
+			}
+
+			req, body := s.received[0], s.bodies[0]
+			// what a receiver computes, the timestamp is signed so it can not be changed to replay the delivery
+			mac := hmac.New(sha256.New, secret)
+			mac.Write([]byte(req.Header.Get(HeaderTimestamp) + "." + string(body)))
+			if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); !hmac.Equal([]byte(req.Header.Get(HeaderSignature)), []byte(want)) {
+				t.Errorf("signature %s, want %s", req.Header.Get(HeaderSignature), want)
+			}
+			if timestamp, err := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64); err != nil || time.Since(time.Unix(timestamp, 0)) > time.Minute {
+				t.Errorf("invalid timestamp %s", req.Header.Get(HeaderTimestamp))
+			}
+			if Sign(secret, "0", body) == req.Header.Get(HeaderSignature) {
+				t.Errorf("the signature does not depend on the timestamp")
+			}
+			payload := &Payload{}
+			if err := json.Unmarshal(body, payload); err != nil {
+				t.Fatalf("invalid payload %s: %s", body, err)
+			}
+			if payload.Type != string(router_socket.RouteEmptied) || payload.Event.Route != "order" || payload.RouterHost != "router:9070" ||
+				req.Header.Get(HeaderEvent) != payload.Type || req.Header.Get(HeaderDelivery) != payload.ID {
+				t.Errorf("got payload %s with headers %v", body, req.Header)
+			}
+			for _, retried := range s.received[1:] {
+				if retried.Header.Get(HeaderDelivery) != payload.ID {
+					t.Errorf("a retry has another delivery id")
+				}
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	target := NewTarget("http://localhost").SetRetry(10, time.Second, 5*time.Second)
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 9: 5 * time.Second} {
+		if got := target.backoff(attempt); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package util
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ClientIp the ip of the client which sent the request. X-Forwarded-For is only read if the request comes from one of the
+// trusted proxies, from right to left skipping the trusted proxies, so a client can not spoof its ip by sending the header itself
+func ClientIp(r *http.Request, trustedProxies IpRanges) string {
+	ip := HostOf(r.RemoteAddr)
+	if len(trustedProxies) == 0 || !trustedProxies.Contains(ip) {
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			continue
+		}
+		if _, ok := ParseHostAddr(hop); !ok {
+			// a garbled hop can not be trusted, neither can the ones before it
+			break
+		}
+		ip = HostOf(hop)
+		if !trustedProxies.Contains(ip) {
+			break
+		}
+	}
+	return ip
+}
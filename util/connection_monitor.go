@@ -110,6 +110,13 @@ func (m *ConnectionMonitor) ReportWebsocketPoolSize(size int) {
 	}
 }
 
+// PublishData publishes a metric to all the data publish handlers of the monitor
+func (m *ConnectionMonitor) PublishData(key string, value int) {
+	for _, handler := range m.dataPublishHandlers {
+		handler.PublishData(key, value)
+	}
+}
+
 func (m *ConnectionMonitor) ConnectionCount() int {
 	return int(atomic.LoadInt32(&m.requestNum))
 }
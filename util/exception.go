@@ -47,3 +47,12 @@ type TimeoutErr struct {
 func (err TimeoutErr) Error() string {
 	return err.Msg
 }
+
+// OverloadErr the request is rejected because too many requests are in flight
+type OverloadErr struct {
+	Msg string
+}
+
+func (err OverloadErr) Error() string {
+	return err.Msg
+}
@@ -0,0 +1,91 @@
+package util
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// ParseIpRange parses a single ip or a CIDR range, IPv4 and IPv6 are both supported.
+// a single ip is returned as a full length prefix, e.g. 10.0.0.1/32, IPv4-mapped IPv6 addresses are unmapped
+func ParseIpRange(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if addr := prefix.Addr(); addr.Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if addr.Zone() != "" {
+		return netip.Prefix{}, errors.New("ip with zone is not supported: " + s)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// FormatIpRange formats a prefix the way it is shown to users, a single ip is shown without its prefix length
+func FormatIpRange(prefix netip.Prefix) string {
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String()
+	}
+	return prefix.String()
+}
+
+// HostOf strips the port from a remote address like 10.0.0.1:5432 or [::1]:5432, an address without port is returned as it is
+func HostOf(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(remoteAddr, "["), "]")
+}
+
+// ParseHostAddr parses the host part of a remote address, IPv4-mapped IPv6 addresses are unmapped and zones are dropped
+func ParseHostAddr(remoteAddr string) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(HostOf(remoteAddr))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// IpRanges an allowlist made of single ips and CIDR ranges
+type IpRanges []netip.Prefix
+
+// ParseIpRanges parses every entry with ParseIpRange, blank entries and entries starting with # are skipped
+func ParseIpRanges(entries []string) (IpRanges, error) {
+	ranges := make(IpRanges, 0, len(entries))
+	for _, entry := range entries {
+		if entry = strings.TrimSpace(entry); entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		prefix, err := ParseIpRange(entry)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, prefix)
+	}
+	return ranges, nil
+}
+
+// Contains judges if the host part of remoteAddr is in any of the ranges
+func (r IpRanges) Contains(remoteAddr string) bool {
+	addr, ok := ParseHostAddr(remoteAddr)
+	if !ok {
+		return false
+	}
+	for _, prefix := range r {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package util
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIpRangesContains(t *testing.T) {
+	ranges, err := ParseIpRanges([]string{"# connectors", "10.0.0.0/8", "192.168.1.7", "", "fd00::/8", "::ffff:172.16.0.0/108"})
+	if err != nil {
+		t.Fatalf("ParseIpRanges() error = %v", err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		want       bool
+	}{
+		{name: "ipv4InRangeWithPort", remoteAddr: "10.20.30.40:51234", want: true},
+		{name: "ipv4SingleWithoutPort", remoteAddr: "192.168.1.7", want: true},
+		{name: "ipv4NotInRange", remoteAddr: "192.168.1.8:80", want: false},
+		{name: "ipv6InRangeWithPort", remoteAddr: "[fd12:3456::1]:443", want: true},
+		{name: "ipv6NotInRange", remoteAddr: "[2001:db8::1]:443", want: false},
+		{name: "ipv4MappedIpv6", remoteAddr: "[::ffff:10.1.2.3]:8080", want: true},
+		{name: "unmappedRange", remoteAddr: "172.16.9.9:1", want: true},
+		{name: "garbage", remoteAddr: "not-an-ip:80", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ranges.Contains(tt.remoteAddr); got != tt.want {
+				t.Errorf("Contains(%s) = %v, want %v", tt.remoteAddr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIpRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   string
+		want    string
+		wantErr bool
+	}{
+		{name: "single", entry: "10.0.0.1", want: "10.0.0.1"},
+		{name: "masked", entry: "10.1.2.3/8", want: "10.0.0.0/8"},
+		{name: "ipv6", entry: "2001:DB8::1", want: "2001:db8::1"},
+		{name: "mapped", entry: "::ffff:10.0.0.1", want: "10.0.0.1"},
+		{name: "badOctet", entry: "10.0.0.256", wantErr: true},
+		{name: "badBits", entry: "10.0.0.0/33", wantErr: true},
+		{name: "zone", entry: "fe80::1%eth0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, err := ParseIpRange(tt.entry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseIpRange(%s) error = %v, wantErr %v", tt.entry, err, tt.wantErr)
+			}
+			if err == nil && FormatIpRange(prefix) != tt.want {
+				t.Errorf("ParseIpRange(%s) = %s, want %s", tt.entry, FormatIpRange(prefix), tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIp(t *testing.T) {
+	trusted, _ := ParseIpRanges([]string{"10.0.0.0/8", "::1"})
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		want       string
+	}{
+		{"no header", "192.168.1.2:5000", nil, "192.168.1.2"},
+		{"untrusted peer", "192.168.1.2:5000", []string{"1.2.3.4"}, "192.168.1.2"},
+		{"trusted peer", "10.0.0.1:5000", []string{"1.2.3.4"}, "1.2.3.4"},
+		{"spoofed hop on the left", "10.0.0.1:5000", []string{"6.6.6.6, 1.2.3.4, 10.0.0.2"}, "1.2.3.4"},
+		{"several headers", "[::1]:5000", []string{"6.6.6.6", "1.2.3.4"}, "1.2.3.4"},
+		{"only trusted hops", "10.0.0.1:5000", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"garbled hop", "10.0.0.1:5000", []string{"1.2.3.4, unknown"}, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &http.Request{RemoteAddr: tt.remoteAddr, Header: http.Header{}}
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := ClientIp(r, trusted); got != tt.want {
+				t.Errorf("ClientIp() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"net"
+	"sync"
+)
+
+// LimitListener accepts at most maxConns connections at the same time, Accept blocks until one of them is closed.
+// maxConns <= 0 leaves the listener unlimited
+func LimitListener(listener net.Listener, maxConns int) net.Listener {
+	if maxConns <= 0 {
+		return listener
+	}
+	return &limitListener{Listener: listener, slots: make(chan struct{}, maxConns), done: make(chan struct{})}
+}
+
+type limitListener struct {
+	net.Listener
+	slots     chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.slots <- struct{}{}:
+	case <-l.done:
+		// the error of the closed listener
+		return l.Listener.Accept()
+	}
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+	return &limitListenerConn{Conn: conn, release: func() { <-l.slots }}, nil
+}
+
+func (l *limitListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() { close(l.done) })
+	return err
+}
+
+// limitListenerConn frees its slot when it is closed, even if it is closed more than once
+type limitListenerConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func (c *limitListenerConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+	return err
+}
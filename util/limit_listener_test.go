@@ -0,0 +1,60 @@
+package util
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimitListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen, err: %s", err.Error())
+	}
+	listener := LimitListener(inner, 2)
+	defer listener.Close()
+	accepted := make(chan net.Conn, 3)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	awaitAccepted := func(want bool) net.Conn {
+		select {
+		case conn := <-accepted:
+			if !want {
+				t.Fatalf("a connection over the limit was accepted")
+			}
+			return conn
+		case <-time.After(200 * time.Millisecond):
+			if want {
+				t.Fatalf("a connection within the limit was not accepted")
+			}
+			return nil
+		}
+	}
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial, err: %s", err.Error())
+		}
+		defer conn.Close()
+	}
+
+	first := awaitAccepted(true)
+	awaitAccepted(true)
+	awaitAccepted(false)
+	_ = first.Close()
+	_ = first.Close()
+	awaitAccepted(true)
+
+	_ = listener.Close()
+	if _, ok := <-accepted; ok {
+		t.Errorf("Accept should fail once the listener is closed")
+	}
+}
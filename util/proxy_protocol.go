@@ -0,0 +1,162 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the signature every PROXY protocol v2 header starts with
+var proxyProtoV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// the longest PROXY protocol v1 header including its CRLF
+const proxyProtoV1MaxLen = 107
+
+// ProxyProtoListener accepts connections from a load balancer speaking HAProxy's PROXY protocol v1 or v2, the RemoteAddr
+// of its connections is the client's address from the header. connections from outside of trustedSources are closed
+// right away, so are connections whose header is missing or garbled once they are read.
+// the header must arrive within headerTimeout, 0 to wait for it forever
+func ProxyProtoListener(listener net.Listener, trustedSources IpRanges, headerTimeout time.Duration) net.Listener {
+	return &proxyProtoListener{Listener: listener, trustedSources: trustedSources, headerTimeout: headerTimeout}
+}
+
+type proxyProtoListener struct {
+	net.Listener
+	trustedSources IpRanges
+	headerTimeout  time.Duration
+}
+
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if !l.trustedSources.Contains(conn.RemoteAddr().String()) {
+			LOG.Warningf("rejected connection from %s, it is not a trusted source of the PROXY protocol", conn.RemoteAddr())
+			_ = conn.Close()
+			continue
+		}
+		// the header is read by the goroutine serving the connection, so a slow source does not hold up the others
+		return &proxyProtoConn{Conn: conn, reader: bufio.NewReader(conn), headerTimeout: l.headerTimeout}, nil
+	}
+}
+
+type proxyProtoConn struct {
+	net.Conn
+	reader        *bufio.Reader
+	headerTimeout time.Duration
+	once          sync.Once
+	remoteAddr    net.Addr // nil if the header carries no address, e.g. a health check of the load balancer
+	err           error
+}
+
+func (c *proxyProtoConn) Read(buf []byte) (int, error) {
+	if c.readHeader(); c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(buf)
+}
+
+// RemoteAddr the client's address, or the load balancer's one if the header carries none
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	if c.readHeader(); c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyProtoConn) readHeader() {
+	c.once.Do(func() {
+		if c.headerTimeout > 0 {
+			_ = c.Conn.SetReadDeadline(time.Now().Add(c.headerTimeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+		if c.remoteAddr, c.err = readProxyProtoHeader(c.reader); c.err != nil {
+			LOG.Warningf("failed to read the PROXY protocol header from %s, err: %s", c.Conn.RemoteAddr(), c.err.Error())
+		}
+	})
+}
+
+// readProxyProtoHeader the source address of a v1 or v2 header, nil for v1 UNKNOWN and v2 LOCAL
+func readProxyProtoHeader(reader *bufio.Reader) (net.Addr, error) {
+	signature, err := reader.Peek(len(proxyProtoV2Signature))
+	if err != nil && !(err == io.EOF && len(signature) > 0) {
+		return nil, err
+	}
+	if bytes.Equal(signature, proxyProtoV2Signature) {
+		return readProxyProtoV2(reader)
+	}
+	if bytes.HasPrefix(signature, []byte("PROXY ")) {
+		return readProxyProtoV1(reader)
+	}
+	return nil, errors.New("PROXY protocol header is missing")
+}
+
+// readProxyProtoV1 e.g. PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n
+func readProxyProtoV1(reader *bufio.Reader) (net.Addr, error) {
+	line := make([]byte, 0, proxyProtoV1MaxLen)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == proxyProtoV1MaxLen {
+			return nil, errors.New("PROXY protocol v1 header is too long")
+		}
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 header %q", strings.TrimSpace(string(line)))
+	}
+	srcIp := net.ParseIP(fields[2])
+	srcPort, err := strconv.ParseUint(fields[4], 10, 16)
+	if srcIp == nil || err != nil || (srcIp.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("invalid source %s %s in PROXY protocol v1 header", fields[2], fields[4])
+	}
+	return &net.TCPAddr{IP: srcIp, Port: int(srcPort)}, nil
+}
+
+// readProxyProtoV2 the binary header, its TLVs are skipped
+func readProxyProtoV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	if version := header[12] >> 4; version != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", version)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+	switch command := header[12] & 0x0f; command {
+	case 0x0: // LOCAL, the connection was made by the load balancer itself
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("unsupported PROXY protocol v2 command %d", command)
+	}
+	switch family := header[13] >> 4; {
+	case family == 0x1 && len(body) >= 12:
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case family == 0x2 && len(body) >= 36:
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	case family == 0x0:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported PROXY protocol v2 address family %d with %d address bytes", family, len(body))
+	}
+}
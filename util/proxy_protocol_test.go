@@ -0,0 +1,111 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func proxyProtoV2Header(command, family byte, addresses []byte) []byte {
+	header := append([]byte{}, proxyProtoV2Signature...)
+	header = append(header, 0x20|command, family<<4|0x1, 0, 0)
+	binary.BigEndian.PutUint16(header[14:16], uint16(len(addresses)))
+	return append(header, addresses...)
+}
+
+func TestReadProxyProtoHeader(t *testing.T) {
+	v4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+	v6 := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0xdc, 0x04, 0x01, 0xbb)
+	tests := []struct {
+		name     string
+		header   []byte
+		wantAddr string
+		wantErr  bool
+	}{
+		{name: "v1 tcp4", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"), wantAddr: "192.0.2.1:56324"},
+		{name: "v1 tcp6", header: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"), wantAddr: "[2001:db8::1]:56324"},
+		{name: "v1 unknown", header: []byte("PROXY UNKNOWN\r\n")},
+		{name: "v1 family mismatch", header: []byte("PROXY TCP4 2001:db8::1 2001:db8::2 56324 443\r\n"), wantErr: true},
+		{name: "v1 too long", header: append([]byte("PROXY TCP4 "), bytes.Repeat([]byte("1"), 120)...), wantErr: true},
+		{name: "v2 tcp4", header: proxyProtoV2Header(0x1, 0x1, v4), wantAddr: "192.0.2.1:56324"},
+		{name: "v2 tcp6 with tlv", header: proxyProtoV2Header(0x1, 0x2, append(v6, 0x04, 0x00, 0x01, 0xff)), wantAddr: "[2001:db8::1]:56324"},
+		{name: "v2 local", header: proxyProtoV2Header(0x0, 0x0, nil)},
+		{name: "v2 short addresses", header: proxyProtoV2Header(0x1, 0x1, v4[:8]), wantErr: true},
+		{name: "missing", header: []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReader(bytes.NewReader(append(tt.header, "GET /"...)))
+			addr, err := readProxyProtoHeader(reader)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want an error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if gotAddr := ""; addr != nil {
+				if gotAddr = addr.String(); gotAddr != tt.wantAddr {
+					t.Errorf("addr = %s, want %s", gotAddr, tt.wantAddr)
+				}
+			} else if tt.wantAddr != "" {
+				t.Errorf("addr = nil, want %s", tt.wantAddr)
+			}
+			if rest, _ := io.ReadAll(reader); string(rest) != "GET /" {
+				t.Errorf("the data after the header = %q, want it untouched", rest)
+			}
+		})
+	}
+}
+
+func TestProxyProtoListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen, err: %s", err.Error())
+	}
+	accept := func(trusted string) (net.Conn, net.Conn) {
+		trustedSources, _ := ParseIpRanges([]string{trusted})
+		listener := ProxyProtoListener(inner, trustedSources, time.Second)
+		client, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial, err: %s", err.Error())
+		}
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			if conn, err := listener.Accept(); err == nil {
+				accepted <- conn
+			}
+		}()
+		select {
+		case conn := <-accepted:
+			return client, conn
+		case <-time.After(200 * time.Millisecond):
+			return client, nil
+		}
+	}
+
+	client, conn := accept("127.0.0.1")
+	_, _ = client.Write([]byte("PROXY TCP4 192.0.2.1 127.0.0.1 56324 443\r\nhello"))
+	if got := conn.RemoteAddr().String(); got != "192.0.2.1:56324" {
+		t.Errorf("RemoteAddr() = %s, want the client from the header", got)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("read %q, err: %v, want the data after the header", buf, err)
+	}
+	_ = conn.Close()
+	_ = client.Close()
+
+	client, conn = accept("10.0.0.0/8")
+	if conn != nil {
+		t.Errorf("a connection from an untrusted source was accepted")
+	}
+	if _, err := client.Read(buf); err == nil {
+		t.Errorf("the connection from an untrusted source should be closed")
+	}
+	_ = client.Close()
+	_ = inner.Close()
+}
@@ -3,5 +3,5 @@ package util
 const (
 	UUI31223NotSendingHostHeader      = false
 	UUI51288AllowFixedLengthResponses = false
-	UUI51292EnableResponseTimeouts    = false
+	UUI51292EnableResponseTimeouts    = true
 )